@@ -0,0 +1,243 @@
+// Package client is a typed client of the coffee shop REST api (/v1 routes)
+package client
+
+import (
+	"CoffeeShop/coffeedb"
+	"CoffeeShop/shopapi"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const defaultTimeout = 10 * time.Second
+const defaultRetries = 2
+const defaultRetryWait = 100 * time.Millisecond
+
+// Client calls the api of one shop, it is safe for concurrent use
+type Client struct {
+	baseUrl    string
+	httpClient *http.Client
+	timeout    *time.Duration
+	retries    int
+	retryWait  time.Duration
+}
+
+// Option changes a default of New
+type Option func(*Client)
+
+// WithHttpClient uses a copy of httpClient for all requests, its timeout
+// is kept unless WithTimeout is given
+func WithHttpClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithTimeout limits every attempt of a call to timeout, 10 seconds by default
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		c.timeout = &timeout
+	}
+}
+
+// WithRetries sets how often idempotent calls are retried after network
+// errors and 502, 503 and 504 responses, wait doubles after every attempt
+func WithRetries(retries int, wait time.Duration) Option {
+	return func(c *Client) {
+		c.retries = retries
+		c.retryWait = wait
+	}
+}
+
+// New creates a client of the shop at baseUrl, for example "http://localhost:8080"
+func New(baseUrl string, opts ...Option) *Client {
+	c := &Client{
+		baseUrl:   strings.TrimSuffix(baseUrl, "/"),
+		retries:   defaultRetries,
+		retryWait: defaultRetryWait,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	//the caller's client, for example http.DefaultClient, is never changed
+	httpClient := http.Client{Timeout: defaultTimeout}
+	if c.httpClient != nil {
+		httpClient = *c.httpClient
+	}
+	if c.timeout != nil {
+		httpClient.Timeout = *c.timeout
+	}
+	c.httpClient = &httpClient
+	return c
+}
+
+func userPath(userId string) string {
+	return "/v1/users/" + url.PathEscape(userId)
+}
+
+// Register registers a new user and returns the user's quota status
+func (c *Client) Register(ctx context.Context, userId string, membership coffeedb.MembershipType) (*shopapi.UserQuotaStatus, error) {
+	var status shopapi.UserQuotaStatus
+	err := c.do(ctx, "POST", "/v1/users", shopapi.UserRegister{UserId: userId, Membership: membership}, &status, "")
+	if err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// Buy buys a coffee for the user and returns the updated quota status,
+// the error is *QuotaExceededError if the quota is exhausted, the purchase
+// is sent with a new Idempotency-Key so retries never buy twice
+func (c *Client) Buy(ctx context.Context, userId string, coffee coffeedb.CoffeeType) (*shopapi.UserQuotaStatus, error) {
+	return c.BuyWithKey(ctx, uuid.New().String(), userId, coffee)
+}
+
+// BuyWithKey is Buy with the caller's Idempotency-Key, calls with the same
+// key and purchase get the result of the first one
+func (c *Client) BuyWithKey(ctx context.Context, idempotencyKey string, userId string, coffee coffeedb.CoffeeType) (*shopapi.UserQuotaStatus, error) {
+	var status shopapi.UserQuotaStatus
+	err := c.do(ctx, "POST", userPath(userId)+"/purchases", shopapi.PurchaseRequest{Coffee: coffee}, &status, idempotencyKey)
+	if err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// Status returns the quota status of the user
+func (c *Client) Status(ctx context.Context, userId string) (*shopapi.UserQuotaStatus, error) {
+	var status shopapi.UserQuotaStatus
+	if err := c.do(ctx, "GET", userPath(userId), nil, &status, ""); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// History returns all purchases of the user
+func (c *Client) History(ctx context.Context, userId string) ([]coffeedb.Purchase, error) {
+	var purchases []coffeedb.Purchase
+	if err := c.do(ctx, "GET", userPath(userId)+"/purchases", nil, &purchases, ""); err != nil {
+		return nil, err
+	}
+	return purchases, nil
+}
+
+// Export returns all data held about the user
+func (c *Client) Export(ctx context.Context, userId string) (*shopapi.UserExport, error) {
+	var export shopapi.UserExport
+	if err := c.do(ctx, "GET", userPath(userId)+"/export", nil, &export, ""); err != nil {
+		return nil, err
+	}
+	return &export, nil
+}
+
+// Delete removes the user, it is not retried because a retry
+// after a lost response would fail with ErrUserNotFound
+func (c *Client) Delete(ctx context.Context, userId string) error {
+	return c.do(ctx, "DELETE", userPath(userId), nil, nil, "")
+}
+
+// do sends the request with body as json and decodes the response into out,
+// GET requests and requests with an idempotency key are retried
+func (c *Client) do(ctx context.Context, method string, path string, body interface{}, out interface{}, idempotencyKey string) error {
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+	attempts := 1
+	if method == "GET" || len(idempotencyKey) != 0 {
+		attempts += c.retries
+	}
+	wait := c.retryWait
+	for attempt := 1; ; attempt++ {
+		resp, err := c.send(ctx, method, path, data, idempotencyKey)
+		retry := attempt < attempts && ctx.Err() == nil && (err != nil || retryStatus(resp.StatusCode))
+		if !retry {
+			if err != nil {
+				return err
+			}
+			return decodeResponse(resp, out)
+		}
+		if resp != nil {
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+}
+
+func (c *Client) send(ctx context.Context, method string, path string, data []byte, idempotencyKey string) (*http.Response, error) {
+	var body io.Reader
+	if data != nil {
+		body = bytes.NewReader(data)
+	}
+	request, err := http.NewRequestWithContext(ctx, method, c.baseUrl+path, body)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Accept", "application/json")
+	if data != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	if len(idempotencyKey) != 0 {
+		request.Header.Set("Idempotency-Key", idempotencyKey)
+	}
+	return c.httpClient.Do(request)
+}
+
+func retryStatus(status int) bool {
+	return status == http.StatusBadGateway || status == http.StatusServiceUnavailable || status == http.StatusGatewayTimeout
+}
+
+// decodeResponse decodes a 2xx body into out or returns the error of the response
+func decodeResponse(resp *http.Response, out interface{}) error {
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		if out == nil || resp.StatusCode == http.StatusNoContent {
+			return nil
+		}
+		return json.Unmarshal(data, out)
+	}
+
+	var errResp struct {
+		Code    string          `json:"code"`
+		Message string          `json:"message"`
+		Details json.RawMessage `json:"details"`
+	}
+	if err := json.Unmarshal(data, &errResp); err != nil || len(errResp.Code) == 0 {
+		return &Error{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(data))}
+	}
+	if errResp.Code == shopapi.CodeQuotaExceeded {
+		quotaErr := QuotaExceededError{Message: errResp.Message}
+		if err := json.Unmarshal(errResp.Details, &quotaErr.Limit); err != nil {
+			return errors.New("invalid quota exceeded details: " + err.Error())
+		}
+		quotaErr.RetryAfter = time.Duration(quotaErr.Limit.AvailableIn) * time.Second
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			quotaErr.RetryAfter = time.Duration(seconds) * time.Second
+		}
+		return &quotaErr
+	}
+	return &Error{StatusCode: resp.StatusCode, Code: errResp.Code, Message: errResp.Message}
+}
@@ -0,0 +1,135 @@
+package client
+
+import (
+	"CoffeeShop/coffeedb"
+	"CoffeeShop/shopapi"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func shopHandler(t *testing.T) http.Handler {
+	db, err := coffeedb.Init(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return shopapi.NewShop(db, shopapi.DefaultConfig(), nil).Handler()
+}
+
+func TestClient(t *testing.T) {
+	t.Parallel()
+	srv := httptest.NewServer(shopHandler(t))
+	defer srv.Close()
+	c := New(srv.URL)
+	ctx := context.Background()
+
+	userId := "c3e5a7b9-0d2f-4b6a-8c1e-3f5a7b9d1e3c"
+	status, err := c.Register(ctx, userId, coffeedb.Basic)
+	if err != nil || status.UserId != userId || len(status.Coffees) != 3 {
+		t.Fatalf("register user: %+v %v", status, err)
+	}
+	if _, err := c.Register(ctx, userId, coffeedb.Basic); !errors.Is(err, ErrUserExists) {
+		t.Errorf("expected ErrUserExists got %v", err)
+	}
+	if _, err := c.Buy(ctx, userId, coffeedb.Espresso); err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.Buy(ctx, userId, coffeedb.Espresso)
+	var quotaErr *QuotaExceededError
+	if !errors.As(err, &quotaErr) || quotaErr.Limit.Type != coffeedb.Espresso || quotaErr.Limit.AmountBought != 1 || quotaErr.RetryAfter <= 0 {
+		t.Fatalf("expected QuotaExceededError got %v", err)
+	}
+	if _, err := c.Buy(ctx, userId, coffeedb.CoffeeType(9)); !errors.Is(err, ErrInvalidCoffeeType) {
+		t.Errorf("expected ErrInvalidCoffeeType got %v", err)
+	}
+	purchases, err := c.History(ctx, userId)
+	if err != nil || len(purchases) != 1 {
+		t.Errorf("history: %v %v", purchases, err)
+	}
+	if export, err := c.Export(ctx, userId); err != nil || export.UserId != userId {
+		t.Errorf("export: %+v %v", export, err)
+	}
+	if err := c.Delete(ctx, userId); err != nil {
+		t.Fatal(err)
+	}
+	var apiErr *Error
+	if _, err := c.Status(ctx, userId); !errors.Is(err, ErrUserNotFound) || !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound {
+		t.Errorf("expected ErrUserNotFound got %v", err)
+	}
+}
+
+func TestClientRetries(t *testing.T) {
+	t.Parallel()
+	handler := shopHandler(t)
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if atomic.AddInt32(&calls, 1)%3 != 0 {
+			writer.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		handler.ServeHTTP(writer, request)
+	}))
+	defer srv.Close()
+
+	c := New(srv.URL, WithRetries(2, time.Millisecond))
+	ctx := context.Background()
+	if _, err := c.Status(ctx, "unknown"); !errors.Is(err, ErrUserNotFound) || atomic.LoadInt32(&calls) != 3 {
+		t.Errorf("get not retried: %d calls, %v", atomic.LoadInt32(&calls), err)
+	}
+	atomic.StoreInt32(&calls, 0)
+	var apiErr *Error
+	if _, err := c.Register(ctx, "user", coffeedb.Basic); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusServiceUnavailable || atomic.LoadInt32(&calls) != 1 {
+		t.Errorf("post retried: %d calls, %v", atomic.LoadInt32(&calls), err)
+	}
+
+	atomic.StoreInt32(&calls, 0)
+	if _, err := c.Register(ctx, "buyer", coffeedb.Basic); err == nil {
+		t.Fatal("expected 503 on the first call")
+	}
+	atomic.StoreInt32(&calls, 2)
+	if _, err := c.Register(ctx, "buyer", coffeedb.Basic); err != nil {
+		t.Fatal(err)
+	}
+	atomic.StoreInt32(&calls, 0)
+	status, err := c.Buy(ctx, "buyer", coffeedb.Espresso)
+	if err != nil || atomic.LoadInt32(&calls) != 3 || status.Coffees[0].Remaining != 0 {
+		t.Errorf("buy not retried with an idempotency key: %d calls, %v", atomic.LoadInt32(&calls), err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	slow := New(srv.URL, WithRetries(10, time.Second))
+	start := time.Now()
+	if _, err := slow.Status(ctx, "unknown"); !errors.Is(err, context.DeadlineExceeded) || time.Since(start) > time.Second {
+		t.Errorf("retries did not stop at the deadline: %v", err)
+	}
+}
+
+func TestClientOptions(t *testing.T) {
+	t.Parallel()
+	if c := New("http://localhost"); c.httpClient.Timeout != defaultTimeout {
+		t.Errorf("expected default timeout %s got %s", defaultTimeout, c.httpClient.Timeout)
+	}
+	callerClient := &http.Client{Timeout: time.Minute}
+	if c := New("http://localhost", WithHttpClient(callerClient)); c.httpClient.Timeout != time.Minute {
+		t.Errorf("timeout of the caller's client not kept, got %s", c.httpClient.Timeout)
+	}
+	for _, opts := range [][]Option{
+		{WithTimeout(time.Second), WithHttpClient(callerClient)},
+		{WithHttpClient(callerClient), WithTimeout(time.Second)},
+		{WithTimeout(time.Second), WithHttpClient(http.DefaultClient)},
+		{WithHttpClient(http.DefaultClient), WithTimeout(time.Second)},
+	} {
+		if c := New("http://localhost", opts...); c.httpClient.Timeout != time.Second {
+			t.Errorf("expected timeout %s got %s", time.Second, c.httpClient.Timeout)
+		}
+	}
+	if callerClient.Timeout != time.Minute || http.DefaultClient.Timeout != 0 {
+		t.Errorf("caller's client changed, timeouts %s and %s", callerClient.Timeout, http.DefaultClient.Timeout)
+	}
+}
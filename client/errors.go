@@ -0,0 +1,43 @@
+package client
+
+import (
+	"CoffeeShop/shopapi"
+	"fmt"
+	"time"
+)
+
+// Error error response of the api, Code is one of shopapi Code* constants
+type Error struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("coffee shop api: %d %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// Is reports whether target is an *Error with the same code,
+// so errors.Is(err, client.ErrUserNotFound) works for any status
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+var ErrUserNotFound = &Error{Code: shopapi.CodeUserNotFound}
+var ErrUserExists = &Error{Code: shopapi.CodeUserExists}
+var ErrUserSuspended = &Error{Code: shopapi.CodeUserSuspended}
+var ErrInvalidCoffeeType = &Error{Code: shopapi.CodeInvalidCoffeeType}
+var ErrInvalidMembership = &Error{Code: shopapi.CodeInvalidMembership}
+
+// QuotaExceededError quota of the coffee type is exhausted, RetryAfter is
+// the time until the next coffee of the type can be bought
+type QuotaExceededError struct {
+	Limit      shopapi.CoffeeLimitExceed
+	RetryAfter time.Duration
+	Message    string
+}
+
+func (e *QuotaExceededError) Error() string {
+	return "coffee shop api: " + e.Message
+}
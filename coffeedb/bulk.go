@@ -0,0 +1,242 @@
+package coffeedb
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// bulk file formats
+const (
+	FormatCsv   = "csv"
+	FormatJsonl = "jsonl"
+)
+
+var csvMembersHeader = []string{"user_id", "membership", "quota_state"}
+
+// MemberRecord one row of a bulk import or export, in CSV files
+// quota_state is a JSON object in the same form as in JSONL files
+type MemberRecord struct {
+	UserId     string                         `json:"user_id"`
+	Membership MembershipType                 `json:"membership"`
+	QuotaState map[CoffeeType]UserCoffeeQuota `json:"quota_state,omitempty"`
+}
+
+// ImportRowError row which was not imported, rows are counted from 1
+// without the CSV header
+type ImportRowError struct {
+	Row    int    `json:"row"`
+	UserId string `json:"user_id,omitempty"`
+	Error  string `json:"error"`
+}
+
+// ImportReport result of ImportMembers
+type ImportReport struct {
+	Total    int              `json:"total"`
+	Imported int              `json:"imported"`
+	Failed   []ImportRowError `json:"failed"`
+}
+
+func (rec *MemberRecord) validate() error {
+	if len(rec.UserId) == 0 {
+		return errors.New("empty user id")
+	}
+	if !ValidUserId(rec.UserId) {
+		return errors.New("invalid user id")
+	}
+	if err := rec.Membership.IsValid(); err != nil {
+		return err
+	}
+	for coffee := range rec.QuotaState {
+		if err := coffee.IsValid(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// importUser registers the user with the given quota state in one write
+func (db *CoffeeDb) importUser(rec *MemberRecord) error {
+	lock := db.userLock(rec.UserId)
+	lock.Lock()
+	defer lock.Unlock()
+	if _, err := db.loadUserData(rec.UserId); err == nil {
+		return ErrUserExists
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	userData := UserCoffeeMembership{Membership: rec.Membership, QuotaState: make(map[CoffeeType]UserCoffeeQuota)}
+	for coffee, qs := range rec.QuotaState {
+		userData.QuotaState[coffee] = qs
+	}
+	if err := db.saveUserData(walRegister, rec.UserId, &userData); err != nil {
+		return err
+	}
+	db.users.put(rec.UserId, userData)
+	return nil
+}
+
+// memberReader returns the next record of the file or the error of an
+// invalid row, reading goes on with the next row after a row error
+type memberReader func() (*MemberRecord, *ImportRowError, error)
+
+func csvMemberReader(r io.Reader) memberReader {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	row := 0
+	return func() (*MemberRecord, *ImportRowError, error) {
+		fields, err := reader.Read()
+		if err != nil {
+			if _, ok := err.(*csv.ParseError); ok && row != 0 {
+				row++
+				return nil, &ImportRowError{Row: row, Error: err.Error()}, nil
+			}
+			return nil, nil, err
+		}
+		if row == 0 && len(fields) != 0 && fields[0] == csvMembersHeader[0] {
+			fields, err = reader.Read()
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+		row++
+		rec := MemberRecord{UserId: fields[0]}
+		if len(fields) < 2 || len(fields) > len(csvMembersHeader) {
+			return nil, &ImportRowError{Row: row, UserId: rec.UserId, Error: fmt.Sprintf("expected 2 or 3 fields, got %d", len(fields))}, nil
+		}
+		if err := rec.Membership.UnmarshalText([]byte(fields[1])); err != nil {
+			return nil, &ImportRowError{Row: row, UserId: rec.UserId, Error: err.Error()}, nil
+		}
+		if len(fields) == 3 && len(fields[2]) != 0 {
+			if err := json.Unmarshal([]byte(fields[2]), &rec.QuotaState); err != nil {
+				return nil, &ImportRowError{Row: row, UserId: rec.UserId, Error: "quota_state: " + err.Error()}, nil
+			}
+		}
+		return &rec, nil, nil
+	}
+}
+
+func jsonlMemberReader(r io.Reader) memberReader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1024*1024)
+	row := 0
+	return func() (*MemberRecord, *ImportRowError, error) {
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if len(line) == 0 {
+				continue
+			}
+			row++
+			var rec MemberRecord
+			if err := json.Unmarshal([]byte(line), &rec); err != nil {
+				return nil, &ImportRowError{Row: row, Error: err.Error()}, nil
+			}
+			return &rec, nil, nil
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, nil, err
+		}
+		return nil, nil, io.EOF
+	}
+}
+
+// ImportMembers registers all users of a CSV or JSONL file, invalid rows and
+// users registered already are reported and skipped, an error is returned
+// only if the file can't be read further
+func (db *CoffeeDb) ImportMembers(r io.Reader, format string) (*ImportReport, error) {
+	var next memberReader
+	switch format {
+	case FormatCsv:
+		next = csvMemberReader(r)
+	case FormatJsonl:
+		next = jsonlMemberReader(r)
+	default:
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+	report := ImportReport{Failed: []ImportRowError{}}
+	for {
+		rec, rowErr, err := next()
+		if err == io.EOF {
+			return &report, nil
+		}
+		if err != nil {
+			return &report, err
+		}
+		report.Total++
+		if rowErr != nil {
+			report.Failed = append(report.Failed, *rowErr)
+			continue
+		}
+		err = rec.validate()
+		if err == nil {
+			err = db.importUser(rec)
+		}
+		if err != nil {
+			report.Failed = append(report.Failed, ImportRowError{Row: report.Total, UserId: rec.UserId, Error: err.Error()})
+			continue
+		}
+		report.Imported++
+	}
+}
+
+// ExportMembers writes all users sorted by id in the format read by ImportMembers
+func (db *CoffeeDb) ExportMembers(w io.Writer, format string) error {
+	var write func(rec *MemberRecord) error
+	var csvWriter *csv.Writer
+	switch format {
+	case FormatCsv:
+		csvWriter = csv.NewWriter(w)
+		if err := csvWriter.Write(csvMembersHeader); err != nil {
+			return err
+		}
+		write = func(rec *MemberRecord) error {
+			quotaState := ""
+			if len(rec.QuotaState) != 0 {
+				data, err := json.Marshal(storedQuotaState(rec.QuotaState))
+				if err != nil {
+					return err
+				}
+				quotaState = string(data)
+			}
+			return csvWriter.Write([]string{rec.UserId, strconv.Itoa(int(rec.Membership)), quotaState})
+		}
+	case FormatJsonl:
+		encoder := json.NewEncoder(w)
+		write = func(rec *MemberRecord) error {
+			return encoder.Encode(rec.stored())
+		}
+	default:
+		return fmt.Errorf("unknown format %q", format)
+	}
+
+	q := UserQuery{Limit: 1000}
+	for {
+		page, more := db.ListUsers(&q)
+		for _, summary := range page {
+			userData := db.GetUserData(summary.UserId)
+			if userData == nil {
+				continue
+			}
+			rec := MemberRecord{UserId: summary.UserId, Membership: userData.Membership, QuotaState: userData.QuotaState}
+			if err := write(&rec); err != nil {
+				return err
+			}
+		}
+		if !more {
+			break
+		}
+		q.After = page[len(page)-1].UserId
+	}
+	if csvWriter != nil {
+		csvWriter.Flush()
+		return csvWriter.Error()
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package coffeedb
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// CacheStats counters of the in-memory users cache
+// Capacity is zero for an unbounded cache
+type CacheStats struct {
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"`
+	Size      int    `json:"size"`
+	Capacity  int    `json:"capacity"`
+}
+
+type cacheEntry struct {
+	userId   string
+	userData UserCoffeeMembership
+	loadedAt time.Time
+}
+
+// userCache keeps recently used users in memory, least recently used users
+// are evicted when capacity is reached and users older than ttl are reloaded
+// from storage, storage stays authoritative
+type userCache struct {
+	capacity  int
+	ttl       time.Duration
+	items     map[string]*list.Element
+	order     *list.List
+	hits      uint64
+	misses    uint64
+	evictions uint64
+	lock      sync.Mutex
+}
+
+// newUserCache capacity <= 0 means unbounded, ttl <= 0 means no expiry
+func newUserCache(capacity int, ttl time.Duration) *userCache {
+	return &userCache{capacity: capacity, ttl: ttl, items: make(map[string]*list.Element), order: list.New()}
+}
+
+func (c *userCache) get(userId string) (UserCoffeeMembership, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	el, ok := c.items[userId]
+	if !ok {
+		c.misses++
+		return UserCoffeeMembership{}, false
+	}
+	entry := el.Value.(*cacheEntry)
+	if c.ttl > 0 && time.Since(entry.loadedAt) > c.ttl {
+		c.order.Remove(el)
+		delete(c.items, userId)
+		c.misses++
+		return UserCoffeeMembership{}, false
+	}
+	c.order.MoveToFront(el)
+	c.hits++
+	return entry.userData, true
+}
+
+func (c *userCache) put(userId string, userData UserCoffeeMembership) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if el, ok := c.items[userId]; ok {
+		entry := el.Value.(*cacheEntry)
+		entry.userData = userData
+		entry.loadedAt = time.Now()
+		c.order.MoveToFront(el)
+		return
+	}
+	c.items[userId] = c.order.PushFront(&cacheEntry{userId: userId, userData: userData, loadedAt: time.Now()})
+	for c.capacity > 0 && c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*cacheEntry).userId)
+		c.evictions++
+	}
+}
+
+func (c *userCache) remove(userId string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if el, ok := c.items[userId]; ok {
+		c.order.Remove(el)
+		delete(c.items, userId)
+	}
+}
+
+func (c *userCache) clear() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.items = make(map[string]*list.Element)
+	c.order.Init()
+}
+
+func (c *userCache) stats() CacheStats {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return CacheStats{Hits: c.hits, Misses: c.misses, Evictions: c.evictions, Size: c.order.Len(), Capacity: c.capacity}
+}
@@ -4,14 +4,19 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"hash/fnv"
 	"io/ioutil"
 	"os"
-	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 )
 
 type MembershipType uint8
 type CoffeeType uint8
+type UserStatusType uint8
 
 const (
 	Basic MembershipType = iota + 1
@@ -25,8 +30,87 @@ const (
 	Cappuccino
 )
 
+// Active is the zero value so records stored without status are active
+const (
+	Active UserStatusType = iota
+	Suspended
+	Banned
+)
+
+var ErrInvalidMembership = errors.New("invalid membership type")
+var ErrInvalidCoffeeType = errors.New("invalid coffee type")
+var ErrInvalidUserStatus = errors.New("invalid user status")
+
+var membershipNames = [...]string{"Basic", "Coffee Lover", "Espresso Maniac"}
+var coffeeNames = [...]string{"Espresso", "Americano", "Cappuccino"}
+var userStatusNames = [...]string{"Active", "Suspended", "Banned"}
+
+// enumName returns names[v-first] or "typeName(v)" if v is out of range
+func enumName(names []string, first uint8, v uint8, typeName string) string {
+	if v < first || int(v-first) >= len(names) {
+		return typeName + "(" + strconv.Itoa(int(v)) + ")"
+	}
+	return names[v-first]
+}
+
+// parseEnum returns the value of a name (case-insensitive, spaces,
+// underscores and dashes ignored) or of a number
+func parseEnum(names []string, first uint8, text string) (uint8, bool) {
+	if n, err := strconv.ParseUint(text, 10, 8); err == nil {
+		return uint8(n), true
+	}
+	normalize := strings.NewReplacer(" ", "", "_", "", "-", "")
+	text = normalize.Replace(text)
+	for i, name := range names {
+		if strings.EqualFold(normalize.Replace(name), text) {
+			return first + uint8(i), true
+		}
+	}
+	return 0, false
+}
+
+// unmarshalEnumJSON unmarshals a bare number or a string with parse,
+// null is ignored like for other JSON values
+func unmarshalEnumJSON(data []byte, parse func(text []byte) error) error {
+	var text string
+	if string(data) == "null" {
+		return nil
+	}
+	if len(data) != 0 && data[0] != '"' {
+		text = string(data)
+	} else if err := json.Unmarshal(data, &text); err != nil {
+		return err
+	}
+	return parse([]byte(text))
+}
+
 func (m MembershipType) String() string {
-	return [...]string{"Basic", "Coffee Lover", "Espresso Maniac"}[m-1]
+	return enumName(membershipNames[:], uint8(Basic), uint8(m), "MembershipType")
+}
+
+// MarshalText writes the name, it is used for JSON values and map keys,
+// invalid values like an unset zero are written as numbers
+func (m MembershipType) MarshalText() ([]byte, error) {
+	if m.IsValid() != nil {
+		return []byte(strconv.Itoa(int(m))), nil
+	}
+	return []byte(m.String()), nil
+}
+
+// UnmarshalText accepts names case-insensitively and numbers, 0 is kept
+// as not set
+func (m *MembershipType) UnmarshalText(text []byte) error {
+	v, ok := parseEnum(membershipNames[:], uint8(Basic), string(text))
+	if !ok || v != 0 && MembershipType(v).IsValid() != nil {
+		return fmt.Errorf("%w %q, expected one of %s", ErrInvalidMembership, text, strings.Join(membershipNames[:], ", "))
+	}
+	*m = MembershipType(v)
+	return nil
+}
+
+// UnmarshalJSON accepts a name or a bare number
+func (m *MembershipType) UnmarshalJSON(data []byte) error {
+	return unmarshalEnumJSON(data, m.UnmarshalText)
 }
 
 func (m MembershipType) EnumIndex() uint8 {
@@ -34,19 +118,79 @@ func (m MembershipType) EnumIndex() uint8 {
 }
 
 func (c CoffeeType) String() string {
-	return [...]string{"Espresso", "Americano", "Cappuccino"}[c-1]
+	return enumName(coffeeNames[:], uint8(Espresso), uint8(c), "CoffeeType")
+}
+
+// MarshalText writes the name, it is used for JSON values and map keys,
+// invalid values like an unset zero are written as numbers
+func (c CoffeeType) MarshalText() ([]byte, error) {
+	if c.IsValid() != nil {
+		return []byte(strconv.Itoa(int(c))), nil
+	}
+	return []byte(c.String()), nil
+}
+
+// UnmarshalText accepts names case-insensitively and numbers, 0 is kept
+// as not set
+func (c *CoffeeType) UnmarshalText(text []byte) error {
+	v, ok := parseEnum(coffeeNames[:], uint8(Espresso), string(text))
+	if !ok || v != 0 && CoffeeType(v).IsValid() != nil {
+		return fmt.Errorf("%w %q, expected one of %s", ErrInvalidCoffeeType, text, strings.Join(coffeeNames[:], ", "))
+	}
+	*c = CoffeeType(v)
+	return nil
+}
+
+// UnmarshalJSON accepts a name or a bare number
+func (c *CoffeeType) UnmarshalJSON(data []byte) error {
+	return unmarshalEnumJSON(data, c.UnmarshalText)
 }
 
 func (c CoffeeType) EnumIndex() uint8 {
 	return uint8(c)
 }
 
+func (s UserStatusType) String() string {
+	return enumName(userStatusNames[:], uint8(Active), uint8(s), "UserStatusType")
+}
+
+// MarshalText writes the name, invalid values are written as numbers
+func (s UserStatusType) MarshalText() ([]byte, error) {
+	if s.IsValid() != nil {
+		return []byte(strconv.Itoa(int(s))), nil
+	}
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText accepts names case-insensitively and numbers
+func (s *UserStatusType) UnmarshalText(text []byte) error {
+	v, ok := parseEnum(userStatusNames[:], uint8(Active), string(text))
+	if !ok || UserStatusType(v).IsValid() != nil {
+		return fmt.Errorf("%w %q, expected one of %s", ErrInvalidUserStatus, text, strings.Join(userStatusNames[:], ", "))
+	}
+	*s = UserStatusType(v)
+	return nil
+}
+
+// UnmarshalJSON accepts a name or a bare number
+func (s *UserStatusType) UnmarshalJSON(data []byte) error {
+	return unmarshalEnumJSON(data, s.UnmarshalText)
+}
+
+func (s UserStatusType) IsValid() error {
+	switch s {
+	case Active, Suspended, Banned:
+		return nil
+	}
+	return ErrInvalidUserStatus
+}
+
 func (ct CoffeeType) IsValid() error {
 	switch ct {
 	case Espresso, Americano, Cappuccino:
 		return nil
 	}
-	return errors.New("invalid coffee type")
+	return ErrInvalidCoffeeType
 }
 
 func (mt MembershipType) IsValid() error {
@@ -54,7 +198,7 @@ func (mt MembershipType) IsValid() error {
 	case Basic, CoffeeLover, EspressoManiac:
 		return nil
 	}
-	return errors.New("invalid membership type")
+	return ErrInvalidMembership
 }
 
 // UserCoffeeQuota user data
@@ -63,78 +207,277 @@ type UserCoffeeQuota struct {
 	StartBoughtTime int64  `json:"bought_time"`
 }
 
+// UserCoffeeGrant one-off extra coffees given to a user, consumed after
+// the regular membership quota is exhausted
+type UserCoffeeGrant struct {
+	Id        string     `json:"id"`
+	Coffee    CoffeeType `json:"coffee_type"`
+	Amount    uint32     `json:"amount"`
+	Used      uint32     `json:"used"`
+	ExpiresAt int64      `json:"expires_at"`
+	Reason    string     `json:"reason"`
+	GrantedBy string     `json:"granted_by"`
+	CreatedAt int64      `json:"created_at"`
+}
+
+// UserStatus Until is the end of a suspension (unix seconds)
+type UserStatus struct {
+	Status    UserStatusType `json:"status"`
+	Until     int64          `json:"until,omitempty"`
+	Reason    string         `json:"reason,omitempty"`
+	ChangedBy string         `json:"changed_by,omitempty"`
+	ChangedAt int64          `json:"changed_at,omitempty"`
+}
+
+// UserCoffeeMembership stored user record, SchemaVersion is set to
+// CurrentSchemaVersion on every write and older records are migrated on read
 type UserCoffeeMembership struct {
-	Membership MembershipType                 `json:"membership"`
-	QuotaState map[CoffeeType]UserCoffeeQuota `json:"quota_state"`
+	SchemaVersion int                            `json:"schema_version"`
+	Membership    MembershipType                 `json:"membership"`
+	QuotaState    map[CoffeeType]UserCoffeeQuota `json:"quota_state"`
+	Grants        []UserCoffeeGrant              `json:"grants,omitempty"`
+	Group         string                         `json:"group,omitempty"`
+	Organization  string                         `json:"organization,omitempty"`
+	GuestQuota    *UserCoffeeQuota               `json:"guest_quota,omitempty"`
+	Status        UserStatus                     `json:"status"`
+}
+
+var ErrUserNotFound = errors.New("user not found")
+var ErrUserExists = errors.New("this user is registered already")
+var ErrInvalidUserId = errors.New(`invalid user id, / and \ are not allowed`)
+
+// Options of the db, users cache is bounded by CacheSize users and
+// users are reloaded from storage after CacheTTL
+// zero CacheSize means unbounded cache, zero CacheTTL means no expiry
+// with WriteBehind user changes are appended to a write-ahead log and
+// written to user files every FlushInterval, when FlushBatchSize users
+// are changed and on Close
+type Options struct {
+	CacheSize      int
+	CacheTTL       time.Duration
+	WriteBehind    bool
+	FlushInterval  time.Duration
+	FlushBatchSize int
+}
+
+// DefaultOptions keeps up to 10000 users in memory for 30 minutes
+// and writes every change to user's file
+func DefaultOptions() Options {
+	return Options{CacheSize: 10000, CacheTTL: 30 * time.Minute, FlushInterval: time.Second, FlushBatchSize: 1000}
 }
 
+// errNoUpdate returned by an updateUser or updateGroup callback to leave the record untouched
+var errNoUpdate = errors.New("no update")
+
+// userLockShards number of locks users are striped over by user id
+const userLockShards = 64
+
+// CoffeeDb users are guarded by userLocks, a user's lock is held while the
+// user is changed in memory and persisted on storage, so operations on
+// different users do not contend and the last state in memory is the last
+// one written to storage
 type CoffeeDb struct {
-	users     map[string]UserCoffeeMembership
-	dbDataDir string
-	lock      sync.Mutex
+	users      *userCache
+	groups     map[string]CoffeeGroup
+	index      *userIndex
+	wb         *writeBehind
+	wal        walLog
+	dbDataDir  string
+	userLocks  [userLockShards]sync.Mutex
+	groupLock  sync.Mutex
+	orgLock    sync.Mutex
+	ledgerLock sync.Mutex
+	guestLock  sync.Mutex
+}
+
+// Remaining returns how many coffees are still available in the grant
+func (g *UserCoffeeGrant) Remaining() uint32 {
+	if g.Used >= g.Amount {
+		return 0
+	}
+	return g.Amount - g.Used
+}
+
+// IsActive reports if the grant can still be consumed at time now (unix seconds)
+func (g *UserCoffeeGrant) IsActive(now int64) bool {
+	return g.Remaining() > 0 && now < g.ExpiresAt
+}
+
+// Effective returns the status at time now (unix seconds),
+// a suspension ends by itself at Until
+func (us *UserStatus) Effective(now int64) UserStatusType {
+	if us.Status == Suspended && now >= us.Until {
+		return Active
+	}
+	return us.Status
+}
+
+// IsActive reports if the user may buy coffee at time now (unix seconds)
+func (us *UserStatus) IsActive(now int64) bool {
+	return us.Effective(now) == Active
 }
 
 func (um *UserCoffeeMembership) Print() {
 	fmt.Printf("membership %s\n", um.Membership.String())
+	fmt.Printf("status %s\n", um.Status.Status.String())
 	for key, value := range um.QuotaState {
 		fmt.Printf("coffee type %s\n", key.String())
 		fmt.Printf("amount bought %d\n", value.AmountBought)
 		fmt.Printf("bought time %d\n", value.StartBoughtTime)
 	}
+	for _, g := range um.Grants {
+		fmt.Printf("grant %s: %d/%d %s expires at %d\n", g.Id, g.Used, g.Amount, g.Coffee.String(), g.ExpiresAt)
+	}
+}
+
+// clone returns a copy of the user's data which does not share maps
+// and slices with the original
+func (um *UserCoffeeMembership) clone() UserCoffeeMembership {
+	c := *um
+	c.QuotaState = make(map[CoffeeType]UserCoffeeQuota, len(um.QuotaState))
+	for coffee, q := range um.QuotaState {
+		c.QuotaState[coffee] = q
+	}
+	if um.Grants != nil {
+		c.Grants = append([]UserCoffeeGrant(nil), um.Grants...)
+	}
+	if um.GuestQuota != nil {
+		guestQuota := *um.GuestQuota
+		c.GuestQuota = &guestQuota
+	}
+	return c
 }
 
 // Init initialize db folder where all user's file will be stored
+// with DefaultOptions
 func Init(dbFolder string) (*CoffeeDb, error) {
-	pathExecutable, err := os.Executable()
+	return InitWithOptions(dbFolder, DefaultOptions())
+}
+
+// InitWithOptions initialize db folder where all user's file will be stored
+// dbFolder is an absolute path or relative to the working directory
+func InitWithOptions(dbFolder string, opts Options) (*CoffeeDb, error) {
+	dataDir, err := filepath.Abs(dbFolder)
 	if err != nil {
 		return nil, err
 	}
-	dataDir := path.Dir(pathExecutable) + string(os.PathSeparator) + dbFolder
-	if _, err := os.Stat(dataDir); errors.Is(err, os.ErrNotExist) {
-		err := os.Mkdir(dataDir, os.ModePerm)
-		if err != nil {
-			return nil, err
-		}
+	if err := os.MkdirAll(dataDir, os.ModePerm); err != nil {
+		return nil, err
 	}
 
-	return &CoffeeDb{users: make(map[string]UserCoffeeMembership), dbDataDir: dataDir}, nil
+	db := &CoffeeDb{
+		users:     newUserCache(opts.CacheSize, opts.CacheTTL),
+		groups:    make(map[string]CoffeeGroup),
+		index:     newUserIndex(),
+		dbDataDir: dataDir,
+	}
+	db.wal.init()
+	if err := db.writeLockFile(); err != nil {
+		return nil, err
+	}
+	if err := db.replayWal(); err != nil {
+		db.removeLockFile()
+		return nil, err
+	}
+	if err := db.buildIndex(); err != nil {
+		db.removeLockFile()
+		return nil, err
+	}
+	if !opts.WriteBehind {
+		db.wal.truncateAfter = walTruncateRecords
+		return db, nil
+	}
+	if opts.FlushInterval <= 0 {
+		db.removeLockFile()
+		return nil, errors.New("flush interval must be positive")
+	}
+	db.wb = &writeBehind{
+		dirty:     make(map[string]pendingWrite),
+		batchSize: opts.FlushBatchSize,
+		flushCh:   make(chan struct{}, 1),
+		stopCh:    make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	go db.flushLoop(opts.FlushInterval)
+	return db, nil
 }
 
 // RegisterUser inserts a new user into db.users and persist user's information on storage
+// returns ErrUserExists if the user is registered already
 func (db *CoffeeDb) RegisterUser(userId string, membership MembershipType) error {
-	if err := db.loadUserDataIfNotExist(userId); errors.Is(err, os.ErrNotExist) {
-		db.lock.Lock()
-		userData := UserCoffeeMembership{Membership: membership, QuotaState: make(map[CoffeeType]UserCoffeeQuota)}
-		db.users[userId] = userData
-		db.lock.Unlock()
-		return db.saveUserData(userId, &userData)
+	lock := db.userLock(userId)
+	lock.Lock()
+	defer lock.Unlock()
+	_, err := db.loadUserData(userId)
+	if err == nil {
+		return ErrUserExists
 	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	userData := UserCoffeeMembership{Membership: membership, QuotaState: make(map[CoffeeType]UserCoffeeQuota)}
+	if err := db.saveUserData(walRegister, userId, &userData); err != nil {
+		return err
+	}
+	db.users.put(userId, userData)
 	return nil
 }
 
-func (db *CoffeeDb) loadUserDataIfNotExist(userId string) error {
-	data, err := db.readFromStorage(userId)
-	if err != nil {
-		return err
+// ValidUserId user ids are file names, so they must not be empty or hold path separators
+func ValidUserId(userId string) bool {
+	return len(userId) != 0 && !strings.ContainsAny(userId, `/\`)
+}
+
+// userLock returns the lock of the shard the user belongs to
+func (db *CoffeeDb) userLock(userId string) *sync.Mutex {
+	h := fnv.New32a()
+	h.Write([]byte(userId))
+	return &db.userLocks[h.Sum32()%userLockShards]
+}
+
+func (db *CoffeeDb) readUserData(userId string) (*UserCoffeeMembership, error) {
+	var data []byte
+	var err error
+	if p, ok := db.pendingWrite(userId); ok {
+		if p.deleted {
+			return nil, os.ErrNotExist
+		}
+		data = p.data
+	} else {
+		data, err = db.readFromStorage(userId)
+		if err != nil {
+			return nil, err
+		}
 	}
-	var userData UserCoffeeMembership
-	err = json.Unmarshal(data, &userData)
+	userData, err := decodeUserData(data)
 	if err != nil {
 		fmt.Println(err)
-		return err
+		return nil, err
 	}
-	db.lock.Lock()
-	db.users[userId] = userData
-	db.lock.Unlock()
-	return nil
+	return userData, nil
+}
+
+// loadUserData returns the user from memory or loads it from storage,
+// user's lock must be held so an older file is never cached over a newer state
+func (db *CoffeeDb) loadUserData(userId string) (*UserCoffeeMembership, error) {
+	if !ValidUserId(userId) {
+		return nil, ErrInvalidUserId
+	}
+	if qs := db.userData(userId); qs != nil {
+		return qs, nil
+	}
+	userData, err := db.readUserData(userId)
+	if err != nil {
+		return nil, err
+	}
+	db.users.put(userId, *userData)
+	return userData, nil
 }
 
 // userData returns UserCoffeeMembership struct by user id from memory
 // if user does not exist - return nil
 func (db *CoffeeDb) userData(userId string) *UserCoffeeMembership {
-	db.lock.Lock()
-	qs, ok := db.users[userId]
-	db.lock.Unlock()
+	qs, ok := db.users.get(userId)
 	if !ok {
 		return nil
 	}
@@ -147,59 +490,259 @@ func (db *CoffeeDb) userData(userId string) *UserCoffeeMembership {
 func (db *CoffeeDb) GetUserData(userId string) *UserCoffeeMembership {
 	qs := db.userData(userId)
 	if qs == nil {
-		err := db.loadUserDataIfNotExist(userId)
+		lock := db.userLock(userId)
+		lock.Lock()
+		defer lock.Unlock()
+		userData, err := db.loadUserData(userId)
 		if err != nil {
 			return nil
 		}
-		return db.userData(userId)
+		return userData
 	}
 	return qs
 }
 
+// updateUser applies update to a copy of the user's data under user's lock,
+// persist it on storage and keeps the result in memory
+// the user is loaded from storage if it was evicted from memory
+func (db *CoffeeDb) updateUser(userId string, update func(userData *UserCoffeeMembership) error) error {
+	lock := db.userLock(userId)
+	lock.Lock()
+	defer lock.Unlock()
+	current, err := db.loadUserData(userId)
+	if errors.Is(err, os.ErrNotExist) {
+		return ErrUserNotFound
+	}
+	if err != nil {
+		return err
+	}
+	userData := current.clone()
+	if err := update(&userData); err != nil {
+		if errors.Is(err, errNoUpdate) {
+			return nil
+		}
+		return err
+	}
+	if err := db.saveUserData(walUpdate, userId, &userData); err != nil {
+		return err
+	}
+	db.users.put(userId, userData)
+	return nil
+}
+
+// CacheStats returns counters of the in-memory users cache
+func (db *CoffeeDb) CacheStats() CacheStats {
+	return db.users.stats()
+}
+
 func fullUserFileName(userId string) string {
 	return userId + ".json"
 }
 
+// writeFileSync writes data to the file and flushes it to the disk
+func writeFileSync(fileName string, data []byte) error {
+	f, err := os.OpenFile(fileName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(data); err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// persistOnStorage writes to a temporary file first and renames it so
+// a crash never leaves a half written user file
 func (db *CoffeeDb) persistOnStorage(userId string, data []byte) error {
 	fileName := db.dbDataDir + string(os.PathSeparator) + fullUserFileName(userId)
-	err := ioutil.WriteFile(fileName, data, 0644)
+	err := writeFileSync(fileName+".tmp", data)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return os.Rename(fileName+".tmp", fileName)
 }
 
 func (db *CoffeeDb) readFromStorage(userId string) ([]byte, error) {
+	if !ValidUserId(userId) {
+		return nil, ErrInvalidUserId
+	}
 	fileName := db.dbDataDir + string(os.PathSeparator) + fullUserFileName(userId)
 	return ioutil.ReadFile(fileName)
 }
 
-func (db *CoffeeDb) saveUserData(userId string, userQuota *UserCoffeeMembership) error {
-	data, err := json.Marshal(userQuota)
+func (db *CoffeeDb) saveUserData(op walOp, userId string, userQuota *UserCoffeeMembership) error {
+	userQuota.SchemaVersion = CurrentSchemaVersion
+	data, err := json.Marshal(userQuota.stored())
 	if err != nil {
 		return err
 	}
-	return db.persistOnStorage(userId, data)
+	if err := db.writeUser(&walRecord{Op: op, UserId: userId, Data: data}, pendingWrite{data: data}); err != nil {
+		return err
+	}
+	db.index.put(userId, userQuota)
+	return nil
+}
+
+// writeUser logs the user's change to the write-ahead log and writes it to
+// user's file or, in write-behind mode, keeps it until the next flush,
+// user's lock must be held
+func (db *CoffeeDb) writeUser(record *walRecord, p pendingWrite) error {
+	if db.wb != nil {
+		return db.appendDirty(record, p)
+	}
+	if err := db.appendWal(record); err != nil {
+		return err
+	}
+	return db.writePending(record.UserId, p)
+}
+
+// pendingWrite returns user's state not yet flushed in write-behind mode
+func (db *CoffeeDb) pendingWrite(userId string) (pendingWrite, bool) {
+	if db.wb == nil {
+		return pendingWrite{}, false
+	}
+	return db.wb.pending(userId)
 }
 
 // SetQuotaState sets coffee amount and time of first bought
 func (db *CoffeeDb) SetQuotaState(userId string, coffee CoffeeType, qs *UserCoffeeQuota) error {
-	if db.GetUserData(userId) != nil {
-		db.lock.Lock()
-		userQuota := db.users[userId]
-		userQuota.QuotaState[coffee] = *qs
-		db.lock.Unlock()
-		return db.saveUserData(userId, &userQuota)
+	err := db.updateUser(userId, func(userData *UserCoffeeMembership) error {
+		userData.QuotaState[coffee] = *qs
+		return nil
+	})
+	if errors.Is(err, ErrUserNotFound) {
+		return nil
 	}
-	return nil
+	return err
+}
+
+// UpdateQuota applies update to the user's quota state of the coffee type
+// under user's lock so the state can not change between reading and
+// writing it, state is nil if the user never bought the coffee, a nil
+// result leaves the state unchanged
+func (db *CoffeeDb) UpdateQuota(userId string, coffee CoffeeType, update func(state *UserCoffeeQuota) (*UserCoffeeQuota, error)) error {
+	return db.updateUser(userId, func(userData *UserCoffeeMembership) error {
+		var state *UserCoffeeQuota
+		if q, ok := userData.QuotaState[coffee]; ok {
+			state = &q
+		}
+		newState, err := update(state)
+		if err != nil {
+			return err
+		}
+		if newState == nil {
+			return errNoUpdate
+		}
+		userData.QuotaState[coffee] = *newState
+		return nil
+	})
+}
+
+// SetUserStatus sets user's status and persist it on storage
+func (db *CoffeeDb) SetUserStatus(userId string, status *UserStatus) error {
+	return db.updateUser(userId, func(userData *UserCoffeeMembership) error {
+		userData.Status = *status
+		return nil
+	})
+}
+
+// AddGrant appends a grant to the user's grants and persist it on storage
+func (db *CoffeeDb) AddGrant(userId string, grant *UserCoffeeGrant) error {
+	return db.updateUser(userId, func(userData *UserCoffeeMembership) error {
+		userData.Grants = append(userData.Grants, *grant)
+		return nil
+	})
+}
+
+// ConsumeGrant uses one coffee from the user's active grant for the coffee type
+// grants which expire first are consumed first
+// returns the id of the used grant or "" if there is no active grant for this coffee type
+func (db *CoffeeDb) ConsumeGrant(userId string, coffee CoffeeType, now int64) (string, error) {
+	consumed := ""
+	err := db.updateUser(userId, func(userData *UserCoffeeMembership) error {
+		found := -1
+		for i := range userData.Grants {
+			g := &userData.Grants[i]
+			if g.Coffee != coffee || !g.IsActive(now) {
+				continue
+			}
+			if found < 0 || g.ExpiresAt < userData.Grants[found].ExpiresAt {
+				found = i
+			}
+		}
+		if found < 0 {
+			return errNoUpdate
+		}
+		userData.Grants[found].Used++
+		consumed = userData.Grants[found].Id
+		return nil
+	})
+	return consumed, err
+}
+
+// ReleaseGrant gives back one coffee used from the grant by ConsumeGrant
+func (db *CoffeeDb) ReleaseGrant(userId string, grantId string) error {
+	return db.updateUser(userId, func(userData *UserCoffeeMembership) error {
+		for i := range userData.Grants {
+			if userData.Grants[i].Id == grantId && userData.Grants[i].Used != 0 {
+				userData.Grants[i].Used--
+				return nil
+			}
+		}
+		return errNoUpdate
+	})
+}
+
+// DeleteUser removes the user from memory, storage, user's group and organization
+// ledger entries and guest passes of the user are kept for accounting
+// but anonymized with anonymousId
+func (db *CoffeeDb) DeleteUser(userId string, anonymousId string) error {
+	userData := db.GetUserData(userId)
+	if userData == nil {
+		return ErrUserNotFound
+	}
+	if len(userData.Group) != 0 {
+		if err := db.RemoveGroupMember(userData.Group, userId); err != nil && !errors.Is(err, ErrGroupNotFound) {
+			return err
+		}
+	}
+	if len(userData.Organization) != 0 {
+		if err := db.RemoveOrganizationMember(userData.Organization, userId); err != nil && !errors.Is(err, ErrOrganizationNotFound) {
+			return err
+		}
+	}
+	if err := db.AnonymizePurchases(userId, anonymousId); err != nil {
+		return err
+	}
+	if err := db.AnonymizeGuestPasses(userId, anonymousId); err != nil {
+		return err
+	}
+
+	lock := db.userLock(userId)
+	lock.Lock()
+	defer lock.Unlock()
+	db.users.remove(userId)
+	db.index.remove(userId)
+	return db.writeUser(&walRecord{Op: walDelete, UserId: userId}, pendingWrite{deleted: true})
 }
 
 // ClearDb remove all files on storage from dbDataDir and
 // clears db.users
 func (db *CoffeeDb) ClearDb() {
+	if db.wb != nil {
+		db.wb.lock.Lock()
+		db.wb.dirty = make(map[string]pendingWrite)
+		db.wb.lock.Unlock()
+	}
+	db.closeWal(false)
 	os.RemoveAll(db.dbDataDir)
-	db.lock.Lock()
-	defer db.lock.Unlock()
-	db.users = make(map[string]UserCoffeeMembership)
+	db.groupLock.Lock()
+	defer db.groupLock.Unlock()
+	db.users.clear()
+	db.groups = make(map[string]CoffeeGroup)
+	db.index.clear()
 }
@@ -0,0 +1,780 @@
+package coffeedb
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"reflect"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLastStateWinsOnStorage(t *testing.T) {
+	t.Parallel()
+	db, err := Init(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	userId := "user-1"
+	if err := db.RegisterUser(userId, Basic); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 1; i <= 50; i++ {
+		wg.Add(1)
+		go func(amount uint32) {
+			defer wg.Done()
+			if err := db.SetQuotaState(userId, Espresso, &UserCoffeeQuota{AmountBought: amount, StartBoughtTime: int64(amount)}); err != nil {
+				t.Error(err)
+			}
+		}(uint32(i))
+	}
+	wg.Wait()
+
+	inMemory := db.GetUserData(userId).QuotaState[Espresso]
+	onStorage, err := db.readUserData(userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if onStorage.QuotaState[Espresso] != inMemory {
+		t.Errorf("storage %+v differs from memory %+v", onStorage.QuotaState[Espresso], inMemory)
+	}
+}
+
+// BenchmarkSetQuotaState every goroutine updates own users, so throughput
+// should grow with goroutines until storage becomes the bottleneck, every
+// update syncs user's file while concurrent updates share log syncs
+func BenchmarkSetQuotaState(b *testing.B) {
+	db, err := Init(b.TempDir())
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer db.Close()
+
+	const usersPerGoroutine = 16
+	for _, goroutines := range []int{1, 2, 4, 8, 16, 32} {
+		usersId := make([][]string, goroutines)
+		for g := range usersId {
+			for u := 0; u < usersPerGoroutine; u++ {
+				userId := fmt.Sprintf("bench-%d-%d-%d", goroutines, g, u)
+				if err := db.RegisterUser(userId, Basic); err != nil {
+					b.Fatal(err)
+				}
+				usersId[g] = append(usersId[g], userId)
+			}
+		}
+
+		b.Run(fmt.Sprintf("goroutines-%d", goroutines), func(b *testing.B) {
+			var next int64
+			var wg sync.WaitGroup
+			b.ResetTimer()
+			for g := 0; g < goroutines; g++ {
+				wg.Add(1)
+				go func(users []string) {
+					defer wg.Done()
+					for i := 0; atomic.AddInt64(&next, 1) <= int64(b.N); i++ {
+						qs := UserCoffeeQuota{AmountBought: uint32(i), StartBoughtTime: int64(i)}
+						if err := db.SetQuotaState(users[i%len(users)], Espresso, &qs); err != nil {
+							b.Error(err)
+							return
+						}
+					}
+				}(usersId[g])
+			}
+			wg.Wait()
+		})
+	}
+}
+
+func TestWriteBehindRecovery(t *testing.T) {
+	t.Parallel()
+	opts := DefaultOptions()
+	opts.WriteBehind = true
+	opts.FlushInterval = time.Hour
+	dir := t.TempDir()
+	db, err := InitWithOptions(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	qs := UserCoffeeQuota{AmountBought: 2, StartBoughtTime: 100}
+	for _, userId := range []string{"user-1", "user-2"} {
+		if err := db.RegisterUser(userId, CoffeeLover); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.SetQuotaState(userId, Espresso, &qs); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.DeleteUser("user-2", "deleted"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.readFromStorage("user-1"); err == nil {
+		t.Fatal("user file written before flush")
+	}
+
+	//a new instance on the same folder acts like a restart after a crash
+	recovered, err := Init(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer recovered.Close()
+	userData := recovered.GetUserData("user-1")
+	if userData == nil || userData.QuotaState[Espresso] != qs {
+		t.Errorf("user not recovered from write-ahead log %+v", userData)
+	}
+	if recovered.GetUserData("user-2") != nil {
+		t.Error("deleted user recovered from write-ahead log")
+	}
+}
+
+func TestWriteBehindFlush(t *testing.T) {
+	t.Parallel()
+	opts := DefaultOptions()
+	opts.WriteBehind = true
+	opts.FlushInterval = time.Hour
+	opts.FlushBatchSize = 0
+	db, err := InitWithOptions(t.TempDir(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.RegisterUser("user-1", Basic); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.readFromStorage("user-1"); err != nil {
+		t.Errorf("user file not written on close: %v", err)
+	}
+	if wal, err := ioutil.ReadFile(db.walFileName()); err != nil || len(wal) != 0 {
+		t.Errorf("write-ahead log not emptied on close: %q %v", wal, err)
+	}
+}
+
+func TestWalWithoutWriteBehind(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	db, err := Init(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.wal.truncateAfter = 3
+
+	if err := db.RegisterUser("user-1", Basic); err != nil {
+		t.Fatal(err)
+	}
+	qs := UserCoffeeQuota{AmountBought: 1, StartBoughtTime: 100}
+	if err := db.SetQuotaState("user-1", Espresso, &qs); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.readFromStorage("user-1"); err != nil {
+		t.Fatalf("user file not written: %v", err)
+	}
+	wal, err := ioutil.ReadFile(db.walFileName())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if records, _ := decodeWalRecords(wal); len(records) != 2 || records[0].Op != walRegister || records[1].Op != walUpdate {
+		t.Fatalf("mutations not logged %+v", records)
+	}
+	for i := 0; i < 3; i++ {
+		if err := db.SetQuotaState("user-1", Espresso, &qs); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if wal, err = ioutil.ReadFile(db.walFileName()); err != nil {
+		t.Fatal(err)
+	}
+	if records, _ := decodeWalRecords(wal); len(records) != 2 {
+		t.Errorf("log not truncated, %d records", len(records))
+	}
+
+	//a crash after the record is logged and before user's file is written
+	data, err := json.Marshal(&UserCoffeeMembership{SchemaVersion: CurrentSchemaVersion, Membership: CoffeeLover})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.appendWal(&walRecord{Op: walUpdate, UserId: "user-1", Data: data}); err != nil {
+		t.Fatal(err)
+	}
+	recovered, err := Init(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer recovered.Close()
+	if userData := recovered.GetUserData("user-1"); userData == nil || userData.Membership != CoffeeLover {
+		t.Errorf("logged change not recovered %+v", userData)
+	}
+	if err := recovered.SetQuotaState("user-1", Americano, &qs); err != nil {
+		t.Fatal(err)
+	}
+	if err := recovered.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if wal, err := ioutil.ReadFile(recovered.walFileName()); err != nil || len(wal) != 0 {
+		t.Errorf("write-ahead log not emptied on close: %q %v", wal, err)
+	}
+}
+
+// TestWalGroupCommit concurrent updates share log syncs, every update
+// must still be in the log once it returns
+func TestWalGroupCommit(t *testing.T) {
+	t.Parallel()
+	db, err := Init(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	const users = 50
+	for i := 0; i < users; i++ {
+		if err := db.RegisterUser("user-"+strconv.Itoa(i), Basic); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < users; i++ {
+		wg.Add(1)
+		go func(userId string) {
+			defer wg.Done()
+			if err := db.SetQuotaState(userId, Espresso, &UserCoffeeQuota{AmountBought: 1, StartBoughtTime: 100}); err != nil {
+				t.Error(err)
+			}
+		}("user-" + strconv.Itoa(i))
+	}
+	wg.Wait()
+
+	wal, err := ioutil.ReadFile(db.walFileName())
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, end := decodeWalRecords(wal)
+	if len(records) != 2*users || end != len(wal) || db.wal.records != 2*users {
+		t.Fatalf("expected %d logged records got %d, %d counted, %d of %d bytes valid", 2*users, len(records), db.wal.records, end, len(wal))
+	}
+	updated := make(map[string]bool)
+	for _, record := range records[users:] {
+		updated[record.UserId] = record.Op == walUpdate
+	}
+	for i := 0; i < users; i++ {
+		if !updated["user-"+strconv.Itoa(i)] {
+			t.Errorf("update of user-%d not logged", i)
+		}
+	}
+}
+
+// TestWalRecoveryFromTruncatedLog a crash may cut the log at any byte,
+// recovery must restore the state after some prefix of the logged records
+func TestWalRecoveryFromTruncatedLog(t *testing.T) {
+	t.Parallel()
+	opts := DefaultOptions()
+	opts.WriteBehind = true
+	opts.FlushInterval = time.Hour
+	opts.FlushBatchSize = 0
+	db, err := InitWithOptions(t.TempDir(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	usersId := []string{"user-1", "user-2", "user-3"}
+	for i, userId := range usersId {
+		if err := db.RegisterUser(userId, MembershipType(i+1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 1; i <= 10; i++ {
+		qs := UserCoffeeQuota{AmountBought: uint32(i), StartBoughtTime: int64(i)}
+		if err := db.SetQuotaState(usersId[i%len(usersId)], CoffeeType(i%3+1), &qs); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.DeleteUser("user-2", "deleted"); err != nil {
+		t.Fatal(err)
+	}
+	wal, err := ioutil.ReadFile(db.walFileName())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//expected users after each prefix of records
+	records, end := decodeWalRecords(wal)
+	if end != len(wal) || len(records) != 14 {
+		t.Fatalf("unexpected log: %d records, %d of %d bytes valid", len(records), end, len(wal))
+	}
+	expected := []map[string]string{{}}
+	for _, record := range records {
+		state := make(map[string]string)
+		for userId, data := range expected[len(expected)-1] {
+			state[userId] = data
+		}
+		if record.Op == walDelete {
+			delete(state, record.UserId)
+		} else {
+			state[record.UserId] = string(record.Data)
+		}
+		expected = append(expected, state)
+	}
+
+	rnd := rand.New(rand.NewSource(1))
+	offsets := []int{0, len(wal)}
+	for i := 0; i < 50; i++ {
+		offsets = append(offsets, rnd.Intn(len(wal)))
+	}
+	for _, offset := range offsets {
+		recoverDir := t.TempDir()
+		if err := ioutil.WriteFile(recoverDir+string(os.PathSeparator)+walFileName, wal[:offset], 0644); err != nil {
+			t.Fatal(err)
+		}
+		recovered, err := Init(recoverDir)
+		if err != nil {
+			t.Fatalf("recover from log cut at %d: %v", offset, err)
+		}
+		complete, _ := decodeWalRecords(wal[:offset])
+		state := expected[len(complete)]
+		if recovered.UsersCount() != len(state) {
+			t.Errorf("log cut at %d: expected %d users got %d", offset, len(state), recovered.UsersCount())
+		}
+		for userId, data := range state {
+			stored, err := recovered.readFromStorage(userId)
+			if err != nil || string(stored) != data {
+				t.Errorf("log cut at %d: user %s expected %s got %s %v", offset, userId, data, stored, err)
+			}
+		}
+		if err := recovered.Close(); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestMigrateLegacyUserRecords(t *testing.T) {
+	t.Parallel()
+	db, err := Init(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	legacy := []byte(`{"membership":2,"quota_state":{"1":{"amount_bought":1,"bought_time":5}}}`)
+	files := map[string][]byte{
+		"legacy": legacy,
+		"newer":  []byte(`{"schema_version":99,"membership":1}`),
+	}
+	for userId, data := range files {
+		if err := db.persistOnStorage(userId, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.RegisterUser("current", Basic); err != nil {
+		t.Fatal(err)
+	}
+
+	userData := db.GetUserData("legacy")
+	if userData == nil || userData.Membership != CoffeeLover || userData.QuotaState[Espresso].AmountBought != 1 || !userData.Status.IsActive(0) {
+		t.Fatalf("legacy record not migrated on read %+v", userData)
+	}
+	if db.GetUserData("newer") != nil {
+		t.Error("record with a newer schema version was read")
+	}
+
+	report, err := db.MigrateAll(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Total != 3 || report.UpToDate != 1 || report.Migrated[1] != 1 || len(report.Failed) != 1 || report.Failed[0].UserId != "newer" {
+		t.Errorf("unexpected dry run report %+v", report)
+	}
+	if stored, _ := db.readFromStorage("legacy"); string(stored) != string(legacy) {
+		t.Error("dry run rewrote a record")
+	}
+
+	if _, err := db.MigrateAll(false); err != nil {
+		t.Fatal(err)
+	}
+	report, err = db.MigrateAll(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.UpToDate != 2 || len(report.Migrated) != 0 {
+		t.Errorf("records not rewritten with the current schema version %+v", report)
+	}
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	t.Parallel()
+	opts := DefaultOptions()
+	opts.WriteBehind = true
+	opts.FlushInterval = time.Hour
+	db, err := InitWithOptions(t.TempDir(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	qs := UserCoffeeQuota{AmountBought: 2, StartBoughtTime: 100}
+	if err := db.RegisterUser("user-1", CoffeeLover); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetQuotaState("user-1", Espresso, &qs); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreateGroup("team", Basic); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.RecordPurchase(&Purchase{UserId: "user-1", Coffee: Espresso, Time: 100}); err != nil {
+		t.Fatal(err)
+	}
+
+	var archive bytes.Buffer
+	manifest, err := db.Snapshot(&archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.Users != 1 || manifest.Files != 3 {
+		t.Fatalf("unexpected manifest %+v", manifest)
+	}
+	if _, err := db.Restore(bytes.NewReader(archive.Bytes())); err == nil {
+		t.Error("snapshot restored into a non empty store")
+	}
+	corrupted := append([]byte{}, archive.Bytes()...)
+	corrupted[len(corrupted)/2] ^= 0xff
+	if _, err := ValidateSnapshot(bytes.NewReader(corrupted)); err == nil {
+		t.Error("corrupted snapshot is valid")
+	}
+
+	restored, err := Init(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restored.Close()
+	if _, err := restored.Restore(bytes.NewReader(archive.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	userData := restored.GetUserData("user-1")
+	if userData == nil || userData.QuotaState[Espresso] != qs {
+		t.Errorf("user not restored %+v", userData)
+	}
+	if restored.GetGroupData("team") == nil || restored.UsersCount() != 1 {
+		t.Error("group or index not restored")
+	}
+	if purchases, err := restored.Purchases(func(p *Purchase) bool { return true }); err != nil || len(purchases) != 1 {
+		t.Errorf("purchases not restored %v %v", purchases, err)
+	}
+}
+
+func TestEnumJson(t *testing.T) {
+	t.Parallel()
+	type request struct {
+		Membership MembershipType `json:"membership"`
+		Coffee     CoffeeType     `json:"coffee_type"`
+	}
+	for _, body := range []string{
+		`{"membership":3,"coffee_type":1}`,
+		`{"membership":"3","coffee_type":"1"}`,
+		`{"membership":"Espresso Maniac","coffee_type":"Espresso"}`,
+		`{"membership":"espresso_maniac","coffee_type":"ESPRESSO"}`,
+		`{"membership":"espresso-maniac","coffee_type":"espresso"}`,
+	} {
+		var r request
+		if err := json.Unmarshal([]byte(body), &r); err != nil || r.Membership != EspressoManiac || r.Coffee != Espresso {
+			t.Errorf("%s: got %+v %v", body, r, err)
+		}
+	}
+	for _, body := range []string{`{"coffee_type":4}`, `{"coffee_type":"Latte"}`, `{"coffee_type":-1}`, `{"coffee_type":true}`} {
+		var r request
+		err := json.Unmarshal([]byte(body), &r)
+		if !errors.Is(err, ErrInvalidCoffeeType) {
+			t.Errorf("%s: expected ErrInvalidCoffeeType got %v", body, err)
+		}
+	}
+	var r request
+	if err := json.Unmarshal([]byte(`{"membership":"gold"}`), &r); !errors.Is(err, ErrInvalidMembership) {
+		t.Errorf("expected ErrInvalidMembership got %v", err)
+	}
+
+	data, err := json.Marshal(request{Membership: CoffeeLover, Coffee: Cappuccino})
+	if err != nil || string(data) != `{"membership":"Coffee Lover","coffee_type":"Cappuccino"}` {
+		t.Errorf("unexpected json %s %v", data, err)
+	}
+	data, err = json.Marshal(map[CoffeeType]UserCoffeeQuota{Americano: {AmountBought: 1}})
+	if err != nil || !bytes.Contains(data, []byte(`"Americano":`)) {
+		t.Errorf("unexpected map keys %s %v", data, err)
+	}
+	//records stored with numbers stay readable
+	var qs map[CoffeeType]UserCoffeeQuota
+	if err := json.Unmarshal([]byte(`{"2":{"amount_bought":1,"bought_time":5}}`), &qs); err != nil || qs[Americano].AmountBought != 1 {
+		t.Errorf("numeric keys not read %+v %v", qs, err)
+	}
+
+	var status UserStatus
+	if err := json.Unmarshal([]byte(`{"status":"banned"}`), &status); err != nil || status.Status != Banned {
+		t.Errorf("status name not read %+v %v", status, err)
+	}
+	if err := json.Unmarshal([]byte(`{"status":7}`), &status); !errors.Is(err, ErrInvalidUserStatus) {
+		t.Errorf("expected ErrInvalidUserStatus got %v", err)
+	}
+	if data, err := json.Marshal(UserStatus{Status: Suspended}); err != nil || string(data) != `{"status":"Suspended"}` {
+		t.Errorf("unexpected json %s %v", data, err)
+	}
+
+	if CoffeeType(9).String() != "CoffeeType(9)" || MembershipType(0).String() != "MembershipType(0)" || UserStatusType(7).String() != "UserStatusType(7)" {
+		t.Error("unexpected names of invalid values")
+	}
+}
+
+func TestRecordsStoredWithNumbers(t *testing.T) {
+	t.Parallel()
+	for _, types := range [][2]interface{}{
+		{UserCoffeeMembership{}, storedUser{}},
+		{UserCoffeeGrant{}, storedGrant{}},
+		{UserStatus{}, storedUserStatus{}},
+		{CoffeeGroup{}, storedGroup{}},
+		{GroupMember{}, storedGroupMember{}},
+		{Purchase{}, storedPurchase{}},
+		{GuestPass{}, storedGuestPass{}},
+		{MemberRecord{}, storedMemberRecord{}},
+	} {
+		record, stored := reflect.TypeOf(types[0]), reflect.TypeOf(types[1])
+		if record.NumField() != stored.NumField() {
+			t.Errorf("%s has %d fields, its storage form %s has %d", record, record.NumField(), stored, stored.NumField())
+		}
+	}
+
+	db, err := Init(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.RegisterUser("user", CoffeeLover); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetQuotaState("user", Americano, &UserCoffeeQuota{AmountBought: 1, StartBoughtTime: 5}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AddGrant("user", &UserCoffeeGrant{Id: "grant", Coffee: Cappuccino, Amount: 1, ExpiresAt: 100}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetUserStatus("user", &UserStatus{Status: Suspended, Until: 100}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreateGroup("group", EspressoManiac); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AddGroupMember("group", "user", GroupMember{SubLimits: map[CoffeeType]uint32{Espresso: 2}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreateGuestPass("GUEST23", &GuestPass{IssuedBy: "user", Coffee: Espresso, ExpiresAt: 100}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.RecordPurchase(&Purchase{UserId: "user", Coffee: Americano, Time: 5, Price: 300}); err != nil {
+		t.Fatal(err)
+	}
+
+	files := make(map[string][]byte)
+	if files["user"], err = db.readFromStorage("user"); err != nil {
+		t.Fatal(err)
+	}
+	if files["group"], err = ioutil.ReadFile(db.recordFileName(groupsDir, "group")); err != nil {
+		t.Fatal(err)
+	}
+	if files["guest pass"], err = ioutil.ReadFile(db.recordFileName(guestPassesDir, "GUEST23")); err != nil {
+		t.Fatal(err)
+	}
+	if files["ledger"], err = ioutil.ReadFile(db.purchasesFileName()); err != nil {
+		t.Fatal(err)
+	}
+	for _, format := range []string{FormatCsv, FormatJsonl} {
+		var export bytes.Buffer
+		if err := db.ExportMembers(&export, format); err != nil {
+			t.Fatal(err)
+		}
+		files[format+" export"] = export.Bytes()
+	}
+	for name, data := range files {
+		for _, enumName := range []string{"Espresso", "Americano", "Cappuccino", "Lover", "Maniac", "Suspended"} {
+			if bytes.Contains(data, []byte(enumName)) {
+				t.Errorf("%s stored with name %s: %s", name, enumName, data)
+			}
+		}
+	}
+	for name, expected := range map[string]string{
+		"user":         `"membership":2,"quota_state":{"2":`,
+		"group":        `"sub_limits":{"1":2}`,
+		"guest pass":   `"coffee_type":1`,
+		"ledger":       `"coffee_type":2`,
+		"jsonl export": `"membership":2`,
+	} {
+		if !bytes.Contains(files[name], []byte(expected)) {
+			t.Errorf("%s has no %s: %s", name, expected, files[name])
+		}
+	}
+	if !bytes.Contains(files["user"], []byte(`"coffee_type":3`)) || !bytes.Contains(files["user"], []byte(`"status":{"status":1`)) {
+		t.Errorf("user grants or status not stored with numbers: %s", files["user"])
+	}
+}
+
+func TestInvalidRecordIds(t *testing.T) {
+	t.Parallel()
+	db, err := Init(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.RegisterUser("alice", CoffeeLover); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := db.readFromStorage("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []string{"", "../alice", `..\alice`, "a/b", "a.b"} {
+		if err := db.CreateGroup(id, Basic); !errors.Is(err, ErrInvalidGroupId) {
+			t.Errorf("create group %q: expected %v got %v", id, ErrInvalidGroupId, err)
+		}
+		if err := db.AddGroupMember(id, "alice", GroupMember{}); !errors.Is(err, ErrInvalidGroupId) {
+			t.Errorf("add member of group %q: expected %v got %v", id, ErrInvalidGroupId, err)
+		}
+		if db.GetGroupData(id) != nil {
+			t.Errorf("group %q found", id)
+		}
+		if err := db.CreateOrganization(id, &Organization{Name: "Acme"}); !errors.Is(err, ErrInvalidOrganizationId) {
+			t.Errorf("create organization %q: expected %v got %v", id, ErrInvalidOrganizationId, err)
+		}
+		if err := db.AddOrganizationMember(id, "alice"); !errors.Is(err, ErrInvalidOrganizationId) {
+			t.Errorf("add member of organization %q: expected %v got %v", id, ErrInvalidOrganizationId, err)
+		}
+		if db.GetOrganizationData(id) != nil {
+			t.Errorf("organization %q found", id)
+		}
+	}
+
+	if after, err := db.readFromStorage("alice"); err != nil || !bytes.Equal(stored, after) {
+		t.Errorf("user record changed %s", after)
+	}
+	if userData := db.GetUserData("alice"); userData == nil || len(userData.Group) != 0 || len(userData.Organization) != 0 {
+		t.Errorf("user joined an invalid group or organization %v", userData)
+	}
+
+	if err := db.CreateGroup("team", Basic); err != nil {
+		t.Fatal(err)
+	}
+	group, err := ioutil.ReadFile(db.recordFileName(groupsDir, "team"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, userId := range []string{"", groupsDir + "/team", groupsDir + `\team`} {
+		if err := db.RegisterUser(userId, Basic); !errors.Is(err, ErrInvalidUserId) {
+			t.Errorf("register user %q: expected %v got %v", userId, ErrInvalidUserId, err)
+		}
+		if err := db.AddGrant(userId, &UserCoffeeGrant{Id: "grant", Coffee: Espresso, Amount: 1}); !errors.Is(err, ErrInvalidUserId) {
+			t.Errorf("grant to user %q: expected %v got %v", userId, ErrInvalidUserId, err)
+		}
+		if db.GetUserData(userId) != nil {
+			t.Errorf("user %q found", userId)
+		}
+		if _, err := db.readFromStorage(userId); !errors.Is(err, ErrInvalidUserId) {
+			t.Errorf("read user %q: expected %v got %v", userId, ErrInvalidUserId, err)
+		}
+	}
+	if after, err := ioutil.ReadFile(db.recordFileName(groupsDir, "team")); err != nil || !bytes.Equal(group, after) {
+		t.Errorf("group record changed %s", after)
+	}
+}
+
+func TestConcurrentOrganizationMembers(t *testing.T) {
+	t.Parallel()
+	db, err := Init(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.RegisterUser("alice", Basic); err != nil {
+		t.Fatal(err)
+	}
+	orgs := make([]string, 10)
+	for i := range orgs {
+		orgs[i] = "org" + strconv.Itoa(i)
+		if err := db.CreateOrganization(orgs[i], &Organization{Name: orgs[i]}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var added int32
+	var wg sync.WaitGroup
+	for _, orgId := range orgs {
+		wg.Add(1)
+		go func(orgId string) {
+			defer wg.Done()
+			if db.AddOrganizationMember(orgId, "alice") == nil {
+				atomic.AddInt32(&added, 1)
+			}
+		}(orgId)
+	}
+	wg.Wait()
+	if added != 1 {
+		t.Fatalf("expected the user added to one organization got %d", added)
+	}
+	userOrg := db.GetUserData("alice").Organization
+	for _, orgId := range orgs {
+		members := db.GetOrganizationData(orgId).Members
+		if isMember := len(members) == 1 && members[0] == "alice"; isMember != (orgId == userOrg) {
+			t.Errorf("organization %s members %v, user is a member of %s", orgId, members, userOrg)
+		}
+	}
+}
+
+func TestDataDirInUse(t *testing.T) {
+	t.Parallel()
+	for _, writeBehind := range []bool{false, true} {
+		dir := t.TempDir()
+		opts := DefaultOptions()
+		opts.WriteBehind = writeBehind
+		db, err := InitWithOptions(dir, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := db.RegisterUser("user", Basic); err != nil {
+			t.Fatal(err)
+		}
+		if err := CheckNotInUse(dir); !errors.Is(err, ErrDataDirInUse) {
+			t.Errorf("write-behind %v: expected %v with an open db got %v", writeBehind, ErrDataDirInUse, err)
+		}
+		if err := db.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := CheckNotInUse(dir); err != nil {
+			t.Errorf("write-behind %v: closed db in use %v", writeBehind, err)
+		}
+	}
+
+	//a crashed db leaves records in the log even if the lock file is gone
+	dir := t.TempDir()
+	db, err := Init(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.RegisterUser("user", Basic); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.removeLockFile(); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckNotInUse(dir); !errors.Is(err, ErrDataDirInUse) {
+		t.Errorf("expected %v with records in the log got %v", ErrDataDirInUse, err)
+	}
+}
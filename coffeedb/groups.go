@@ -0,0 +1,184 @@
+package coffeedb
+
+import (
+	"errors"
+	"os"
+)
+
+const groupsDir = "groups"
+
+var ErrGroupNotFound = errors.New("group not found")
+var ErrGroupExists = errors.New("group exists already")
+var ErrInvalidGroupId = errors.New("invalid group id, only letters, digits, - and _ are allowed")
+
+// GroupMember optional per member limits inside the group pool
+// a coffee type without a sub-limit is limited only by the pool
+type GroupMember struct {
+	SubLimits map[CoffeeType]uint32 `json:"sub_limits,omitempty"`
+}
+
+// CoffeeGroup family/team account where all members share one quota pool
+type CoffeeGroup struct {
+	Membership MembershipType                 `json:"membership"`
+	Members    map[string]GroupMember         `json:"members"`
+	QuotaState map[CoffeeType]UserCoffeeQuota `json:"quota_state"`
+}
+
+// groupData returns the group from memory or loads it from storage,
+// db.groupLock must be held
+func (db *CoffeeDb) groupData(groupId string) (*CoffeeGroup, error) {
+	if !ValidRecordId(groupId) {
+		return nil, ErrInvalidGroupId
+	}
+	if group, ok := db.groups[groupId]; ok {
+		return &group, nil
+	}
+	var group CoffeeGroup
+	if err := db.readRecord(groupsDir, groupId, &group); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, ErrGroupNotFound
+		}
+		return nil, err
+	}
+	db.groups[groupId] = group
+	return &group, nil
+}
+
+// updateGroup applies update to a copy of the group under db.groupLock and
+// persist it on storage before releasing the lock, update may take user
+// locks but user locks are never held while taking db.groupLock
+func (db *CoffeeDb) updateGroup(groupId string, update func(group *CoffeeGroup) error) error {
+	db.groupLock.Lock()
+	defer db.groupLock.Unlock()
+	current, err := db.groupData(groupId)
+	if err != nil {
+		return err
+	}
+	group := *current
+	group.Members = make(map[string]GroupMember, len(current.Members)+1)
+	for id, m := range current.Members {
+		group.Members[id] = m
+	}
+	group.QuotaState = make(map[CoffeeType]UserCoffeeQuota, len(current.QuotaState)+1)
+	for c, q := range current.QuotaState {
+		group.QuotaState[c] = q
+	}
+	if err := update(&group); err != nil {
+		if errors.Is(err, errNoUpdate) {
+			return nil
+		}
+		return err
+	}
+	if err := db.saveRecord(groupsDir, groupId, group.stored()); err != nil {
+		return err
+	}
+	db.groups[groupId] = group
+	return nil
+}
+
+// GetGroupData returns CoffeeGroup struct by group id from memory
+// if group does not exist in memory then try to load from file
+// if file does not exist - return nil
+func (db *CoffeeDb) GetGroupData(groupId string) *CoffeeGroup {
+	db.groupLock.Lock()
+	defer db.groupLock.Unlock()
+	group, err := db.groupData(groupId)
+	if err != nil {
+		return nil
+	}
+	return group
+}
+
+// CreateGroup inserts a new group without members and persist it on storage
+func (db *CoffeeDb) CreateGroup(groupId string, membership MembershipType) error {
+	if !ValidRecordId(groupId) {
+		return ErrInvalidGroupId
+	}
+	db.groupLock.Lock()
+	defer db.groupLock.Unlock()
+	if _, err := db.groupData(groupId); err == nil {
+		return ErrGroupExists
+	}
+	group := CoffeeGroup{
+		Membership: membership,
+		Members:    make(map[string]GroupMember),
+		QuotaState: make(map[CoffeeType]UserCoffeeQuota),
+	}
+	if err := db.saveRecord(groupsDir, groupId, group.stored()); err != nil {
+		return err
+	}
+	db.groups[groupId] = group
+	return nil
+}
+
+// AddGroupMember adds a registered user to the group or updates the
+// member's sub-limits if the user is a member already
+// a user can be a member of one group only
+func (db *CoffeeDb) AddGroupMember(groupId string, userId string, member GroupMember) error {
+	if !ValidRecordId(groupId) {
+		return ErrInvalidGroupId
+	}
+	if db.GetGroupData(groupId) == nil {
+		return ErrGroupNotFound
+	}
+	err := db.updateUser(userId, func(userData *UserCoffeeMembership) error {
+		if len(userData.Group) != 0 && userData.Group != groupId {
+			return errors.New("user is a member of group " + userData.Group)
+		}
+		userData.Group = groupId
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return db.updateGroup(groupId, func(group *CoffeeGroup) error {
+		group.Members[userId] = member
+		return nil
+	})
+}
+
+// RemoveGroupMember removes the user from the group, the user
+// buys coffee with own membership afterwards
+func (db *CoffeeDb) RemoveGroupMember(groupId string, userId string) error {
+	if !ValidRecordId(groupId) {
+		return ErrInvalidGroupId
+	}
+	if db.GetGroupData(groupId) == nil {
+		return ErrGroupNotFound
+	}
+	err := db.updateUser(userId, func(userData *UserCoffeeMembership) error {
+		if userData.Group != groupId {
+			return errors.New("user is not a member of group " + groupId)
+		}
+		userData.Group = ""
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return db.updateGroup(groupId, func(group *CoffeeGroup) error {
+		delete(group.Members, userId)
+		return nil
+	})
+}
+
+// UpdateGroupQuota applies update to the pool state of the coffee type
+// under db.groupLock, state is nil if the group never bought the coffee,
+// a nil result leaves the pool unchanged, group must not be modified
+func (db *CoffeeDb) UpdateGroupQuota(groupId string, coffee CoffeeType, update func(group *CoffeeGroup, state *UserCoffeeQuota) (*UserCoffeeQuota, error)) error {
+	return db.updateGroup(groupId, func(group *CoffeeGroup) error {
+		var state *UserCoffeeQuota
+		if q, ok := group.QuotaState[coffee]; ok {
+			state = &q
+		}
+		newState, err := update(group, state)
+		if err != nil {
+			return err
+		}
+		if newState == nil {
+			return errNoUpdate
+		}
+		group.QuotaState[coffee] = *newState
+		return nil
+	})
+}
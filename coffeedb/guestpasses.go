@@ -0,0 +1,155 @@
+package coffeedb
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+const guestPassesDir = "guestpasses"
+
+var ErrGuestPassNotFound = errors.New("guest pass not found")
+var ErrGuestPassExists = errors.New("guest pass exists already")
+var ErrGuestPassRedeemed = errors.New("guest pass redeemed already")
+var ErrGuestPassExpired = errors.New("guest pass expired")
+
+// GuestPass one-time code a member issues to treat somebody to a coffee
+// RedeemedAt is zero until the pass is used
+type GuestPass struct {
+	IssuedBy   string     `json:"issued_by"`
+	Coffee     CoffeeType `json:"coffee_type"`
+	CreatedAt  int64      `json:"created_at"`
+	ExpiresAt  int64      `json:"expires_at"`
+	RedeemedAt int64      `json:"redeemed_at,omitempty"`
+}
+
+// validGuestCode guest codes are used as file names, allow only letters and digits
+func validGuestCode(code string) bool {
+	if len(code) == 0 {
+		return false
+	}
+	for _, c := range code {
+		if !(c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// GetGuestPass returns GuestPass by code from storage
+// if guest pass does not exist - return nil
+func (db *CoffeeDb) GetGuestPass(code string) *GuestPass {
+	if !validGuestCode(code) {
+		return nil
+	}
+	db.guestLock.Lock()
+	defer db.guestLock.Unlock()
+	var pass GuestPass
+	if err := db.readRecord(guestPassesDir, code, &pass); err != nil {
+		return nil
+	}
+	return &pass
+}
+
+// CreateGuestPass persist a new guest pass on storage
+func (db *CoffeeDb) CreateGuestPass(code string, pass *GuestPass) error {
+	if !validGuestCode(code) {
+		return errors.New("invalid guest code")
+	}
+	db.guestLock.Lock()
+	defer db.guestLock.Unlock()
+	if _, err := os.Stat(db.recordFileName(guestPassesDir, code)); err == nil {
+		return ErrGuestPassExists
+	}
+	return db.saveRecord(guestPassesDir, code, pass.stored())
+}
+
+// RedeemGuestPass marks the guest pass as used at time now (unix seconds)
+// a guest pass can be redeemed only once and before it expires
+func (db *CoffeeDb) RedeemGuestPass(code string, now int64) (*GuestPass, error) {
+	if !validGuestCode(code) {
+		return nil, ErrGuestPassNotFound
+	}
+	db.guestLock.Lock()
+	defer db.guestLock.Unlock()
+	var pass GuestPass
+	if err := db.readRecord(guestPassesDir, code, &pass); err != nil {
+		return nil, ErrGuestPassNotFound
+	}
+	if pass.RedeemedAt != 0 {
+		return nil, ErrGuestPassRedeemed
+	}
+	if now >= pass.ExpiresAt {
+		return nil, ErrGuestPassExpired
+	}
+	pass.RedeemedAt = now
+	if err := db.saveRecord(guestPassesDir, code, pass.stored()); err != nil {
+		return nil, err
+	}
+	return &pass, nil
+}
+
+// UpdateGuestQuota applies update to the state of the user's separate guest
+// allowance under user's lock like UpdateQuota, state is nil if the user never
+// issued a guest pass from the allowance, a nil result leaves the state unchanged
+func (db *CoffeeDb) UpdateGuestQuota(userId string, update func(state *UserCoffeeQuota) (*UserCoffeeQuota, error)) error {
+	return db.updateUser(userId, func(userData *UserCoffeeMembership) error {
+		newState, err := update(userData.GuestQuota)
+		if err != nil {
+			return err
+		}
+		if newState == nil {
+			return errNoUpdate
+		}
+		guestQuota := *newState
+		userData.GuestQuota = &guestQuota
+		return nil
+	})
+}
+
+// GuestPassesIssuedBy returns all guest passes issued by the user keyed by guest code
+func (db *CoffeeDb) GuestPassesIssuedBy(userId string) (map[string]GuestPass, error) {
+	db.guestLock.Lock()
+	defer db.guestLock.Unlock()
+	return db.guestPassesIssuedBy(userId)
+}
+
+func (db *CoffeeDb) guestPassesIssuedBy(userId string) (map[string]GuestPass, error) {
+	passes := make(map[string]GuestPass)
+	files, err := ioutil.ReadDir(db.dbDataDir + string(os.PathSeparator) + guestPassesDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return passes, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		code := strings.TrimSuffix(f.Name(), ".json")
+		var pass GuestPass
+		if err := db.readRecord(guestPassesDir, code, &pass); err != nil {
+			return nil, err
+		}
+		if pass.IssuedBy == userId {
+			passes[code] = pass
+		}
+	}
+	return passes, nil
+}
+
+// AnonymizeGuestPasses replaces the issuer userId with anonymousId in all guest passes
+func (db *CoffeeDb) AnonymizeGuestPasses(userId string, anonymousId string) error {
+	db.guestLock.Lock()
+	defer db.guestLock.Unlock()
+	passes, err := db.guestPassesIssuedBy(userId)
+	if err != nil {
+		return err
+	}
+	for code, pass := range passes {
+		pass.IssuedBy = anonymousId
+		if err := db.saveRecord(guestPassesDir, code, pass.stored()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
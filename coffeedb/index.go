@@ -0,0 +1,145 @@
+package coffeedb
+
+import (
+	"CoffeeShop/logging"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// UserSummary index entry of a user used for listing and search
+type UserSummary struct {
+	UserId       string         `json:"user_id"`
+	Membership   MembershipType `json:"membership"`
+	Status       UserStatus     `json:"status"`
+	Group        string         `json:"group,omitempty"`
+	Organization string         `json:"organization,omitempty"`
+}
+
+// UserQuery filters users in ListUsers
+// After is the cursor, only users with id greater than After are listed
+// zero Membership and nil Status match any user
+// Status is matched against the effective status at time Now (unix seconds)
+type UserQuery struct {
+	After      string
+	Limit      int
+	Prefix     string
+	Membership MembershipType
+	Status     *UserStatusType
+	Now        int64
+}
+
+// userIndex in-memory summaries of all users on storage with ids kept sorted
+// so listing never reads user files
+type userIndex struct {
+	users map[string]UserSummary
+	ids   []string
+	lock  sync.RWMutex
+}
+
+func newUserIndex() *userIndex {
+	return &userIndex{users: make(map[string]UserSummary)}
+}
+
+func (idx *userIndex) put(userId string, userData *UserCoffeeMembership) {
+	idx.lock.Lock()
+	defer idx.lock.Unlock()
+	if _, ok := idx.users[userId]; !ok {
+		i := sort.SearchStrings(idx.ids, userId)
+		idx.ids = append(idx.ids, "")
+		copy(idx.ids[i+1:], idx.ids[i:])
+		idx.ids[i] = userId
+	}
+	idx.users[userId] = UserSummary{
+		UserId:       userId,
+		Membership:   userData.Membership,
+		Status:       userData.Status,
+		Group:        userData.Group,
+		Organization: userData.Organization,
+	}
+}
+
+func (idx *userIndex) remove(userId string) {
+	idx.lock.Lock()
+	defer idx.lock.Unlock()
+	if _, ok := idx.users[userId]; !ok {
+		return
+	}
+	delete(idx.users, userId)
+	i := sort.SearchStrings(idx.ids, userId)
+	idx.ids = append(idx.ids[:i], idx.ids[i+1:]...)
+}
+
+func (idx *userIndex) clear() {
+	idx.lock.Lock()
+	defer idx.lock.Unlock()
+	idx.users = make(map[string]UserSummary)
+	idx.ids = nil
+}
+
+func (idx *userIndex) list(q *UserQuery) ([]UserSummary, bool) {
+	idx.lock.RLock()
+	defer idx.lock.RUnlock()
+	start := sort.SearchStrings(idx.ids, q.Prefix)
+	if q.After >= q.Prefix {
+		start = sort.Search(len(idx.ids), func(i int) bool { return idx.ids[i] > q.After })
+	}
+	users := []UserSummary{}
+	for _, userId := range idx.ids[start:] {
+		if !strings.HasPrefix(userId, q.Prefix) {
+			break
+		}
+		u := idx.users[userId]
+		if q.Membership != 0 && u.Membership != q.Membership {
+			continue
+		}
+		if q.Status != nil && u.Status.Effective(q.Now) != *q.Status {
+			continue
+		}
+		if len(users) == q.Limit {
+			return users, true
+		}
+		users = append(users, u)
+	}
+	return users, false
+}
+
+// buildIndex reads all user files on storage once at start,
+// files which can not be decoded are logged and skipped
+func (db *CoffeeDb) buildIndex() error {
+	files, err := ioutil.ReadDir(db.dbDataDir)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), ".json") {
+			continue
+		}
+		data, err := ioutil.ReadFile(db.dbDataDir + string(os.PathSeparator) + f.Name())
+		if err != nil {
+			return err
+		}
+		userData, err := decodeUserData(data)
+		if err != nil {
+			logging.Warnf("user file %s is not indexed: %v", f.Name(), err)
+			continue
+		}
+		db.index.put(strings.TrimSuffix(f.Name(), ".json"), userData)
+	}
+	return nil
+}
+
+// ListUsers returns up to q.Limit users matching q sorted by user id
+// and true if there are more users to list after the last one
+func (db *CoffeeDb) ListUsers(q *UserQuery) ([]UserSummary, bool) {
+	return db.index.list(q)
+}
+
+// UsersCount returns the number of users on storage
+func (db *CoffeeDb) UsersCount() int {
+	db.index.lock.RLock()
+	defer db.index.lock.RUnlock()
+	return len(db.index.ids)
+}
@@ -0,0 +1,62 @@
+package coffeedb
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// lockFileName holds the process id of the db which has the data folder
+// open, it is written by Init and removed by Close, a crashed db leaves it
+// behind together with its write-ahead log
+const lockFileName = "coffeedb.lock"
+
+// ErrDataDirInUse the data folder is open in another db or holds changes
+// of a db which was not closed
+var ErrDataDirInUse = errors.New("data folder is in use")
+
+// CheckNotInUse returns ErrDataDirInUse if a db has the data folder open or
+// its write-ahead log has records, Init would replay the log and remove it
+// under a running db, maintenance commands run only on folders not in use
+func CheckNotInUse(dbFolder string) error {
+	lockFile := filepath.Join(dbFolder, lockFileName)
+	pid, err := ioutil.ReadFile(lockFile)
+	if err == nil {
+		return fmt.Errorf("%w by process %s, stop the server first or remove %s if it is not running",
+			ErrDataDirInUse, strings.TrimSpace(string(pid)), lockFile)
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	walFile := filepath.Join(dbFolder, walFileName)
+	info, err := os.Stat(walFile)
+	if err == nil && info.Size() != 0 {
+		return fmt.Errorf("%w, %s has changes not applied yet, start and stop the server first", ErrDataDirInUse, walFile)
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (db *CoffeeDb) lockFileName() string {
+	return db.dbDataDir + string(os.PathSeparator) + lockFileName
+}
+
+// writeLockFile marks the data folder in use, a lock file left by a
+// crashed db is replaced
+func (db *CoffeeDb) writeLockFile() error {
+	return writeFileSync(db.lockFileName(), []byte(strconv.Itoa(os.Getpid())+"\n"))
+}
+
+func (db *CoffeeDb) removeLockFile() error {
+	err := os.Remove(db.lockFileName())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
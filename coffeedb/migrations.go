@@ -0,0 +1,169 @@
+package coffeedb
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+)
+
+// CurrentSchemaVersion version of stored user records written by this build
+// records stored before versioning have no schema_version and are version 1
+const CurrentSchemaVersion = 2
+
+// Migration upgrades a stored user record from version From to From+1
+// Upgrade gets the record as a map of json fields and changes it in place
+type Migration struct {
+	From        int
+	Description string
+	Upgrade     func(record map[string]json.RawMessage) error
+}
+
+var migrations = make(map[int]Migration)
+
+// RegisterMigration adds a migration to the registry, there is one
+// migration per version
+func RegisterMigration(m Migration) {
+	if _, ok := migrations[m.From]; ok {
+		panic(fmt.Sprintf("migration from schema version %d registered twice", m.From))
+	}
+	migrations[m.From] = m
+}
+
+func init() {
+	RegisterMigration(Migration{
+		From:        1,
+		Description: "add user status, quota state is never null",
+		Upgrade: func(record map[string]json.RawMessage) error {
+			if _, ok := record["status"]; !ok {
+				record["status"] = json.RawMessage(`{"status":0}`)
+			}
+			if qs, ok := record["quota_state"]; !ok || string(qs) == "null" {
+				record["quota_state"] = json.RawMessage(`{}`)
+			}
+			return nil
+		},
+	})
+}
+
+// schemaVersion returns version of the stored record
+func schemaVersion(record map[string]json.RawMessage) (int, error) {
+	raw, ok := record["schema_version"]
+	if !ok {
+		return 1, nil
+	}
+	var version int
+	if err := json.Unmarshal(raw, &version); err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+// migrateUserRecord upgrades a stored user record to CurrentSchemaVersion
+// returns the upgraded record and the version it was stored with
+func migrateUserRecord(data []byte) ([]byte, int, error) {
+	var record map[string]json.RawMessage
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, 0, err
+	}
+	version, err := schemaVersion(record)
+	if err != nil {
+		return nil, 0, err
+	}
+	if version == CurrentSchemaVersion {
+		return data, version, nil
+	}
+	if version > CurrentSchemaVersion {
+		return nil, version, fmt.Errorf("schema version %d is newer than supported %d", version, CurrentSchemaVersion)
+	}
+	for v := version; v < CurrentSchemaVersion; v++ {
+		m, ok := migrations[v]
+		if !ok {
+			return nil, version, fmt.Errorf("no migration from schema version %d", v)
+		}
+		if err := m.Upgrade(record); err != nil {
+			return nil, version, fmt.Errorf("migration from schema version %d: %w", v, err)
+		}
+		record["schema_version"] = json.RawMessage(fmt.Sprint(v + 1))
+	}
+	data, err = json.Marshal(record)
+	return data, version, err
+}
+
+// decodeUserData unmarshal a stored user record of any supported schema version
+func decodeUserData(data []byte) (*UserCoffeeMembership, error) {
+	data, _, err := migrateUserRecord(data)
+	if err != nil {
+		return nil, err
+	}
+	var userData UserCoffeeMembership
+	if err := json.Unmarshal(data, &userData); err != nil {
+		return nil, err
+	}
+	return &userData, nil
+}
+
+// MigrationFailure user record which could not be migrated
+type MigrationFailure struct {
+	UserId string `json:"user_id"`
+	Error  string `json:"error"`
+}
+
+// MigrationReport result of MigrateAll, Migrated counts records per
+// schema version they were upgraded from
+type MigrationReport struct {
+	DryRun   bool               `json:"dry_run"`
+	Total    int                `json:"total"`
+	UpToDate int                `json:"up_to_date"`
+	Migrated map[int]int        `json:"migrated"`
+	Failed   []MigrationFailure `json:"failed"`
+}
+
+// MigrateAll rewrites all user records on storage with CurrentSchemaVersion,
+// with dryRun it only reports what would be migrated
+// it must not run concurrently with other writers of the data directory
+func (db *CoffeeDb) MigrateAll(dryRun bool) (*MigrationReport, error) {
+	if err := db.Flush(); err != nil {
+		return nil, err
+	}
+	files, err := ioutil.ReadDir(db.dbDataDir)
+	if err != nil {
+		return nil, err
+	}
+	report := MigrationReport{DryRun: dryRun, Migrated: make(map[int]int), Failed: []MigrationFailure{}}
+	var userIds []string
+	for _, f := range files {
+		if !f.IsDir() && strings.HasSuffix(f.Name(), ".json") {
+			userIds = append(userIds, strings.TrimSuffix(f.Name(), ".json"))
+		}
+	}
+	sort.Strings(userIds)
+	for _, userId := range userIds {
+		report.Total++
+		data, err := ioutil.ReadFile(db.dbDataDir + string(os.PathSeparator) + fullUserFileName(userId))
+		if err == nil {
+			var version int
+			data, version, err = migrateUserRecord(data)
+			if err == nil && version == CurrentSchemaVersion {
+				report.UpToDate++
+				continue
+			}
+			if err == nil {
+				report.Migrated[version]++
+			}
+			if err == nil && !dryRun {
+				lock := db.userLock(userId)
+				lock.Lock()
+				err = db.persistOnStorage(userId, data)
+				db.users.remove(userId)
+				lock.Unlock()
+			}
+		}
+		if err != nil {
+			report.Failed = append(report.Failed, MigrationFailure{UserId: userId, Error: err.Error()})
+		}
+	}
+	return &report, nil
+}
@@ -0,0 +1,198 @@
+package coffeedb
+
+import (
+	"CoffeeShop/logging"
+	"errors"
+	"os"
+	"sort"
+)
+
+const organizationsDir = "organizations"
+
+var ErrOrganizationNotFound = errors.New("organization not found")
+var ErrOrganizationExists = errors.New("organization exists already")
+var ErrInvalidOrganizationId = errors.New("invalid organization id, only letters, digits, - and _ are allowed")
+
+// OrganizationUsage drinks and spending of an organization in one month
+// Month is formatted as "2006-01"
+type OrganizationUsage struct {
+	Month  string `json:"month"`
+	Drinks uint32 `json:"drinks"`
+	Spent  uint64 `json:"spent"`
+}
+
+// Organization corporate account billed monthly for drinks of its members
+// a zero cap means no cap
+type Organization struct {
+	Name            string            `json:"name"`
+	MonthlyDrinkCap uint32            `json:"monthly_drink_cap"`
+	MonthlySpendCap uint64            `json:"monthly_spend_cap"`
+	Members         []string          `json:"members"`
+	Usage           OrganizationUsage `json:"usage"`
+}
+
+// UsageIn returns the organization usage in month
+func (o *Organization) UsageIn(month string) OrganizationUsage {
+	if o.Usage.Month != month {
+		return OrganizationUsage{Month: month}
+	}
+	return o.Usage
+}
+
+// GetOrganizationData returns Organization struct by organization id from storage
+// if organization does not exist - return nil
+func (db *CoffeeDb) GetOrganizationData(orgId string) *Organization {
+	if !ValidRecordId(orgId) {
+		return nil
+	}
+	db.orgLock.Lock()
+	defer db.orgLock.Unlock()
+	var org Organization
+	if err := db.readRecord(organizationsDir, orgId, &org); err != nil {
+		return nil
+	}
+	return &org
+}
+
+// CreateOrganization inserts a new organization without members and persist it on storage
+func (db *CoffeeDb) CreateOrganization(orgId string, org *Organization) error {
+	if !ValidRecordId(orgId) {
+		return ErrInvalidOrganizationId
+	}
+	db.orgLock.Lock()
+	defer db.orgLock.Unlock()
+	if _, err := os.Stat(db.recordFileName(organizationsDir, orgId)); err == nil {
+		return ErrOrganizationExists
+	}
+	newOrg := *org
+	newOrg.Members = []string{}
+	newOrg.Usage = OrganizationUsage{}
+	return db.saveRecord(organizationsDir, orgId, &newOrg)
+}
+
+// updateOrganization applies update to the stored organization under orgLock
+func (db *CoffeeDb) updateOrganization(orgId string, update func(org *Organization) error) error {
+	if !ValidRecordId(orgId) {
+		return ErrInvalidOrganizationId
+	}
+	db.orgLock.Lock()
+	defer db.orgLock.Unlock()
+	var org Organization
+	if err := db.readRecord(organizationsDir, orgId, &org); err != nil {
+		return ErrOrganizationNotFound
+	}
+	if err := update(&org); err != nil {
+		return err
+	}
+	return db.saveRecord(organizationsDir, orgId, &org)
+}
+
+// AddOrganizationMember adds a registered user to the organization
+// a user can be a member of one organization only, the membership is
+// checked and set under the user lock
+func (db *CoffeeDb) AddOrganizationMember(orgId string, userId string) error {
+	if !ValidRecordId(orgId) {
+		return ErrInvalidOrganizationId
+	}
+	if db.GetOrganizationData(orgId) == nil {
+		return ErrOrganizationNotFound
+	}
+	err := db.updateUser(userId, func(userData *UserCoffeeMembership) error {
+		if len(userData.Organization) != 0 {
+			return errors.New("user is a member of organization " + userData.Organization)
+		}
+		userData.Organization = orgId
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	err = db.updateOrganization(orgId, func(org *Organization) error {
+		org.Members = append(org.Members, userId)
+		sort.Strings(org.Members)
+		return nil
+	})
+	if err != nil {
+		db.clearUserOrganization(userId, orgId)
+	}
+	return err
+}
+
+// RemoveOrganizationMember removes the user from the organization
+// purchases made before stay on the organization statements
+func (db *CoffeeDb) RemoveOrganizationMember(orgId string, userId string) error {
+	if !ValidRecordId(orgId) {
+		return ErrInvalidOrganizationId
+	}
+	if db.GetOrganizationData(orgId) == nil {
+		return ErrOrganizationNotFound
+	}
+	err := db.updateUser(userId, func(userData *UserCoffeeMembership) error {
+		if userData.Organization != orgId {
+			return errors.New("user is not a member of organization " + orgId)
+		}
+		userData.Organization = ""
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return db.updateOrganization(orgId, func(org *Organization) error {
+		members := make([]string, 0, len(org.Members))
+		for _, m := range org.Members {
+			if m != userId {
+				members = append(members, m)
+			}
+		}
+		org.Members = members
+		return nil
+	})
+}
+
+// ChargeOrganization adds one drink of price to the organization usage in month
+// if check of the organization and its current usage returns nil, checking and
+// charging under one lock keeps concurrent purchases within the caps
+// usage of the previous month is dropped, statements are built from the purchases ledger
+func (db *CoffeeDb) ChargeOrganization(orgId string, month string, price uint64, check func(org *Organization, usage OrganizationUsage) error) error {
+	return db.updateOrganization(orgId, func(org *Organization) error {
+		usage := org.UsageIn(month)
+		if err := check(org, usage); err != nil {
+			return err
+		}
+		usage.Drinks++
+		usage.Spent += price
+		org.Usage = usage
+		return nil
+	})
+}
+
+// RefundOrganization takes back a drink of price charged by ChargeOrganization in month
+func (db *CoffeeDb) RefundOrganization(orgId string, month string, price uint64) error {
+	return db.updateOrganization(orgId, func(org *Organization) error {
+		if org.Usage.Month != month || org.Usage.Drinks == 0 {
+			return nil
+		}
+		org.Usage.Drinks--
+		if org.Usage.Spent >= price {
+			org.Usage.Spent -= price
+		} else {
+			org.Usage.Spent = 0
+		}
+		return nil
+	})
+}
+
+// clearUserOrganization takes back the membership set by AddOrganizationMember
+// if the organization could not be updated
+func (db *CoffeeDb) clearUserOrganization(userId string, orgId string) {
+	err := db.updateUser(userId, func(userData *UserCoffeeMembership) error {
+		if userData.Organization != orgId {
+			return errNoUpdate
+		}
+		userData.Organization = ""
+		return nil
+	})
+	if err != nil {
+		logging.Errorf("clear organization %s of user %s: %v", orgId, userId, err)
+	}
+}
@@ -0,0 +1,116 @@
+package coffeedb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+)
+
+const purchasesFileName = "purchases.jsonl"
+
+// Purchase one coffee bought by a user, stored in an append only ledger
+// Price is in cents, Organization is the user's organization at buying time
+// for coffees bought with a guest pass UserId is the member who issued it
+type Purchase struct {
+	UserId       string     `json:"user_id"`
+	Coffee       CoffeeType `json:"coffee_type"`
+	Time         int64      `json:"time"`
+	Price        uint64     `json:"price"`
+	Organization string     `json:"organization,omitempty"`
+	GuestCode    string     `json:"guest_code,omitempty"`
+}
+
+func (db *CoffeeDb) purchasesFileName() string {
+	return db.dbDataDir + string(os.PathSeparator) + purchasesFileName
+}
+
+// RecordPurchase appends the purchase to the ledger on storage
+func (db *CoffeeDb) RecordPurchase(p *Purchase) error {
+	data, err := json.Marshal(p.stored())
+	if err != nil {
+		return err
+	}
+	db.ledgerLock.Lock()
+	defer db.ledgerLock.Unlock()
+	f, err := os.OpenFile(db.purchasesFileName(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(append(data, '\n')); err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Purchases returns all purchases from the ledger accepted by filter
+func (db *CoffeeDb) Purchases(filter func(p *Purchase) bool) ([]Purchase, error) {
+	db.ledgerLock.Lock()
+	defer db.ledgerLock.Unlock()
+	purchases := []Purchase{}
+	f, err := os.Open(db.purchasesFileName())
+	if errors.Is(err, os.ErrNotExist) {
+		return purchases, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var p Purchase
+		if err := json.Unmarshal(scanner.Bytes(), &p); err != nil {
+			return nil, err
+		}
+		if filter(&p) {
+			purchases = append(purchases, p)
+		}
+	}
+	return purchases, scanner.Err()
+}
+
+// AnonymizePurchases replaces userId with anonymousId in all ledger entries,
+// purchases stay on the ledger for accounting
+func (db *CoffeeDb) AnonymizePurchases(userId string, anonymousId string) error {
+	db.ledgerLock.Lock()
+	defer db.ledgerLock.Unlock()
+	data, err := ioutil.ReadFile(db.purchasesFileName())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var out bytes.Buffer
+	changed := false
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		var p Purchase
+		if err := json.Unmarshal(line, &p); err != nil {
+			return err
+		}
+		if p.UserId == userId {
+			p.UserId = anonymousId
+			changed = true
+			if line, err = json.Marshal(p.stored()); err != nil {
+				return err
+			}
+		}
+		out.Write(line)
+		out.WriteByte('\n')
+	}
+	if err := scanner.Err(); err != nil || !changed {
+		return err
+	}
+	tmpFileName := db.purchasesFileName() + ".tmp"
+	if err := ioutil.WriteFile(tmpFileName, out.Bytes(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpFileName, db.purchasesFileName())
+}
@@ -0,0 +1,47 @@
+package coffeedb
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+)
+
+// records are other entities than users (groups, organizations...)
+// stored as json files in a subfolder of dbDataDir per entity kind
+
+// ValidRecordId record ids are used as file names, allow only letters, digits, - and _
+func ValidRecordId(id string) bool {
+	if len(id) == 0 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+func (db *CoffeeDb) recordFileName(dir string, id string) string {
+	return db.dbDataDir + string(os.PathSeparator) + dir + string(os.PathSeparator) + id + ".json"
+}
+
+func (db *CoffeeDb) readRecord(dir string, id string, v interface{}) error {
+	data, err := ioutil.ReadFile(db.recordFileName(dir, id))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func (db *CoffeeDb) saveRecord(dir string, id string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(db.dbDataDir+string(os.PathSeparator)+dir, os.ModePerm)
+	if err != nil {
+		return err
+	}
+	return writeFileSync(db.recordFileName(dir, id), data)
+}
@@ -0,0 +1,286 @@
+package coffeedb
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const snapshotManifestName = "snapshot.json"
+
+// SnapshotManifest first entry of a snapshot archive
+type SnapshotManifest struct {
+	CreatedAt     int64 `json:"created_at"`
+	SchemaVersion int   `json:"schema_version"`
+	Users         int   `json:"users"`
+	Files         int   `json:"files"`
+}
+
+// lockAll blocks all writers of the db, locks are taken in the order the
+// writers nest them: a group purchase holds groupLock while it takes a user lock
+func (db *CoffeeDb) lockAll() {
+	db.groupLock.Lock()
+	for i := range db.userLocks {
+		db.userLocks[i].Lock()
+	}
+	db.orgLock.Lock()
+	db.ledgerLock.Lock()
+	db.guestLock.Lock()
+}
+
+func (db *CoffeeDb) unlockAll() {
+	db.guestLock.Unlock()
+	db.ledgerLock.Unlock()
+	db.orgLock.Unlock()
+	for i := len(db.userLocks) - 1; i >= 0; i-- {
+		db.userLocks[i].Unlock()
+	}
+	db.groupLock.Unlock()
+}
+
+// snapshotFiles reads all records of the data folder, users not yet flushed
+// in write-behind mode are taken from memory, all writers must be locked
+func (db *CoffeeDb) snapshotFiles() (map[string][]byte, int, error) {
+	files := make(map[string][]byte)
+	users := 0
+	err := filepath.Walk(db.dbDataDir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		name, err := filepath.Rel(db.dbDataDir, filePath)
+		if err != nil {
+			return err
+		}
+		name = filepath.ToSlash(name)
+		if name == walFileName || name == lockFileName || strings.HasSuffix(name, ".tmp") {
+			return nil
+		}
+		data, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			return err
+		}
+		files[name] = data
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	if db.wb != nil {
+		db.wb.lock.Lock()
+		for _, pending := range []map[string]pendingWrite{db.wb.flushing, db.wb.dirty} {
+			for userId, p := range pending {
+				if p.deleted {
+					delete(files, fullUserFileName(userId))
+				} else {
+					files[fullUserFileName(userId)] = p.data
+				}
+			}
+		}
+		db.wb.lock.Unlock()
+	}
+	for name := range files {
+		if !strings.Contains(name, "/") && strings.HasSuffix(name, ".json") {
+			users++
+		}
+	}
+	return files, users, nil
+}
+
+// Snapshot writes a tar.gz archive of all records at one instant,
+// writers are blocked only while records are read into memory
+func (db *CoffeeDb) Snapshot(w io.Writer) (*SnapshotManifest, error) {
+	db.lockAll()
+	files, users, err := db.snapshotFiles()
+	db.unlockAll()
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := SnapshotManifest{CreatedAt: time.Now().Unix(), SchemaVersion: CurrentSchemaVersion, Users: users, Files: len(files)}
+	manifestData, err := json.Marshal(&manifest)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	modTime := time.Unix(manifest.CreatedAt, 0)
+	writeEntry := func(name string, data []byte) error {
+		header := tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), ModTime: modTime, Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(&header); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+	if err := writeEntry(snapshotManifestName, manifestData); err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		if err := writeEntry(name, files[name]); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	return &manifest, gz.Close()
+}
+
+// validateSnapshotFile checks that an archive entry is a known record kind
+// with valid content
+func validateSnapshotFile(name string, data []byte) error {
+	dir, file := path.Split(name)
+	switch {
+	case name == purchasesFileName:
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		for scanner.Scan() {
+			var p Purchase
+			if err := json.Unmarshal(scanner.Bytes(), &p); err != nil {
+				return err
+			}
+		}
+		return scanner.Err()
+	case !strings.HasSuffix(file, ".json") || len(file) == len(".json"):
+		return errors.New("unexpected file")
+	case dir == "":
+		userData, err := decodeUserData(data)
+		if err != nil {
+			return err
+		}
+		return userData.Membership.IsValid()
+	case dir == groupsDir+"/":
+		var group CoffeeGroup
+		if err := json.Unmarshal(data, &group); err != nil {
+			return err
+		}
+		return group.Membership.IsValid()
+	case dir == organizationsDir+"/":
+		var org Organization
+		return json.Unmarshal(data, &org)
+	case dir == guestPassesDir+"/":
+		if !validGuestCode(strings.TrimSuffix(file, ".json")) {
+			return errors.New("invalid guest code")
+		}
+		var pass GuestPass
+		if err := json.Unmarshal(data, &pass); err != nil {
+			return err
+		}
+		return pass.Coffee.IsValid()
+	}
+	return errors.New("unexpected file")
+}
+
+// readSnapshot reads and validates the whole archive before anything is restored
+func readSnapshot(r io.Reader) (*SnapshotManifest, map[string][]byte, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+	var manifest *SnapshotManifest
+	files := make(map[string][]byte)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if header.Typeflag != tar.TypeReg {
+			return nil, nil, fmt.Errorf("%s: not a regular file", header.Name)
+		}
+		name := path.Clean(header.Name)
+		if name != header.Name || path.IsAbs(name) || strings.HasPrefix(name, "../") {
+			return nil, nil, fmt.Errorf("%s: invalid file name", header.Name)
+		}
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, nil, err
+		}
+		if name == snapshotManifestName {
+			manifest = &SnapshotManifest{}
+			if err := json.Unmarshal(data, manifest); err != nil {
+				return nil, nil, fmt.Errorf("%s: %w", name, err)
+			}
+			continue
+		}
+		if _, ok := files[name]; ok {
+			return nil, nil, fmt.Errorf("%s: duplicated file", name)
+		}
+		if err := validateSnapshotFile(name, data); err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", name, err)
+		}
+		files[name] = data
+	}
+	if manifest == nil {
+		return nil, nil, errors.New("snapshot manifest is missing")
+	}
+	if manifest.SchemaVersion > CurrentSchemaVersion {
+		return nil, nil, fmt.Errorf("snapshot schema version %d is newer than supported %d", manifest.SchemaVersion, CurrentSchemaVersion)
+	}
+	if manifest.Files != len(files) {
+		return nil, nil, fmt.Errorf("snapshot has %d files, manifest lists %d", len(files), manifest.Files)
+	}
+	return manifest, files, nil
+}
+
+// ValidateSnapshot reads the whole archive and checks all records without restoring them
+func ValidateSnapshot(r io.Reader) (*SnapshotManifest, error) {
+	manifest, _, err := readSnapshot(r)
+	return manifest, err
+}
+
+// Restore validates the archive and loads it into the db,
+// the db must be empty
+func (db *CoffeeDb) Restore(r io.Reader) (*SnapshotManifest, error) {
+	manifest, files, err := readSnapshot(r)
+	if err != nil {
+		return nil, err
+	}
+
+	db.lockAll()
+	defer db.unlockAll()
+	existing, _, err := db.snapshotFiles()
+	if err != nil {
+		return nil, err
+	}
+	if len(existing) != 0 {
+		return nil, errors.New("restore needs an empty store")
+	}
+	for name, data := range files {
+		fileName := filepath.Join(db.dbDataDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(fileName), os.ModePerm); err != nil {
+			return nil, err
+		}
+		if err := ioutil.WriteFile(fileName, data, 0644); err != nil {
+			return nil, err
+		}
+	}
+	db.users.clear()
+	db.groups = make(map[string]CoffeeGroup)
+	db.index.clear()
+	if err := db.buildIndex(); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
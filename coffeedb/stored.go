@@ -0,0 +1,161 @@
+package coffeedb
+
+// stored* types are the storage form of records, the exported types marshal
+// enums as names for the api while user files, the write-ahead log, groups,
+// guest passes, the ledger and member exports keep them as numbers,
+// the format earlier builds read
+
+type storedGrant struct {
+	Id        string `json:"id"`
+	Coffee    uint8  `json:"coffee_type"`
+	Amount    uint32 `json:"amount"`
+	Used      uint32 `json:"used"`
+	ExpiresAt int64  `json:"expires_at"`
+	Reason    string `json:"reason"`
+	GrantedBy string `json:"granted_by"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+type storedUserStatus struct {
+	Status    uint8  `json:"status"`
+	Until     int64  `json:"until,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+	ChangedBy string `json:"changed_by,omitempty"`
+	ChangedAt int64  `json:"changed_at,omitempty"`
+}
+
+type storedUser struct {
+	SchemaVersion int                       `json:"schema_version"`
+	Membership    uint8                     `json:"membership"`
+	QuotaState    map[uint8]UserCoffeeQuota `json:"quota_state"`
+	Grants        []storedGrant             `json:"grants,omitempty"`
+	Group         string                    `json:"group,omitempty"`
+	Organization  string                    `json:"organization,omitempty"`
+	GuestQuota    *UserCoffeeQuota          `json:"guest_quota,omitempty"`
+	Status        storedUserStatus          `json:"status"`
+}
+
+type storedGroupMember struct {
+	SubLimits map[uint8]uint32 `json:"sub_limits,omitempty"`
+}
+
+type storedGroup struct {
+	Membership uint8                        `json:"membership"`
+	Members    map[string]storedGroupMember `json:"members"`
+	QuotaState map[uint8]UserCoffeeQuota    `json:"quota_state"`
+}
+
+type storedPurchase struct {
+	UserId       string `json:"user_id"`
+	Coffee       uint8  `json:"coffee_type"`
+	Time         int64  `json:"time"`
+	Price        uint64 `json:"price"`
+	Organization string `json:"organization,omitempty"`
+	GuestCode    string `json:"guest_code,omitempty"`
+}
+
+type storedGuestPass struct {
+	IssuedBy   string `json:"issued_by"`
+	Coffee     uint8  `json:"coffee_type"`
+	CreatedAt  int64  `json:"created_at"`
+	ExpiresAt  int64  `json:"expires_at"`
+	RedeemedAt int64  `json:"redeemed_at,omitempty"`
+}
+
+type storedMemberRecord struct {
+	UserId     string                    `json:"user_id"`
+	Membership uint8                     `json:"membership"`
+	QuotaState map[uint8]UserCoffeeQuota `json:"quota_state,omitempty"`
+}
+
+func storedQuotaState(quotaState map[CoffeeType]UserCoffeeQuota) map[uint8]UserCoffeeQuota {
+	if quotaState == nil {
+		return nil
+	}
+	stored := make(map[uint8]UserCoffeeQuota, len(quotaState))
+	for coffee, quota := range quotaState {
+		stored[uint8(coffee)] = quota
+	}
+	return stored
+}
+
+func (u *UserCoffeeMembership) stored() *storedUser {
+	stored := &storedUser{
+		SchemaVersion: u.SchemaVersion,
+		Membership:    uint8(u.Membership),
+		QuotaState:    storedQuotaState(u.QuotaState),
+		Group:         u.Group,
+		Organization:  u.Organization,
+		GuestQuota:    u.GuestQuota,
+		Status: storedUserStatus{
+			Status:    uint8(u.Status.Status),
+			Until:     u.Status.Until,
+			Reason:    u.Status.Reason,
+			ChangedBy: u.Status.ChangedBy,
+			ChangedAt: u.Status.ChangedAt,
+		},
+	}
+	for _, g := range u.Grants {
+		stored.Grants = append(stored.Grants, storedGrant{
+			Id:        g.Id,
+			Coffee:    uint8(g.Coffee),
+			Amount:    g.Amount,
+			Used:      g.Used,
+			ExpiresAt: g.ExpiresAt,
+			Reason:    g.Reason,
+			GrantedBy: g.GrantedBy,
+			CreatedAt: g.CreatedAt,
+		})
+	}
+	return stored
+}
+
+func (g *CoffeeGroup) stored() *storedGroup {
+	stored := &storedGroup{
+		Membership: uint8(g.Membership),
+		QuotaState: storedQuotaState(g.QuotaState),
+	}
+	if g.Members != nil {
+		stored.Members = make(map[string]storedGroupMember, len(g.Members))
+	}
+	for id, member := range g.Members {
+		var storedMember storedGroupMember
+		if member.SubLimits != nil {
+			storedMember.SubLimits = make(map[uint8]uint32, len(member.SubLimits))
+		}
+		for coffee, limit := range member.SubLimits {
+			storedMember.SubLimits[uint8(coffee)] = limit
+		}
+		stored.Members[id] = storedMember
+	}
+	return stored
+}
+
+func (p *Purchase) stored() *storedPurchase {
+	return &storedPurchase{
+		UserId:       p.UserId,
+		Coffee:       uint8(p.Coffee),
+		Time:         p.Time,
+		Price:        p.Price,
+		Organization: p.Organization,
+		GuestCode:    p.GuestCode,
+	}
+}
+
+func (p *GuestPass) stored() *storedGuestPass {
+	return &storedGuestPass{
+		IssuedBy:   p.IssuedBy,
+		Coffee:     uint8(p.Coffee),
+		CreatedAt:  p.CreatedAt,
+		ExpiresAt:  p.ExpiresAt,
+		RedeemedAt: p.RedeemedAt,
+	}
+}
+
+func (rec *MemberRecord) stored() *storedMemberRecord {
+	return &storedMemberRecord{
+		UserId:     rec.UserId,
+		Membership: uint8(rec.Membership),
+		QuotaState: storedQuotaState(rec.QuotaState),
+	}
+}
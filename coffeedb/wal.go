@@ -0,0 +1,259 @@
+package coffeedb
+
+import (
+	"CoffeeShop/logging"
+	"encoding/binary"
+	"encoding/json"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+)
+
+// walOp mutation recorded in the write-ahead log, every record holds
+// the full state of the user after the mutation so replay is idempotent
+type walOp uint8
+
+const (
+	walRegister walOp = iota + 1
+	walUpdate
+	walDelete
+)
+
+// walHeaderSize record layout is
+// [4 bytes payload length][4 bytes crc32c of payload][payload]
+// payload is walRecord as json, integers are little endian
+const walHeaderSize = 8
+
+var walCrcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// walRecord one user mutation in the write-ahead log, Data is empty for deleted users
+type walRecord struct {
+	Op     walOp           `json:"op"`
+	UserId string          `json:"user_id"`
+	Data   json.RawMessage `json:"data,omitempty"`
+}
+
+func encodeWalRecord(record *walRecord) ([]byte, error) {
+	payload, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, walHeaderSize+len(payload))
+	binary.LittleEndian.PutUint32(buf[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(buf[4:8], crc32.Checksum(payload, walCrcTable))
+	copy(buf[walHeaderSize:], payload)
+	return buf, nil
+}
+
+// valid reports if the record can be applied, a record of a registered or
+// updated user must hold a valid user
+func (r *walRecord) valid() bool {
+	if len(r.UserId) == 0 {
+		return false
+	}
+	switch r.Op {
+	case walDelete:
+		return len(r.Data) == 0
+	case walRegister, walUpdate:
+		userData, err := decodeUserData(r.Data)
+		if err != nil {
+			return false
+		}
+		return userData.Membership.IsValid() == nil
+	}
+	return false
+}
+
+// decodeWalRecords returns records up to the first torn, corrupted or
+// invalid record and the offset where the valid records end
+// a torn last record belongs to a write which was never acknowledged
+func decodeWalRecords(data []byte) ([]walRecord, int) {
+	var records []walRecord
+	offset := 0
+	for len(data)-offset >= walHeaderSize {
+		length := int(binary.LittleEndian.Uint32(data[offset : offset+4]))
+		checksum := binary.LittleEndian.Uint32(data[offset+4 : offset+8])
+		end := offset + walHeaderSize + length
+		if length <= 0 || end > len(data) || end < offset {
+			break
+		}
+		payload := data[offset+walHeaderSize : end]
+		if crc32.Checksum(payload, walCrcTable) != checksum {
+			break
+		}
+		var record walRecord
+		if err := json.Unmarshal(payload, &record); err != nil || !record.valid() {
+			break
+		}
+		records = append(records, record)
+		offset = end
+	}
+	return records, offset
+}
+
+// walTruncateRecords without write-behind the log is truncated after this
+// many records
+const walTruncateRecords = 1000
+
+// walLog every user mutation is appended to the write-ahead log and flushed
+// to the disk before it is applied, in write-behind mode a checkpoint
+// rewrites the log with users which are not written yet, otherwise user's
+// file is written right after the record and the log is truncated every
+// truncateAfter records
+// records appended while the log is synced wait in the next batch which is
+// written and synced at once by one of its writers (group commit)
+type walLog struct {
+	file          *os.File
+	records       int
+	truncateAfter int
+	lock          sync.Mutex
+	synced        *sync.Cond
+	syncing       bool
+	batch         *walBatch
+}
+
+// walBatch records which are written to the log with one sync, err is the
+// result of the sync and is set once done
+type walBatch struct {
+	data    []byte
+	records int
+	done    bool
+	err     error
+}
+
+func (wal *walLog) init() {
+	wal.synced = sync.NewCond(&wal.lock)
+	wal.batch = &walBatch{}
+}
+
+// appendWal adds the record to the next batch and returns once the batch is
+// written to the end of the log and the log is flushed to the disk
+func (db *CoffeeDb) appendWal(record *walRecord) error {
+	line, err := encodeWalRecord(record)
+	if err != nil {
+		return err
+	}
+	wal := &db.wal
+	wal.lock.Lock()
+	defer wal.lock.Unlock()
+	batch := wal.batch
+	batch.data = append(batch.data, line...)
+	batch.records++
+	for !batch.done {
+		if wal.syncing {
+			wal.synced.Wait()
+			continue
+		}
+		db.syncBatchLocked()
+	}
+	return batch.err
+}
+
+// syncBatchLocked writes and syncs the pending batch, wal.lock must be held
+// and no other batch may be syncing, the lock is released during the write
+// so new records gather in the next batch
+func (db *CoffeeDb) syncBatchLocked() {
+	wal := &db.wal
+	batch := wal.batch
+	wal.batch = &walBatch{}
+	wal.syncing = true
+	truncate := wal.truncateAfter > 0 && wal.records >= wal.truncateAfter
+	wal.lock.Unlock()
+	err := db.writeBatch(batch, truncate)
+	wal.lock.Lock()
+	if err == nil {
+		if truncate {
+			wal.records = 0
+		}
+		wal.records += batch.records
+	}
+	batch.err = err
+	batch.done = true
+	wal.syncing = false
+	wal.synced.Broadcast()
+}
+
+// writeBatch appends the batch to the log file and flushes it to the disk,
+// only the writer syncing the batch uses the file
+func (db *CoffeeDb) writeBatch(batch *walBatch, truncate bool) error {
+	wal := &db.wal
+	var err error
+	if wal.file == nil {
+		wal.file, err = os.OpenFile(db.walFileName(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+	}
+	if truncate {
+		//earlier records are in user files already or were never acknowledged
+		if err := wal.file.Truncate(0); err != nil {
+			return err
+		}
+	}
+	offset, err := wal.file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if _, err = wal.file.Write(batch.data); err == nil {
+		err = wal.file.Sync()
+	}
+	if err != nil {
+		//drop the torn batch, replay stops at it and would miss later records
+		if truncateErr := wal.file.Truncate(offset); truncateErr != nil {
+			logging.Errorf("write-ahead log: truncate torn record: %v", truncateErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// syncAllLocked waits for the syncing batch and syncs the pending one,
+// wal.lock must be held
+func (db *CoffeeDb) syncAllLocked() {
+	wal := &db.wal
+	for wal.syncing || wal.batch.records > 0 {
+		if wal.syncing {
+			wal.synced.Wait()
+			continue
+		}
+		db.syncBatchLocked()
+	}
+}
+
+// replaceWal replaces the log with records which are encoded in data
+func (db *CoffeeDb) replaceWal(data []byte) error {
+	wal := &db.wal
+	wal.lock.Lock()
+	defer wal.lock.Unlock()
+	db.syncAllLocked()
+	wal.closeLocked()
+	tmpFileName := db.walFileName() + ".tmp"
+	if err := writeFileSync(tmpFileName, data); err != nil {
+		return err
+	}
+	return os.Rename(tmpFileName, db.walFileName())
+}
+
+// closeWal closes the log file, with truncate the log is emptied first,
+// it is done when all records are in user files
+func (db *CoffeeDb) closeWal(truncate bool) error {
+	wal := &db.wal
+	wal.lock.Lock()
+	defer wal.lock.Unlock()
+	db.syncAllLocked()
+	var err error
+	if truncate && wal.file != nil {
+		err = wal.file.Truncate(0)
+	}
+	wal.closeLocked()
+	return err
+}
+
+func (wal *walLog) closeLocked() {
+	if wal.file != nil {
+		wal.file.Close()
+		wal.file = nil
+	}
+	wal.records = 0
+}
@@ -0,0 +1,229 @@
+package coffeedb
+
+import (
+	"CoffeeShop/logging"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+const walFileName = "wal.log"
+
+// pendingWrite user's state accepted in memory but not yet flushed to user's file
+type pendingWrite struct {
+	data    []byte
+	deleted bool
+}
+
+// writeBehind in write-behind mode logged user mutations are kept as dirty, a checkpoint (Flush) writes dirty users
+// to their files in batches and rewrites the log with users which are still dirty
+type writeBehind struct {
+	dirty     map[string]pendingWrite
+	flushing  map[string]pendingWrite
+	batchSize int
+	lock      sync.Mutex
+	flushLock sync.Mutex
+	flushCh   chan struct{}
+	stopCh    chan struct{}
+	done      chan struct{}
+}
+
+func (db *CoffeeDb) walFileName() string {
+	return db.dbDataDir + string(os.PathSeparator) + walFileName
+}
+
+// pending returns user's state which is not flushed yet
+func (wb *writeBehind) pending(userId string) (pendingWrite, bool) {
+	wb.lock.Lock()
+	defer wb.lock.Unlock()
+	if p, ok := wb.dirty[userId]; ok {
+		return p, true
+	}
+	p, ok := wb.flushing[userId]
+	return p, ok
+}
+
+// appendDirty logs user's state and marks the user dirty, both under
+// wb.lock so a checkpoint never drops the record of a user which is not
+// written yet, a batch flush is requested once batchSize users are dirty
+func (db *CoffeeDb) appendDirty(record *walRecord, p pendingWrite) error {
+	wb := db.wb
+	wb.lock.Lock()
+	defer wb.lock.Unlock()
+	if err := db.appendWal(record); err != nil {
+		return err
+	}
+	wb.dirty[record.UserId] = p
+	if wb.batchSize > 0 && len(wb.dirty) >= wb.batchSize {
+		select {
+		case wb.flushCh <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// rewriteWalLocked replaces the write-ahead log with records of users
+// which are still dirty, wb.lock must be held
+func (db *CoffeeDb) rewriteWalLocked() error {
+	wb := db.wb
+	var data []byte
+	for userId, p := range wb.dirty {
+		record := walRecord{Op: walUpdate, UserId: userId, Data: p.data}
+		if p.deleted {
+			record = walRecord{Op: walDelete, UserId: userId}
+		}
+		line, err := encodeWalRecord(&record)
+		if err != nil {
+			return err
+		}
+		data = append(data, line...)
+	}
+	return db.replaceWal(data)
+}
+
+// writePending writes the user's state to user's file, user's lock must be held
+func (db *CoffeeDb) writePending(userId string, p pendingWrite) error {
+	if p.deleted {
+		err := os.Remove(db.dbDataDir + string(os.PathSeparator) + fullUserFileName(userId))
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	return db.persistOnStorage(userId, p.data)
+}
+
+// Flush checkpoints the write-ahead log into the main store: writes all dirty
+// users to their files and drops their records from the log
+// it does nothing if write-behind mode is off
+func (db *CoffeeDb) Flush() error {
+	wb := db.wb
+	if wb == nil {
+		return nil
+	}
+	wb.flushLock.Lock()
+	defer wb.flushLock.Unlock()
+
+	wb.lock.Lock()
+	if len(wb.dirty) == 0 {
+		wb.lock.Unlock()
+		return nil
+	}
+	wb.flushing = wb.dirty
+	wb.dirty = make(map[string]pendingWrite)
+	userIds := make([]string, 0, len(wb.flushing))
+	for userId := range wb.flushing {
+		userIds = append(userIds, userId)
+	}
+	wb.lock.Unlock()
+
+	var flushErr error
+	for _, userId := range userIds {
+		lock := db.userLock(userId)
+		lock.Lock()
+		wb.lock.Lock()
+		p, ok := wb.flushing[userId]
+		wb.lock.Unlock()
+		if ok {
+			flushErr = db.writePending(userId, p)
+		}
+		if flushErr == nil {
+			wb.lock.Lock()
+			delete(wb.flushing, userId)
+			wb.lock.Unlock()
+		}
+		lock.Unlock()
+		if flushErr != nil {
+			break
+		}
+	}
+
+	wb.lock.Lock()
+	defer wb.lock.Unlock()
+	if flushErr != nil {
+		//keep users which were not written as dirty, the log still has them
+		for userId, p := range wb.flushing {
+			if _, ok := wb.dirty[userId]; !ok {
+				wb.dirty[userId] = p
+			}
+		}
+		wb.flushing = nil
+		return flushErr
+	}
+	wb.flushing = nil
+	return db.rewriteWalLocked()
+}
+
+func (db *CoffeeDb) flushLoop(interval time.Duration) {
+	wb := db.wb
+	defer close(wb.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-wb.flushCh:
+		case <-wb.stopCh:
+			return
+		}
+		if err := db.Flush(); err != nil {
+			logging.Errorf("write-behind flush failed: %v", err)
+		}
+	}
+}
+
+// replayWal writes users from the write-ahead log left by a previous run
+// to their files and removes the log, replay stops at the first torn or
+// corrupted record
+func (db *CoffeeDb) replayWal() error {
+	data, err := ioutil.ReadFile(db.walFileName())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	records, end := decodeWalRecords(data)
+	if end != len(data) {
+		logging.Warnf("write-ahead log: dropped %d bytes after the last valid record", len(data)-end)
+	}
+	users := make(map[string]pendingWrite)
+	for _, record := range records {
+		users[record.UserId] = pendingWrite{data: record.Data, deleted: record.Op == walDelete}
+	}
+	for userId, p := range users {
+		if err := db.writePending(userId, p); err != nil {
+			return err
+		}
+	}
+	return os.Remove(db.walFileName())
+}
+
+// Close flushes dirty users, stops background flushing, closes the
+// write-ahead log and removes the lock file once all changes are applied
+func (db *CoffeeDb) Close() error {
+	err := db.closeLog()
+	if err == nil {
+		err = db.removeLockFile()
+	}
+	return err
+}
+
+func (db *CoffeeDb) closeLog() error {
+	wb := db.wb
+	if wb == nil {
+		return db.closeWal(true)
+	}
+	close(wb.stopCh)
+	<-wb.done
+	err := db.Flush()
+	wb.lock.Lock()
+	defer wb.lock.Unlock()
+	if closeErr := db.closeWal(false); err == nil {
+		err = closeErr
+	}
+	return err
+}
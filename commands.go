@@ -0,0 +1,180 @@
+package main
+
+import (
+	"CoffeeShop/coffeedb"
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+)
+
+// runCommand runs a maintenance command instead of the server, commands
+// refuse to open a data folder which a running server uses
+func runCommand(name string, args []string) {
+	switch name {
+	case "migrate":
+		runMigrate(args)
+	case "snapshot":
+		runSnapshot(args)
+	case "restore":
+		runRestore(args)
+	case "import":
+		runImport(args)
+	case "export":
+		runExport(args)
+	default:
+		log.Fatalf("unknown command %q, available commands: migrate, snapshot, restore, import, export", name)
+	}
+}
+
+// openDb opens the data folder of a command, opening replays the
+// write-ahead log so the folder must not be in use
+func openDb(dataDir string) *coffeedb.CoffeeDb {
+	if err := coffeedb.CheckNotInUse(dataDir); err != nil {
+		log.Fatal(err)
+	}
+	db, err := coffeedb.Init(dataDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return db
+}
+
+// closeDb closes the db of a command and returns the first error
+func closeDb(db *coffeedb.CoffeeDb, err error) error {
+	if closeErr := db.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// runMigrate rewrites all user records of the data folder with the latest schema version
+func runMigrate(args []string) {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	dataDir := fs.String("data", defaultDataDir(), "data folder")
+	dryRun := fs.Bool("dry-run", false, "only report records which would be migrated")
+	fs.Parse(args)
+
+	db := openDb(*dataDir)
+	report, err := db.MigrateAll(*dryRun)
+	if err = closeDb(db, err); err != nil {
+		log.Fatal(err)
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(report)
+	if len(report.Failed) != 0 {
+		os.Exit(1)
+	}
+}
+
+// runSnapshot writes a tar.gz archive of the data folder
+func runSnapshot(args []string) {
+	fs := flag.NewFlagSet("snapshot", flag.ExitOnError)
+	dataDir := fs.String("data", defaultDataDir(), "data folder")
+	out := fs.String("out", "", "archive file, stdout if empty")
+	fs.Parse(args)
+
+	w := os.Stdout
+	if len(*out) != 0 {
+		var err error
+		if w, err = os.Create(*out); err != nil {
+			log.Fatal(err)
+		}
+	}
+	db := openDb(*dataDir)
+	manifest, err := db.Snapshot(w)
+	if err == nil {
+		err = w.Close()
+	}
+	if err = closeDb(db, err); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("snapshot of %d users, %d files", manifest.Users, manifest.Files)
+}
+
+// runRestore validates the archive and loads it into an empty data folder
+func runRestore(args []string) {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	dataDir := fs.String("data", defaultDataDir(), "data folder, must be empty")
+	in := fs.String("in", "", "archive file, stdin if empty")
+	validateOnly := fs.Bool("validate", false, "only validate the archive")
+	fs.Parse(args)
+
+	r := os.Stdin
+	if len(*in) != 0 {
+		var err error
+		if r, err = os.Open(*in); err != nil {
+			log.Fatal(err)
+		}
+		defer r.Close()
+	}
+	if *validateOnly {
+		manifest, err := coffeedb.ValidateSnapshot(r)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("valid snapshot of %d users, %d files", manifest.Users, manifest.Files)
+		return
+	}
+	db := openDb(*dataDir)
+	manifest, err := db.Restore(r)
+	if err = closeDb(db, err); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("restored %d users, %d files", manifest.Users, manifest.Files)
+}
+
+// runImport registers all users of a CSV or JSONL file and prints the report
+func runImport(args []string) {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	dataDir := fs.String("data", defaultDataDir(), "data folder")
+	format := fs.String("format", coffeedb.FormatCsv, "file format, csv or jsonl")
+	in := fs.String("in", "", "members file, stdin if empty")
+	fs.Parse(args)
+
+	r := os.Stdin
+	if len(*in) != 0 {
+		var err error
+		if r, err = os.Open(*in); err != nil {
+			log.Fatal(err)
+		}
+		defer r.Close()
+	}
+	db := openDb(*dataDir)
+	report, err := db.ImportMembers(r, *format)
+	if err = closeDb(db, err); err != nil {
+		log.Fatal(err)
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(report)
+	if len(report.Failed) != 0 {
+		os.Exit(1)
+	}
+}
+
+// runExport writes all users as CSV or JSONL
+func runExport(args []string) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	dataDir := fs.String("data", defaultDataDir(), "data folder")
+	format := fs.String("format", coffeedb.FormatCsv, "file format, csv or jsonl")
+	out := fs.String("out", "", "members file, stdout if empty")
+	fs.Parse(args)
+
+	w := os.Stdout
+	if len(*out) != 0 {
+		var err error
+		if w, err = os.Create(*out); err != nil {
+			log.Fatal(err)
+		}
+	}
+	db := openDb(*dataDir)
+	err := db.ExportMembers(w, *format)
+	if err == nil {
+		err = w.Close()
+	}
+	if err = closeDb(db, err); err != nil {
+		log.Fatal(err)
+	}
+}
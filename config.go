@@ -0,0 +1,309 @@
+package main
+
+import (
+	"CoffeeShop/coffeedb"
+	"CoffeeShop/logging"
+	"CoffeeShop/shopapi"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// environment variables, they override the config file and are overridden by flags
+const (
+	envAddr            = "COFFEESHOP_ADDR"
+	envGrpcAddr        = "COFFEESHOP_GRPC_ADDR"
+	envDataDir         = "COFFEESHOP_DATA_DIR"
+	envConfig          = "COFFEESHOP_CONFIG"
+	envShutdownTimeout = "COFFEESHOP_SHUTDOWN_TIMEOUT"
+	envLogLevel        = "COFFEESHOP_LOG_LEVEL"
+	envIdempotencyTTL  = "COFFEESHOP_IDEMPOTENCY_TTL"
+	envReadTimeout     = "COFFEESHOP_READ_TIMEOUT"
+	envWriteTimeout    = "COFFEESHOP_WRITE_TIMEOUT"
+	envIdleTimeout     = "COFFEESHOP_IDLE_TIMEOUT"
+	envMaxBodyBytes    = "COFFEESHOP_MAX_BODY_BYTES"
+	envMaxImportBytes  = "COFFEESHOP_MAX_IMPORT_BYTES"
+	envWriteBehind     = "COFFEESHOP_WRITE_BEHIND"
+	envFlushInterval   = "COFFEESHOP_FLUSH_INTERVAL"
+	envCacheSize       = "COFFEESHOP_CACHE_SIZE"
+	envCacheTTL        = "COFFEESHOP_CACHE_TTL"
+)
+
+// duration is a time.Duration written as "5s" in the config file
+type duration time.Duration
+
+func (d duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func (d *duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = duration(v)
+	return nil
+}
+
+// serverConfig effective server settings
+type serverConfig struct {
+	ConfigFile      string   `json:"config_file,omitempty"`
+	Addr            string   `json:"addr"`
+	GrpcAddr        string   `json:"grpc_addr"`
+	DataDir         string   `json:"data_dir"`
+	ShutdownTimeout duration `json:"shutdown_timeout"`
+	LogLevel        string   `json:"log_level"`
+	IdempotencyTTL  duration `json:"idempotency_ttl"`
+	ReadTimeout     duration `json:"read_timeout"`
+	WriteTimeout    duration `json:"write_timeout"`
+	IdleTimeout     duration `json:"idle_timeout"`
+	MaxBodyBytes    int64    `json:"max_body_bytes"`
+	MaxImportBytes  int64    `json:"max_import_bytes"`
+	WriteBehind     bool     `json:"write_behind"`
+	FlushInterval   duration `json:"flush_interval"`
+	CacheSize       int      `json:"cache_size"`
+	CacheTTL        duration `json:"cache_ttl"`
+}
+
+func defaultServerConfig() serverConfig {
+	return serverConfig{
+		Addr:            ":8080",
+		GrpcAddr:        ":9090",
+		DataDir:         "Data",
+		ShutdownTimeout: duration(5 * time.Second),
+		LogLevel:        "info",
+		IdempotencyTTL:  duration(shopapi.DefaultIdempotencyTTL),
+		ReadTimeout:     duration(shopapi.DefaultReadTimeout),
+		WriteTimeout:    duration(shopapi.DefaultWriteTimeout),
+		IdleTimeout:     duration(shopapi.DefaultIdleTimeout),
+		MaxBodyBytes:    shopapi.DefaultMaxBodyBytes,
+		MaxImportBytes:  shopapi.DefaultMaxImportBytes,
+		FlushInterval:   duration(coffeedb.DefaultOptions().FlushInterval),
+		CacheSize:       coffeedb.DefaultOptions().CacheSize,
+		CacheTTL:        duration(coffeedb.DefaultOptions().CacheTTL),
+	}
+}
+
+// defaultDataDir data folder of the maintenance commands
+func defaultDataDir() string {
+	if dataDir, ok := os.LookupEnv(envDataDir); ok {
+		return dataDir
+	}
+	return defaultServerConfig().DataDir
+}
+
+// loadFile reads settings present in the JSON config file, unknown keys
+// are rejected so a misspelled setting is not silently ignored
+func (c *serverConfig) loadFile(fileName string) error {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(c); err != nil {
+		return fmt.Errorf("config file %s: %w", fileName, err)
+	}
+	c.ConfigFile = fileName
+	return nil
+}
+
+// loadEnv reads settings present in the environment, lookupEnv is os.LookupEnv
+// outside of tests
+func (c *serverConfig) loadEnv(lookupEnv func(string) (string, bool)) error {
+	if v, ok := lookupEnv(envAddr); ok {
+		c.Addr = v
+	}
+	if v, ok := lookupEnv(envGrpcAddr); ok {
+		c.GrpcAddr = v
+	}
+	if v, ok := lookupEnv(envDataDir); ok {
+		c.DataDir = v
+	}
+	if v, ok := lookupEnv(envShutdownTimeout); ok {
+		if err := c.ShutdownTimeout.UnmarshalText([]byte(v)); err != nil {
+			return fmt.Errorf("%s: %w", envShutdownTimeout, err)
+		}
+	}
+	if v, ok := lookupEnv(envLogLevel); ok {
+		c.LogLevel = v
+	}
+	if v, ok := lookupEnv(envIdempotencyTTL); ok {
+		if err := c.IdempotencyTTL.UnmarshalText([]byte(v)); err != nil {
+			return fmt.Errorf("%s: %w", envIdempotencyTTL, err)
+		}
+	}
+	for env, d := range map[string]*duration{envReadTimeout: &c.ReadTimeout, envWriteTimeout: &c.WriteTimeout, envIdleTimeout: &c.IdleTimeout} {
+		if v, ok := lookupEnv(env); ok {
+			if err := d.UnmarshalText([]byte(v)); err != nil {
+				return fmt.Errorf("%s: %w", env, err)
+			}
+		}
+	}
+	for env, n := range map[string]*int64{envMaxBodyBytes: &c.MaxBodyBytes, envMaxImportBytes: &c.MaxImportBytes} {
+		if v, ok := lookupEnv(env); ok {
+			value, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return fmt.Errorf("%s: %w", env, err)
+			}
+			*n = value
+		}
+	}
+	if v, ok := lookupEnv(envWriteBehind); ok {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("%s: %w", envWriteBehind, err)
+		}
+		c.WriteBehind = b
+	}
+	if v, ok := lookupEnv(envFlushInterval); ok {
+		if err := c.FlushInterval.UnmarshalText([]byte(v)); err != nil {
+			return fmt.Errorf("%s: %w", envFlushInterval, err)
+		}
+	}
+	if v, ok := lookupEnv(envCacheSize); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("%s: %w", envCacheSize, err)
+		}
+		c.CacheSize = n
+	}
+	if v, ok := lookupEnv(envCacheTTL); ok {
+		if err := c.CacheTTL.UnmarshalText([]byte(v)); err != nil {
+			return fmt.Errorf("%s: %w", envCacheTTL, err)
+		}
+	}
+	return nil
+}
+
+func (c *serverConfig) validate() error {
+	if len(c.Addr) == 0 {
+		return errors.New("empty listen address")
+	}
+	if c.GrpcAddr == c.Addr {
+		return errors.New("grpc and http listen on the same address")
+	}
+	if len(c.DataDir) == 0 {
+		return errors.New("empty data directory")
+	}
+	if c.ShutdownTimeout <= 0 {
+		return errors.New("shutdown timeout must be positive")
+	}
+	if c.IdempotencyTTL <= 0 {
+		return errors.New("idempotency ttl must be positive")
+	}
+	if c.ReadTimeout <= 0 || c.WriteTimeout <= 0 || c.IdleTimeout <= 0 {
+		return errors.New("read, write and idle timeouts must be positive")
+	}
+	if c.MaxBodyBytes <= 0 || c.MaxImportBytes <= 0 {
+		return errors.New("max body and import bytes must be positive")
+	}
+	if c.FlushInterval <= 0 {
+		return errors.New("flush interval must be positive")
+	}
+	if c.CacheSize < 0 || c.CacheTTL < 0 {
+		return errors.New("cache size and ttl must not be negative")
+	}
+	_, err := logging.ParseLevel(c.LogLevel)
+	return err
+}
+
+// parseServerConfig returns the settings from defaults, config file,
+// environment and flags, later sources override earlier ones
+func parseServerConfig(args []string, lookupEnv func(string) (string, bool)) (*serverConfig, bool, error) {
+	c := defaultServerConfig()
+	fs := flag.NewFlagSet("CoffeeShop", flag.ContinueOnError)
+	defaultConfigFile, _ := lookupEnv(envConfig)
+	configFile := fs.String("config", defaultConfigFile, "JSON config file, env "+envConfig)
+	addr := fs.String("addr", "", "listen address (default "+c.Addr+"), env "+envAddr)
+	grpcAddr := fs.String("grpc-addr", "", "grpc listen address, empty disables grpc (default "+c.GrpcAddr+"), env "+envGrpcAddr)
+	dataDir := fs.String("data", "", "data directory, absolute or relative to the working directory (default "+c.DataDir+"), env "+envDataDir)
+	shutdownTimeout := fs.Duration("shutdown-timeout", 0, "time to finish open requests on shutdown (default "+time.Duration(c.ShutdownTimeout).String()+"), env "+envShutdownTimeout)
+	logLevel := fs.String("log-level", "", "debug, info, warn or error (default "+c.LogLevel+"), env "+envLogLevel)
+	idempotencyTTL := fs.Duration("idempotency-ttl", 0, "how long purchases with an Idempotency-Key are replayed (default "+time.Duration(c.IdempotencyTTL).String()+"), env "+envIdempotencyTTL)
+	readTimeout := fs.Duration("read-timeout", 0, "time to read request headers and body (default "+time.Duration(c.ReadTimeout).String()+"), env "+envReadTimeout)
+	writeTimeout := fs.Duration("write-timeout", 0, "time to handle a request and write the response (default "+time.Duration(c.WriteTimeout).String()+"), env "+envWriteTimeout)
+	idleTimeout := fs.Duration("idle-timeout", 0, "time keep-alive connections wait for the next request (default "+time.Duration(c.IdleTimeout).String()+"), env "+envIdleTimeout)
+	maxBodyBytes := fs.Int64("max-body-bytes", 0, "size limit of JSON request bodies (default "+strconv.FormatInt(c.MaxBodyBytes, 10)+"), env "+envMaxBodyBytes)
+	writeBehind := fs.Bool("write-behind", false, "log changes to the write-ahead log and write user files in batches, env "+envWriteBehind)
+	flushInterval := fs.Duration("flush-interval", 0, "time between write-behind flushes (default "+time.Duration(c.FlushInterval).String()+"), env "+envFlushInterval)
+	cacheSize := fs.Int("cache-size", 0, "users kept in memory, 0 is unbounded (default "+strconv.Itoa(c.CacheSize)+"), env "+envCacheSize)
+	cacheTTL := fs.Duration("cache-ttl", 0, "time users are kept in memory, 0 is no expiry (default "+time.Duration(c.CacheTTL).String()+"), env "+envCacheTTL)
+	maxImportBytes := fs.Int64("max-import-bytes", 0, "size limit of bulk import bodies (default "+strconv.FormatInt(c.MaxImportBytes, 10)+"), env "+envMaxImportBytes)
+	printConfig := fs.Bool("print-config", false, "print the effective config and exit")
+	if err := fs.Parse(args); err != nil {
+		return nil, false, err
+	}
+	if fs.NArg() != 0 {
+		return nil, false, fmt.Errorf("unexpected arguments %v", fs.Args())
+	}
+
+	if len(*configFile) != 0 {
+		if err := c.loadFile(*configFile); err != nil {
+			return nil, false, err
+		}
+	}
+	if err := c.loadEnv(lookupEnv); err != nil {
+		return nil, false, err
+	}
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "addr":
+			c.Addr = *addr
+		case "grpc-addr":
+			c.GrpcAddr = *grpcAddr
+		case "data":
+			c.DataDir = *dataDir
+		case "shutdown-timeout":
+			c.ShutdownTimeout = duration(*shutdownTimeout)
+		case "log-level":
+			c.LogLevel = *logLevel
+		case "idempotency-ttl":
+			c.IdempotencyTTL = duration(*idempotencyTTL)
+		case "read-timeout":
+			c.ReadTimeout = duration(*readTimeout)
+		case "write-timeout":
+			c.WriteTimeout = duration(*writeTimeout)
+		case "idle-timeout":
+			c.IdleTimeout = duration(*idleTimeout)
+		case "max-body-bytes":
+			c.MaxBodyBytes = *maxBodyBytes
+		case "max-import-bytes":
+			c.MaxImportBytes = *maxImportBytes
+		case "write-behind":
+			c.WriteBehind = *writeBehind
+		case "flush-interval":
+			c.FlushInterval = duration(*flushInterval)
+		case "cache-size":
+			c.CacheSize = *cacheSize
+		case "cache-ttl":
+			c.CacheTTL = duration(*cacheTTL)
+		}
+	})
+	if err := c.validate(); err != nil {
+		return nil, false, err
+	}
+	return &c, *printConfig, nil
+}
+
+// writeConfig writes the effective settings as a config file, the data
+// directory is made absolute
+func writeConfig(w io.Writer, c *serverConfig) error {
+	printed := *c
+	dataDir, err := filepath.Abs(c.DataDir)
+	if err != nil {
+		return err
+	}
+	printed.DataDir = dataDir
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(&printed)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func envLookup(env map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		v, ok := env[key]
+		return v, ok
+	}
+}
+
+func writeConfigFile(t *testing.T, data string) string {
+	fileName := filepath.Join(t.TempDir(), "config.json")
+	if err := ioutil.WriteFile(fileName, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return fileName
+}
+
+func TestServerConfigPrecedence(t *testing.T) {
+	t.Parallel()
+	configFile := writeConfigFile(t, `{"addr":":1","log_level":"warn","idempotency_ttl":"1m","cache_size":5,"write_behind":true}`)
+	otherFile := writeConfigFile(t, `{"addr":":4"}`)
+
+	cases := []struct {
+		name string
+		env  map[string]string
+		args []string
+		want func(c *serverConfig)
+	}{
+		{"defaults", nil, nil, func(c *serverConfig) {}},
+		{"file over defaults", nil, []string{"-config", configFile}, func(c *serverConfig) {
+			c.ConfigFile = configFile
+			c.Addr = ":1"
+			c.LogLevel = "warn"
+			c.IdempotencyTTL = duration(time.Minute)
+			c.CacheSize = 5
+			c.WriteBehind = true
+		}},
+		{"env over file", map[string]string{envConfig: configFile, envAddr: ":2", envCacheSize: "6", envWriteBehind: "false"}, nil, func(c *serverConfig) {
+			c.ConfigFile = configFile
+			c.Addr = ":2"
+			c.LogLevel = "warn"
+			c.IdempotencyTTL = duration(time.Minute)
+			c.CacheSize = 6
+		}},
+		{"flags over env", map[string]string{envConfig: configFile, envAddr: ":2", envIdempotencyTTL: "2m"},
+			[]string{"-addr", ":3", "-idempotency-ttl", "3m", "-write-behind=false", "-cache-size", "0"}, func(c *serverConfig) {
+				c.ConfigFile = configFile
+				c.Addr = ":3"
+				c.LogLevel = "warn"
+				c.IdempotencyTTL = duration(3 * time.Minute)
+				c.CacheSize = 0
+			}},
+		{"config flag over config env", map[string]string{envConfig: configFile}, []string{"-config", otherFile}, func(c *serverConfig) {
+			c.ConfigFile = otherFile
+			c.Addr = ":4"
+		}},
+	}
+	for _, tc := range cases {
+		c, printConfig, err := parseServerConfig(tc.args, envLookup(tc.env))
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		want := defaultServerConfig()
+		tc.want(&want)
+		if printConfig || !reflect.DeepEqual(*c, want) {
+			t.Errorf("%s: expected %+v got %+v", tc.name, want, *c)
+		}
+	}
+}
+
+func TestServerConfigErrors(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		name string
+		file string
+		env  map[string]string
+		args []string
+	}{
+		{"unknown key", `{"adr":":1"}`, nil, nil},
+		{"wrong type", `{"cache_size":"5"}`, nil, nil},
+		{"invalid duration in file", `{"cache_ttl":"5"}`, nil, nil},
+		{"invalid duration in env", ``, map[string]string{envFlushInterval: "5"}, nil},
+		{"invalid bool in env", ``, map[string]string{envWriteBehind: "maybe"}, nil},
+		{"invalid setting", ``, nil, []string{"-cache-size", "-1"}},
+		{"same addresses", ``, map[string]string{envGrpcAddr: ":8080"}, nil},
+		{"unexpected argument", ``, nil, []string{"-addr", ":1", "serve"}},
+	}
+	for _, tc := range cases {
+		args := tc.args
+		if len(tc.file) != 0 {
+			args = append([]string{"-config", writeConfigFile(t, tc.file)}, args...)
+		}
+		if _, _, err := parseServerConfig(args, envLookup(tc.env)); err == nil {
+			t.Errorf("%s: expected an error", tc.name)
+		}
+	}
+}
+
+func TestPrintConfig(t *testing.T) {
+	t.Parallel()
+	c, printConfig, err := parseServerConfig([]string{"-data", "Data", "-flush-interval", "2s", "-print-config"}, envLookup(nil))
+	if err != nil || !printConfig {
+		t.Fatalf("print config %v: %v", printConfig, err)
+	}
+	var printed bytes.Buffer
+	if err := writeConfig(&printed, c); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(printed.String(), `"flush_interval": "2s"`) {
+		t.Errorf("durations not printed as text %s", printed.String())
+	}
+
+	//the printed config is a valid config file with the same settings
+	configFile := writeConfigFile(t, printed.String())
+	reloaded, _, err := parseServerConfig([]string{"-config", configFile}, envLookup(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := *c
+	want.ConfigFile = configFile
+	if want.DataDir, err = filepath.Abs(c.DataDir); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(*reloaded, want) {
+		t.Errorf("expected %+v got %+v", want, *reloaded)
+	}
+}
@@ -0,0 +1,71 @@
+// Package logging filters messages of the standard logger by level
+package logging
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+)
+
+type Level int32
+
+const (
+	Debug Level = iota
+	Info
+	Warn
+	Error
+)
+
+var levelNames = [...]string{"debug", "info", "warn", "error"}
+
+var level int32 = int32(Info)
+
+func (l Level) String() string {
+	if l < Debug || l > Error {
+		return fmt.Sprintf("Level(%d)", int32(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLevel returns the level by its name, case-insensitive
+func ParseLevel(name string) (Level, error) {
+	for l, n := range levelNames {
+		if strings.EqualFold(name, n) {
+			return Level(l), nil
+		}
+	}
+	return Info, fmt.Errorf("unknown log level %q, available levels: %s", name, strings.Join(levelNames[:], ", "))
+}
+
+// SetLevel messages below the level are dropped
+func SetLevel(l Level) {
+	atomic.StoreInt32(&level, int32(l))
+}
+
+// Enabled reports whether messages of the level are logged
+func Enabled(l Level) bool {
+	return int32(l) >= atomic.LoadInt32(&level)
+}
+
+func logf(l Level, format string, v ...interface{}) {
+	if Enabled(l) {
+		log.Output(3, "["+l.String()+"] "+fmt.Sprintf(format, v...))
+	}
+}
+
+func Debugf(format string, v ...interface{}) {
+	logf(Debug, format, v...)
+}
+
+func Infof(format string, v ...interface{}) {
+	logf(Info, format, v...)
+}
+
+func Warnf(format string, v ...interface{}) {
+	logf(Warn, format, v...)
+}
+
+func Errorf(format string, v ...interface{}) {
+	logf(Error, format, v...)
+}
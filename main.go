@@ -1,13 +1,22 @@
 package main
 
 import (
+	"CoffeeShop/coffeedb"
+	"CoffeeShop/logging"
 	"CoffeeShop/shopapi"
+	"CoffeeShop/shopgrpc"
 	"context"
+	"errors"
+	"flag"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
+
+	"google.golang.org/grpc"
 )
 
 func createChannel() (chan os.Signal, func()) {
@@ -20,17 +29,74 @@ func createChannel() (chan os.Signal, func()) {
 }
 
 func main() {
-	shopapi.InitDefaultConfig()
-	shopapi.InitDb("Data")
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		runCommand(os.Args[1], os.Args[2:])
+		return
+	}
+
+	config, printConfig, err := parseServerConfig(os.Args[1:], os.LookupEnv)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if printConfig {
+		if err := writeConfig(os.Stdout, config); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	level, _ := logging.ParseLevel(config.LogLevel)
+	logging.SetLevel(level)
 
-	mux := http.NewServeMux()
+	dbOptions := coffeedb.DefaultOptions()
+	dbOptions.WriteBehind = config.WriteBehind
+	dbOptions.FlushInterval = time.Duration(config.FlushInterval)
+	dbOptions.CacheSize = config.CacheSize
+	dbOptions.CacheTTL = time.Duration(config.CacheTTL)
+	db, err := coffeedb.InitWithOptions(config.DataDir, dbOptions)
+	if err != nil {
+		log.Fatal(err)
+	}
+	shopConfig := shopapi.DefaultConfig()
+	shopConfig.IdempotencyTTL = time.Duration(config.IdempotencyTTL)
+	shopConfig.MaxBodyBytes = config.MaxBodyBytes
+	shopConfig.MaxImportBytes = config.MaxImportBytes
+	shopConfig.PrintConfig()
+	shop := shopapi.NewShop(db, shopConfig, time.Now)
 
-	s := &http.Server{Addr: ":8080", Handler: mux}
-	go shopapi.StartHttpServer(s, mux)
+	s := shopapi.NewHttpServer(config.Addr, logRequests(shop.Handler()), shopapi.HttpTimeouts{
+		Read:  time.Duration(config.ReadTimeout),
+		Write: time.Duration(config.WriteTimeout),
+		Idle:  time.Duration(config.IdleTimeout),
+	})
+	go shopapi.StartHttpServer(s)
+	var grpcServer *grpc.Server
+	if len(config.GrpcAddr) != 0 {
+		grpcServer = shopgrpc.NewGrpcServer(shop)
+		go shopgrpc.StartGrpcServer(grpcServer, config.GrpcAddr)
+	}
 
 	stopCh, closeCh := createChannel()
 	defer closeCh()
-	log.Println("notified:", <-stopCh)
+	logging.Infof("notified: %v", <-stopCh)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(config.ShutdownTimeout))
+	defer cancel()
+	shopapi.ShutdownHttpServer(ctx, s)
+	if grpcServer != nil {
+		shopgrpc.ShutdownGrpcServer(ctx, grpcServer)
+	}
+	if err := db.Close(); err != nil {
+		logging.Errorf("%v", err)
+	}
+}
 
-	shopapi.ShutdownHttpServer(context.Background(), s)
+// logRequests logs every request on debug level
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		logging.Debugf("%s %s from %s", request.Method, request.URL, request.RemoteAddr)
+		next.ServeHTTP(writer, request)
+	})
 }
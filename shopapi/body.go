@@ -0,0 +1,83 @@
+package shopapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+)
+
+// DefaultMaxBodyBytes size limit of JSON request bodies if Config.MaxBodyBytes is zero
+const DefaultMaxBodyBytes = 1 << 20
+
+// DefaultMaxImportBytes size limit of bulk import bodies if Config.MaxImportBytes is zero
+const DefaultMaxImportBytes = 32 << 20
+
+var errRequestTooLarge = errors.New("request body too large")
+var errUnsupportedMediaType = errors.New("Content-Type must be application/json")
+
+func (shop *Shop) maxBodyBytes() int64 {
+	if shop.config.MaxBodyBytes == 0 {
+		return DefaultMaxBodyBytes
+	}
+	return shop.config.MaxBodyBytes
+}
+
+func (shop *Shop) maxImportBytes() int64 {
+	if shop.config.MaxImportBytes == 0 {
+		return DefaultMaxImportBytes
+	}
+	return shop.config.MaxImportBytes
+}
+
+// readBody reads the whole request body, bodies over the size limit are not read to the end
+func (shop *Shop) readBody(request *http.Request) ([]byte, error) {
+	return readLimited(request.Body, shop.maxBodyBytes())
+}
+
+// readLimited reads r to the end or errRequestTooLarge after limit bytes
+func readLimited(r io.Reader, limit int64) ([]byte, error) {
+	body, err := ioutil.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, errors.New("could not read body")
+	}
+	if int64(len(body)) > limit {
+		return nil, errRequestTooLarge
+	}
+	return body, nil
+}
+
+// readJson decodes the application/json request body into v, unknown
+// fields and data after the JSON value are rejected
+func (shop *Shop) readJson(request *http.Request, v interface{}) error {
+	mediaType, _, err := mime.ParseMediaType(request.Header.Get("Content-Type"))
+	if err != nil || mediaType != "application/json" {
+		return errUnsupportedMediaType
+	}
+	body, err := shop.readBody(request)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return errors.New("unexpected data after JSON body")
+	}
+	return nil
+}
+
+// writeBodyError writes an error of readBody or readJson, 413 for a too
+// large body, 415 for another Content-Type and 400 otherwise
+func writeBodyError(writer http.ResponseWriter, err error) {
+	if errors.Is(err, errRequestTooLarge) || errors.Is(err, errUnsupportedMediaType) {
+		writeShopError(writer, err)
+		return
+	}
+	writeErrorStatus(writer, http.StatusBadRequest, err)
+}
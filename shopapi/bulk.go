@@ -0,0 +1,76 @@
+package shopapi
+
+import (
+	"CoffeeShop/coffeedb"
+	"bytes"
+	"net/http"
+	"strings"
+)
+
+// bulkFormat returns the format from the format query parameter,
+// falls back to the content type of the request
+func bulkFormat(request *http.Request) string {
+	if format := request.URL.Query().Get("format"); len(format) != 0 {
+		return format
+	}
+	switch strings.TrimSpace(strings.Split(request.Header.Get("Content-Type"), ";")[0]) {
+	case "text/csv":
+		return coffeedb.FormatCsv
+	case "application/jsonl", "application/x-ndjson":
+		return coffeedb.FormatJsonl
+	}
+	return coffeedb.FormatCsv
+}
+
+// apiImportUsers POST registers all users of a CSV or JSONL body and
+// returns a report with the rows that failed, a body over the import
+// size limit is rejected before any user is imported
+func (shop *Shop) apiImportUsers(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != "POST" {
+		writeError(writer, http.StatusNotFound, CodeMethodNotAllowed, "Method is not supported.")
+		return
+	}
+	format := bulkFormat(request)
+	if format != coffeedb.FormatCsv && format != coffeedb.FormatJsonl {
+		writeError(writer, http.StatusBadRequest, CodeInvalidRequest, "format must be csv or jsonl")
+		return
+	}
+	body, err := readLimited(request.Body, shop.maxImportBytes())
+	if err != nil {
+		writeBodyError(writer, err)
+		return
+	}
+	report, err := shop.db.ImportMembers(bytes.NewReader(body), format)
+	if err != nil {
+		writeErrorStatus(writer, http.StatusBadRequest, err)
+		return
+	}
+	writeJson(writer, http.StatusOK, report)
+}
+
+// apiExportUsers GET returns all users in the format accepted by apiImportUsers
+func (shop *Shop) apiExportUsers(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != "GET" {
+		writeError(writer, http.StatusNotFound, CodeMethodNotAllowed, "Method is not supported.")
+		return
+	}
+	format := request.URL.Query().Get("format")
+	contentType := "text/csv"
+	switch format {
+	case "", coffeedb.FormatCsv:
+		format = coffeedb.FormatCsv
+	case coffeedb.FormatJsonl:
+		contentType = "application/jsonl"
+	default:
+		writeError(writer, http.StatusBadRequest, CodeInvalidRequest, "format must be csv or jsonl")
+		return
+	}
+	var body bytes.Buffer
+	if err := shop.db.ExportMembers(&body, format); err != nil {
+		writeErrorStatus(writer, http.StatusInternalServerError, err)
+		return
+	}
+	writer.Header().Set("Content-Type", contentType)
+	writer.WriteHeader(http.StatusOK)
+	body.WriteTo(writer)
+}
@@ -0,0 +1,140 @@
+package shopapi
+
+import (
+	"CoffeeShop/coffeedb"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// machine-readable codes of ErrorResponse
+const (
+	CodeInvalidRequest         = "INVALID_REQUEST"
+	CodeMethodNotAllowed       = "METHOD_NOT_ALLOWED"
+	CodeNotFound               = "NOT_FOUND"
+	CodeInternalError          = "INTERNAL_ERROR"
+	CodeUserNotFound           = "USER_NOT_FOUND"
+	CodeUserExists             = "USER_EXISTS"
+	CodeInvalidMembership      = "INVALID_MEMBERSHIP"
+	CodeInvalidCoffeeType      = "INVALID_COFFEE_TYPE"
+	CodeInvalidUserStatus      = "INVALID_USER_STATUS"
+	CodeQuotaExceeded          = "QUOTA_EXCEEDED"
+	CodeUserSuspended          = "USER_SUSPENDED"
+	CodeOrganizationCapReached = "ORGANIZATION_CAP_REACHED"
+	CodeOrganizationNotFound   = "ORGANIZATION_NOT_FOUND"
+	CodeOrganizationExists     = "ORGANIZATION_EXISTS"
+	CodeGroupNotFound          = "GROUP_NOT_FOUND"
+	CodeGroupExists            = "GROUP_EXISTS"
+	CodeGuestPassNotFound      = "GUEST_PASS_NOT_FOUND"
+	CodeGuestPassRedeemed      = "GUEST_PASS_REDEEMED"
+	CodeGuestPassExpired       = "GUEST_PASS_EXPIRED"
+	CodeIdempotencyKeyReused   = "IDEMPOTENCY_KEY_REUSED"
+	CodeRequestTooLarge        = "REQUEST_TOO_LARGE"
+	CodeUnsupportedMediaType   = "UNSUPPORTED_MEDIA_TYPE"
+)
+
+// ErrorResponse body of every error response, Details is
+// CoffeeLimitExceed for QUOTA_EXCEEDED and UserSuspendedError for USER_SUSPENDED
+type ErrorResponse struct {
+	Code    string      `json:"code"`
+	Message string      `json:"message"`
+	Details interface{} `json:"details,omitempty"`
+}
+
+// errorCodes codes of errors returned by Shop and CoffeeDb methods
+var errorCodes = []struct {
+	err  error
+	code string
+}{
+	{coffeedb.ErrUserNotFound, CodeUserNotFound},
+	{coffeedb.ErrUserExists, CodeUserExists},
+	{coffeedb.ErrInvalidMembership, CodeInvalidMembership},
+	{coffeedb.ErrInvalidCoffeeType, CodeInvalidCoffeeType},
+	{coffeedb.ErrInvalidUserStatus, CodeInvalidUserStatus},
+	{ErrOrganizationCapReached, CodeOrganizationCapReached},
+	{coffeedb.ErrOrganizationNotFound, CodeOrganizationNotFound},
+	{coffeedb.ErrOrganizationExists, CodeOrganizationExists},
+	{coffeedb.ErrGroupNotFound, CodeGroupNotFound},
+	{coffeedb.ErrGroupExists, CodeGroupExists},
+	{coffeedb.ErrGuestPassNotFound, CodeGuestPassNotFound},
+	{coffeedb.ErrGuestPassRedeemed, CodeGuestPassRedeemed},
+	{coffeedb.ErrGuestPassExpired, CodeGuestPassExpired},
+	{errRequestTooLarge, CodeRequestTooLarge},
+	{errUnsupportedMediaType, CodeUnsupportedMediaType},
+}
+
+// errorStatus returns the http status code of an error returned by Shop methods
+func errorStatus(err error) int {
+	var suspended *UserSuspendedError
+	switch {
+	case errors.Is(err, coffeedb.ErrUserNotFound), errors.Is(err, coffeedb.ErrGuestPassNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, coffeedb.ErrUserExists):
+		return http.StatusConflict
+	case errors.Is(err, coffeedb.ErrInvalidMembership), errors.Is(err, coffeedb.ErrInvalidCoffeeType), errors.Is(err, coffeedb.ErrInvalidUserStatus):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, coffeedb.ErrGuestPassRedeemed), errors.Is(err, coffeedb.ErrGuestPassExpired):
+		return http.StatusGone
+	case errors.Is(err, ErrOrganizationCapReached), errors.As(err, &suspended):
+		return http.StatusForbidden
+	case errors.Is(err, errRequestTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, errUnsupportedMediaType):
+		return http.StatusUnsupportedMediaType
+	}
+	return http.StatusBadRequest
+}
+
+// writeError writes an error response with the code
+func writeError(writer http.ResponseWriter, status int, code string, message string) {
+	writeJson(writer, status, ErrorResponse{Code: code, Message: message})
+}
+
+// writeErrorStatus writes an error response with the code of err, errors
+// without a code get a code by the status
+func writeErrorStatus(writer http.ResponseWriter, status int, err error) {
+	response := ErrorResponse{Message: err.Error()}
+	var suspended *UserSuspendedError
+	if errors.As(err, &suspended) {
+		if suspended.Status == coffeedb.Suspended {
+			writer.Header().Set("X-Suspended-Until", time.Unix(suspended.Until, 0).UTC().Format(time.RFC3339))
+		}
+		response.Code = CodeUserSuspended
+		response.Details = suspended
+	}
+	for _, ec := range errorCodes {
+		if len(response.Code) == 0 && errors.Is(err, ec.err) {
+			response.Code = ec.code
+		}
+	}
+	if len(response.Code) == 0 {
+		switch status {
+		case http.StatusNotFound:
+			response.Code = CodeNotFound
+		case http.StatusInternalServerError:
+			response.Code = CodeInternalError
+		default:
+			response.Code = CodeInvalidRequest
+		}
+	}
+	writeJson(writer, status, response)
+}
+
+// writeShopError writes an error returned by Shop methods with its status
+func writeShopError(writer http.ResponseWriter, err error) {
+	writeErrorStatus(writer, errorStatus(err), err)
+}
+
+// writeLimitExceeded writes a 429 response with the limit as details
+func writeLimitExceeded(writer http.ResponseWriter, message string, limit *CoffeeLimitExceed) {
+	writeJson(writer, http.StatusTooManyRequests, ErrorResponse{
+		Code:    CodeQuotaExceeded,
+		Message: message,
+		Details: limit,
+	})
+}
+
+// apiNotFound answers all paths without a handler
+func apiNotFound(writer http.ResponseWriter, request *http.Request) {
+	writeError(writer, http.StatusNotFound, CodeNotFound, "path not found "+request.URL.Path)
+}
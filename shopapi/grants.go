@@ -0,0 +1,104 @@
+package shopapi
+
+import (
+	"CoffeeShop/coffeedb"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+// GrantRequest admin request to give a user extra coffees
+// ExpiresIn is the grant lifetime in seconds
+type GrantRequest struct {
+	UserId    string              `json:"user_id"`
+	Coffee    coffeedb.CoffeeType `json:"coffee_type"`
+	Amount    uint32              `json:"amount"`
+	ExpiresIn int64               `json:"expires_in"`
+	Reason    string              `json:"reason"`
+	GrantedBy string              `json:"granted_by"`
+}
+
+func (gr *GrantRequest) validate() error {
+	if len(gr.UserId) == 0 {
+		return errors.New("empty user id")
+	}
+	if err := gr.Coffee.IsValid(); err != nil {
+		return err
+	}
+	if gr.Amount == 0 {
+		return errors.New("grant amount must be positive")
+	}
+	if gr.ExpiresIn <= 0 {
+		return errors.New("grant expiry must be positive")
+	}
+	if len(gr.GrantedBy) == 0 {
+		return errors.New("empty granted by")
+	}
+	return nil
+}
+
+// grantCoffee creates a new grant for the user
+func (shop *Shop) grantCoffee(gr *GrantRequest) (*coffeedb.UserCoffeeGrant, error) {
+	if err := gr.validate(); err != nil {
+		return nil, err
+	}
+	timeNowSeconds := shop.now().Unix()
+	grant := coffeedb.UserCoffeeGrant{
+		Id:        uuid.New().String(),
+		Coffee:    gr.Coffee,
+		Amount:    gr.Amount,
+		ExpiresAt: timeNowSeconds + gr.ExpiresIn,
+		Reason:    gr.Reason,
+		GrantedBy: gr.GrantedBy,
+		CreatedAt: timeNowSeconds,
+	}
+	if err := shop.db.AddGrant(gr.UserId, &grant); err != nil {
+		return nil, err
+	}
+	return &grant, nil
+}
+
+func writeJson(writer http.ResponseWriter, status int, v interface{}) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+	json.NewEncoder(writer).Encode(v)
+}
+
+// apiGrants POST creates a grant, GET lists grants of user_id
+func (shop *Shop) apiGrants(writer http.ResponseWriter, request *http.Request) {
+	switch request.Method {
+	case "POST":
+		var gr GrantRequest
+		err := shop.readJson(request, &gr)
+		if err != nil {
+			writeBodyError(writer, err)
+			return
+		}
+		grant, err := shop.grantCoffee(&gr)
+		if err != nil {
+			writeErrorStatus(writer, http.StatusBadRequest, err)
+			return
+		}
+		writeJson(writer, http.StatusOK, grant)
+	case "GET":
+		userId := request.URL.Query().Get("user_id")
+		if len(userId) == 0 {
+			writeError(writer, http.StatusBadRequest, CodeInvalidRequest, "empty user id")
+			return
+		}
+		qs := shop.db.GetUserData(userId)
+		if qs == nil {
+			writeError(writer, http.StatusBadRequest, CodeUserNotFound, "user not found "+userId)
+			return
+		}
+		grants := qs.Grants
+		if grants == nil {
+			grants = []coffeedb.UserCoffeeGrant{}
+		}
+		writeJson(writer, http.StatusOK, grants)
+	default:
+		writeError(writer, http.StatusNotFound, CodeMethodNotAllowed, "Method is not supported.")
+	}
+}
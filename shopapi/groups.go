@@ -0,0 +1,174 @@
+package shopapi
+
+import (
+	"CoffeeShop/coffeedb"
+	"CoffeeShop/logging"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// GroupRegister admin request to create a group account
+type GroupRegister struct {
+	GroupId    string                  `json:"group_id"`
+	Membership coffeedb.MembershipType `json:"membership"`
+}
+
+// GroupMemberInfo admin request to add a user to a group
+// SubLimits optionally caps the member's share of the pool per coffee type
+// in the same time frame as the group quota
+type GroupMemberInfo struct {
+	GroupId   string                         `json:"group_id"`
+	UserId    string                         `json:"user_id"`
+	SubLimits map[coffeedb.CoffeeType]uint32 `json:"sub_limits,omitempty"`
+}
+
+// buyCoffeeForGroupMember checks the group pool and the member's sub-limit
+// and charges both, the member's own QuotaState tracks the member's usage,
+// both are checked and charged under the group lock so concurrent members
+// can not overspend the pool, the returned undo gives the coffee back to both
+func (shop *Shop) buyCoffeeForGroupMember(userId string, qs *coffeedb.UserCoffeeMembership, coffee coffeedb.CoffeeType, timeNowSeconds int64) (*CoffeeLimitExceed, undoPurchase, error) {
+	var limit *CoffeeLimitExceed
+	memberCharged := false
+	err := shop.db.UpdateGroupQuota(qs.Group, coffee, func(group *coffeedb.CoffeeGroup, pool *coffeedb.UserCoffeeQuota) (*coffeedb.UserCoffeeQuota, error) {
+		cQuotaConfig, err := shop.coffeeQuotaConfig(coffee, group.Membership)
+		if err != nil {
+			return nil, err
+		}
+		var poolState *coffeedb.UserCoffeeQuota
+		poolState, limit = quotaAfterPurchase(coffee, pool, cQuotaConfig.Amount, cQuotaConfig.TimeFrame, timeNowSeconds)
+		if limit != nil {
+			return nil, nil
+		}
+		memberLimit := ^uint32(0)
+		if subLimit, ok := group.Members[userId].SubLimits[coffee]; ok {
+			memberLimit = subLimit
+		}
+		err = shop.db.UpdateQuota(userId, coffee, func(state *coffeedb.UserCoffeeQuota) (*coffeedb.UserCoffeeQuota, error) {
+			var memberState *coffeedb.UserCoffeeQuota
+			memberState, limit = quotaAfterPurchase(coffee, state, memberLimit, cQuotaConfig.TimeFrame, timeNowSeconds)
+			return memberState, nil
+		})
+		if err != nil || limit != nil {
+			return nil, err
+		}
+		memberCharged = true
+		return poolState, nil
+	})
+	undoMember := func() error { return shop.db.UpdateQuota(userId, coffee, refundQuota) }
+	if err != nil && memberCharged {
+		//the pool was not saved
+		if undoErr := undoMember(); undoErr != nil {
+			logging.Errorf("undo purchase of user %s: %v", userId, undoErr)
+		}
+	}
+	if errors.Is(err, coffeedb.ErrGroupNotFound) {
+		return nil, nil, fmt.Errorf("%w %s", coffeedb.ErrGroupNotFound, qs.Group)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if limit != nil {
+		return shop.consumeGrantOrLimit(userId, limit, timeNowSeconds)
+	}
+	return nil, func() error {
+		err := shop.db.UpdateGroupQuota(qs.Group, coffee, func(group *coffeedb.CoffeeGroup, pool *coffeedb.UserCoffeeQuota) (*coffeedb.UserCoffeeQuota, error) {
+			return refundQuota(pool)
+		})
+		if err != nil {
+			return err
+		}
+		return undoMember()
+	}, nil
+}
+
+// apiGroups POST creates a group, GET returns group_id data
+func (shop *Shop) apiGroups(writer http.ResponseWriter, request *http.Request) {
+	switch request.Method {
+	case "POST":
+		var groupReg GroupRegister
+		err := shop.readJson(request, &groupReg)
+		if err != nil {
+			writeBodyError(writer, err)
+			return
+		}
+		if len(groupReg.GroupId) == 0 {
+			writeError(writer, http.StatusBadRequest, CodeInvalidRequest, "empty group id")
+			return
+		}
+		err = groupReg.Membership.IsValid()
+		if err != nil {
+			writeErrorStatus(writer, http.StatusBadRequest, err)
+			return
+		}
+		err = shop.db.CreateGroup(groupReg.GroupId, groupReg.Membership)
+		if err != nil {
+			writeErrorStatus(writer, http.StatusBadRequest, err)
+			return
+		}
+		writeJson(writer, http.StatusOK, shop.db.GetGroupData(groupReg.GroupId))
+	case "GET":
+		groupId := request.URL.Query().Get("group_id")
+		if len(groupId) == 0 {
+			writeError(writer, http.StatusBadRequest, CodeInvalidRequest, "empty group id")
+			return
+		}
+		if !coffeedb.ValidRecordId(groupId) {
+			writeErrorStatus(writer, http.StatusBadRequest, coffeedb.ErrInvalidGroupId)
+			return
+		}
+		group := shop.db.GetGroupData(groupId)
+		if group == nil {
+			writeError(writer, http.StatusBadRequest, CodeGroupNotFound, "group not found "+groupId)
+			return
+		}
+		writeJson(writer, http.StatusOK, group)
+	default:
+		writeError(writer, http.StatusNotFound, CodeMethodNotAllowed, "Method is not supported.")
+	}
+}
+
+// apiGroupMembers POST adds a member or updates member's sub-limits,
+// DELETE removes user_id from group_id
+func (shop *Shop) apiGroupMembers(writer http.ResponseWriter, request *http.Request) {
+	switch request.Method {
+	case "POST":
+		var memberInfo GroupMemberInfo
+		err := shop.readJson(request, &memberInfo)
+		if err != nil {
+			writeBodyError(writer, err)
+			return
+		}
+		if len(memberInfo.GroupId) == 0 || len(memberInfo.UserId) == 0 {
+			writeError(writer, http.StatusBadRequest, CodeInvalidRequest, "empty group or user id")
+			return
+		}
+		for coffee := range memberInfo.SubLimits {
+			if err := coffee.IsValid(); err != nil {
+				writeErrorStatus(writer, http.StatusBadRequest, err)
+				return
+			}
+		}
+		err = shop.db.AddGroupMember(memberInfo.GroupId, memberInfo.UserId, coffeedb.GroupMember{SubLimits: memberInfo.SubLimits})
+		if err != nil {
+			writeErrorStatus(writer, http.StatusBadRequest, err)
+			return
+		}
+		writeJson(writer, http.StatusOK, shop.db.GetGroupData(memberInfo.GroupId))
+	case "DELETE":
+		groupId := request.URL.Query().Get("group_id")
+		userId := request.URL.Query().Get("user_id")
+		if len(groupId) == 0 || len(userId) == 0 {
+			writeError(writer, http.StatusBadRequest, CodeInvalidRequest, "empty group or user id")
+			return
+		}
+		err := shop.db.RemoveGroupMember(groupId, userId)
+		if err != nil {
+			writeErrorStatus(writer, http.StatusBadRequest, err)
+			return
+		}
+		writeJson(writer, http.StatusOK, shop.db.GetGroupData(groupId))
+	default:
+		writeError(writer, http.StatusNotFound, CodeMethodNotAllowed, "Method is not supported.")
+	}
+}
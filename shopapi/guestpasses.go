@@ -0,0 +1,178 @@
+package shopapi
+
+import (
+	"CoffeeShop/coffeedb"
+	"CoffeeShop/logging"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const defaultGuestPassLifetime = int64(24 * 60 * 60)
+const maxGuestPassLifetime = int64(30 * 24 * 60 * 60)
+
+// guestCodeAlphabet has no characters which are easy to confuse like 0/O or 1/I
+const guestCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+const guestCodeLength = 10
+
+// GuestPassRequest member request to issue a guest pass
+// ExpiresIn is the pass lifetime in seconds, 24 hours if zero
+type GuestPassRequest struct {
+	UserId    string              `json:"user_id"`
+	Coffee    coffeedb.CoffeeType `json:"coffee_type"`
+	ExpiresIn int64               `json:"expires_in"`
+}
+
+// GuestPassInfo issued guest pass returned to the member
+type GuestPassInfo struct {
+	GuestCode string              `json:"guest_code"`
+	Coffee    coffeedb.CoffeeType `json:"coffee_type"`
+	ExpiresAt int64               `json:"expires_at"`
+}
+
+func newGuestCode() (string, error) {
+	buf := make([]byte, guestCodeLength)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	for i, b := range buf {
+		buf[i] = guestCodeAlphabet[int(b)%len(guestCodeAlphabet)]
+	}
+	return string(buf), nil
+}
+
+// chargeGuestPass takes one guest pass from the member's guest allowance
+// or from the member's own coffee quota if the membership has no allowance,
+// the allowance is checked and charged under the member's lock, the returned
+// undo gives the guest pass or the coffee taken from the own quota back
+func (shop *Shop) chargeGuestPass(userId string, qs *coffeedb.UserCoffeeMembership, coffee coffeedb.CoffeeType, timeNowSeconds int64) (*CoffeeLimitExceed, undoPurchase, error) {
+	membershipConfig, ok := shop.config.Memberships[qs.Membership]
+	if !ok {
+		return nil, nil, errors.New("invalid Membership")
+	}
+	if membershipConfig.GuestPasses == 0 {
+		if len(qs.Group) != 0 {
+			return nil, nil, errors.New("group members can issue guest passes only from a guest allowance")
+		}
+		return shop.buyCoffeeForMember(userId, qs, coffee, timeNowSeconds)
+	}
+	var limit *CoffeeLimitExceed
+	err := shop.db.UpdateGuestQuota(userId, func(state *coffeedb.UserCoffeeQuota) (*coffeedb.UserCoffeeQuota, error) {
+		var newState *coffeedb.UserCoffeeQuota
+		newState, limit = quotaAfterPurchase(coffee, state, membershipConfig.GuestPasses, membershipConfig.GuestTimeFrame, timeNowSeconds)
+		return newState, nil
+	})
+	if err != nil || limit != nil {
+		return limit, nil, err
+	}
+	return nil, func() error { return shop.db.UpdateGuestQuota(userId, refundQuota) }, nil
+}
+
+// issueGuestPass creates a one-time guest pass paid by the member
+func (shop *Shop) issueGuestPass(gr *GuestPassRequest) (*GuestPassInfo, *CoffeeLimitExceed, error) {
+	if len(gr.UserId) == 0 {
+		return nil, nil, errors.New("empty user id")
+	}
+	if err := gr.Coffee.IsValid(); err != nil {
+		return nil, nil, err
+	}
+	expiresIn := gr.ExpiresIn
+	if expiresIn == 0 {
+		expiresIn = defaultGuestPassLifetime
+	}
+	if expiresIn < 0 || expiresIn > maxGuestPassLifetime {
+		return nil, nil, fmt.Errorf("guest pass expiry must be between 1 and %d seconds", maxGuestPassLifetime)
+	}
+	qs := shop.db.GetUserData(gr.UserId)
+	if qs == nil {
+		return nil, nil, fmt.Errorf("%w %s", coffeedb.ErrUserNotFound, gr.UserId)
+	}
+
+	timeNowSeconds := shop.now().Unix()
+	if err := checkUserStatus(gr.UserId, qs, timeNowSeconds); err != nil {
+		return nil, nil, err
+	}
+	limit, undo, err := shop.chargeGuestPass(gr.UserId, qs, gr.Coffee, timeNowSeconds)
+	if err != nil || limit != nil {
+		return nil, limit, err
+	}
+	code, err := newGuestCode()
+	pass := coffeedb.GuestPass{IssuedBy: gr.UserId, Coffee: gr.Coffee, CreatedAt: timeNowSeconds, ExpiresAt: timeNowSeconds + expiresIn}
+	if err == nil {
+		err = shop.db.CreateGuestPass(code, &pass)
+	}
+	if err != nil {
+		if undo != nil {
+			if undoErr := undo(); undoErr != nil {
+				logging.Errorf("undo guest pass of user %s: %v", gr.UserId, undoErr)
+			}
+		}
+		return nil, nil, err
+	}
+	return &GuestPassInfo{GuestCode: code, Coffee: pass.Coffee, ExpiresAt: pass.ExpiresAt}, nil, nil
+}
+
+// buyCoffeeWithGuestPass redeems the guest pass, coffee may be zero to take
+// the coffee type of the pass, passes of suspended or banned members are
+// not accepted, passes of deleted members still are
+func (shop *Shop) buyCoffeeWithGuestPass(code string, coffee coffeedb.CoffeeType) error {
+	pass := shop.db.GetGuestPass(code)
+	if pass == nil {
+		return coffeedb.ErrGuestPassNotFound
+	}
+	if coffee != 0 && coffee != pass.Coffee {
+		return fmt.Errorf("guest pass is valid for %s only", pass.Coffee.String())
+	}
+	timeNowSeconds := shop.now().Unix()
+	if issuer := shop.db.GetUserData(pass.IssuedBy); issuer != nil {
+		if err := checkUserStatus(pass.IssuedBy, issuer, timeNowSeconds); err != nil {
+			return err
+		}
+	}
+	pass, err := shop.db.RedeemGuestPass(code, timeNowSeconds)
+	if err != nil {
+		return err
+	}
+	return shop.db.RecordPurchase(&coffeedb.Purchase{
+		UserId:    pass.IssuedBy,
+		Coffee:    pass.Coffee,
+		Time:      timeNowSeconds,
+		Price:     shop.coffeePrice(pass.Coffee),
+		GuestCode: code,
+	})
+}
+
+func (shop *Shop) apiGuestPass(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != "POST" {
+		writeError(writer, http.StatusNotFound, CodeMethodNotAllowed, "Method is not supported.")
+		return
+	}
+	var gr GuestPassRequest
+	err := shop.readJson(request, &gr)
+	if err != nil {
+		writeBodyError(writer, err)
+		return
+	}
+	pass, limit, err := shop.issueGuestPass(&gr)
+	if writeUserSuspended(writer, err) {
+		return
+	}
+	if err != nil {
+		writeErrorStatus(writer, http.StatusBadRequest, err)
+		return
+	}
+	if limit != nil {
+		writeLimitExceeded(
+			writer,
+			fmt.Sprintf("User %s guest pass limit exceeded, %s issued: %d available in %s",
+				gr.UserId,
+				limit.Type.String(),
+				limit.AmountBought,
+				time.Duration(limit.AvailableIn*int64(time.Second)).String()),
+			limit)
+		return
+	}
+	writeJson(writer, http.StatusOK, pass)
+}
@@ -0,0 +1,217 @@
+package shopapi
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const idempotencyKeyHeader = "Idempotency-Key"
+const maxIdempotencyKeyLength = 255
+
+// maxIdempotencyEntries the oldest keys are dropped before they expire
+// once this many are stored
+const maxIdempotencyEntries = 100000
+
+// DefaultIdempotencyTTL how long results of requests with an
+// Idempotency-Key are replayed if Config.IdempotencyTTL is zero
+const DefaultIdempotencyTTL = 24 * time.Hour
+
+// idempotentResponse stored response replayed to retries
+type idempotentResponse struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+// idempotencyEntry result of the first request with a key, done is
+// closed when the request finished, response is nil if it was not stored
+type idempotencyEntry struct {
+	fingerprint [sha256.Size]byte
+	expiresAt   int64
+	done        chan struct{}
+	response    *idempotentResponse
+}
+
+// idempotencyStore results by idempotency key, order holds the keys in
+// the order they expire because all entries live equally long,
+// at most maxEntries keys are kept
+type idempotencyStore struct {
+	lock       sync.Mutex
+	entries    map[string]*idempotencyEntry
+	order      []string
+	maxEntries int
+}
+
+func newIdempotencyStore(maxEntries int) *idempotencyStore {
+	return &idempotencyStore{entries: make(map[string]*idempotencyEntry), maxEntries: maxEntries}
+}
+
+// removeExpired removes entries expired at timeNowNano, must be called with lock held
+func (s *idempotencyStore) removeExpired(timeNowNano int64) {
+	for len(s.order) != 0 {
+		entry, ok := s.entries[s.order[0]]
+		if ok && entry.expiresAt > timeNowNano {
+			return
+		}
+		if ok {
+			delete(s.entries, s.order[0])
+		}
+		s.order = s.order[1:]
+	}
+}
+
+// removeOldest removes the oldest entries until there is room for a new one,
+// must be called with lock held
+func (s *idempotencyStore) removeOldest() {
+	for len(s.entries) >= s.maxEntries && len(s.order) != 0 {
+		delete(s.entries, s.order[0])
+		s.order = s.order[1:]
+	}
+}
+
+// begin returns the entry of key, owner is true if the request is the
+// first with the key and has to finish the entry, mismatch is true if
+// the key was used with another fingerprint
+func (s *idempotencyStore) begin(key string, fingerprint [sha256.Size]byte, timeNowNano int64, ttl time.Duration) (entry *idempotencyEntry, owner bool, mismatch bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.removeExpired(timeNowNano)
+	if entry, ok := s.entries[key]; ok {
+		return entry, false, entry.fingerprint != fingerprint
+	}
+	s.removeOldest()
+	entry = &idempotencyEntry{fingerprint: fingerprint, expiresAt: timeNowNano + int64(ttl), done: make(chan struct{})}
+	s.entries[key] = entry
+	s.order = append(s.order, key)
+	return entry, true, false
+}
+
+// finish stores the response of the entry or removes the entry if
+// response is nil so a retry is executed again
+func (s *idempotencyStore) finish(key string, entry *idempotencyEntry, response *idempotentResponse) {
+	s.lock.Lock()
+	if response == nil {
+		if s.entries[key] == entry {
+			delete(s.entries, key)
+		}
+	} else {
+		entry.response = response
+	}
+	s.lock.Unlock()
+	close(entry.done)
+}
+
+// recordingWriter writes the response through and keeps a copy of it
+type recordingWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *recordingWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recordingWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+// storedStatus purchases and exceeded quotas are replayed, other errors
+// did not change anything and are executed again on retry
+func storedStatus(status int) bool {
+	return status >= 200 && status < 300 || status == http.StatusTooManyRequests
+}
+
+func (shop *Shop) idempotencyTTL() time.Duration {
+	if shop.config.IdempotencyTTL == 0 {
+		return DefaultIdempotencyTTL
+	}
+	return shop.config.IdempotencyTTL
+}
+
+// idempotencyScope keys are scoped to the user_id of the body, the method
+// and the path, so clients of different users may use the same keys
+func idempotencyScope(request *http.Request, body []byte) string {
+	var owner struct {
+		UserId string `json:"user_id"`
+	}
+	//a body which is not JSON is rejected by next and never stored
+	json.Unmarshal(body, &owner)
+	return owner.UserId + " " + request.Method + " " + request.URL.Path
+}
+
+// runFirst runs next for the first request with the key and stores its
+// response, the entry is finished without a response if next panics
+func (shop *Shop) runFirst(writer http.ResponseWriter, request *http.Request, next func(http.ResponseWriter, *http.Request), key string, entry *idempotencyEntry) {
+	var response *idempotentResponse
+	defer func() {
+		shop.idempotency.finish(key, entry, response)
+	}()
+	recorder := recordingWriter{ResponseWriter: writer}
+	next(&recorder, request)
+	if storedStatus(recorder.status) {
+		response = &idempotentResponse{status: recorder.status, header: writer.Header().Clone(), body: recorder.body.Bytes()}
+	}
+}
+
+// idempotent runs next once per Idempotency-Key, user, method and path,
+// retries with the same key and the same request get the stored response
+// with Idempotent-Replayed set, retries with another request are rejected
+func (shop *Shop) idempotent(writer http.ResponseWriter, request *http.Request, next func(http.ResponseWriter, *http.Request)) {
+	key := request.Header.Get(idempotencyKeyHeader)
+	if len(key) == 0 {
+		next(writer, request)
+		return
+	}
+	if len(key) > maxIdempotencyKeyLength {
+		writeError(writer, http.StatusBadRequest, CodeInvalidRequest, "Idempotency-Key is too long")
+		return
+	}
+	body, err := shop.readBody(request)
+	if err != nil {
+		writeBodyError(writer, err)
+		return
+	}
+	fingerprint := sha256.Sum256(body)
+	key = idempotencyScope(request, body) + "\n" + key
+
+	for {
+		entry, owner, mismatch := shop.idempotency.begin(key, fingerprint, shop.now().UnixNano(), shop.idempotencyTTL())
+		if mismatch {
+			writeError(writer, http.StatusUnprocessableEntity, CodeIdempotencyKeyReused, "Idempotency-Key was used with another request")
+			return
+		}
+		if owner {
+			request.Body = ioutil.NopCloser(bytes.NewReader(body))
+			shop.runFirst(writer, request, next, key, entry)
+			return
+		}
+		select {
+		case <-entry.done:
+		case <-request.Context().Done():
+			return
+		}
+		if entry.response != nil {
+			for name, values := range entry.response.header {
+				writer.Header()[name] = values
+			}
+			writer.Header().Set("Idempotent-Replayed", "true")
+			writer.WriteHeader(entry.response.status)
+			writer.Write(entry.response.body)
+			return
+		}
+		//the first request failed and was removed, this one runs again
+	}
+}
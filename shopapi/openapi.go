@@ -0,0 +1,25 @@
+package shopapi
+
+import (
+	_ "embed"
+	"net/http"
+	"strconv"
+)
+
+// openApiSpec OpenAPI 3 document of all endpoints, tests check
+// the handlers conform to it
+//
+//go:embed openapi.json
+var openApiSpec []byte
+
+// apiOpenApi GET returns the OpenAPI document
+func apiOpenApi(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != "GET" {
+		methodNotAllowed(writer, "GET")
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Header().Set("Content-Length", strconv.Itoa(len(openApiSpec)))
+	writer.WriteHeader(http.StatusOK)
+	writer.Write(openApiSpec)
+}
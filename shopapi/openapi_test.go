@@ -0,0 +1,337 @@
+package shopapi
+
+import (
+	"CoffeeShop/coffeedb"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// openApi OpenAPI document decoded with json.Number numbers
+type openApi map[string]interface{}
+
+func loadOpenApi(data []byte) (openApi, error) {
+	var spec openApi
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&spec); err != nil {
+		return nil, err
+	}
+	return spec, nil
+}
+
+// resolve follows $ref of a schema, response or header object
+func (spec openApi) resolve(v interface{}) map[string]interface{} {
+	object, _ := v.(map[string]interface{})
+	ref, ok := object["$ref"].(string)
+	if !ok {
+		return object
+	}
+	var node interface{} = map[string]interface{}(spec)
+	for _, name := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+		node = node.(map[string]interface{})[name]
+	}
+	return spec.resolve(node)
+}
+
+// operation returns the path template and the operation of method matching path
+func (spec openApi) operation(method string, path string) (string, map[string]interface{}) {
+	segments := strings.Split(path, "/")
+	for template, item := range spec["paths"].(map[string]interface{}) {
+		templateSegments := strings.Split(template, "/")
+		if len(templateSegments) != len(segments) {
+			continue
+		}
+		match := true
+		for i, s := range templateSegments {
+			if s != segments[i] && !(strings.HasPrefix(s, "{") && len(segments[i]) != 0) {
+				match = false
+			}
+		}
+		if op, ok := item.(map[string]interface{})[strings.ToLower(method)]; match && ok {
+			return template, op.(map[string]interface{})
+		}
+	}
+	return "", nil
+}
+
+// validate checks value decoded with json.Number numbers against schema
+func (spec openApi) validate(schema interface{}, value interface{}, at string) error {
+	s := spec.resolve(schema)
+	if value == nil {
+		if s["nullable"] == true {
+			return nil
+		}
+		return fmt.Errorf("%s: null", at)
+	}
+	if oneOf, ok := s["oneOf"].([]interface{}); ok {
+		matches := 0
+		for _, option := range oneOf {
+			if spec.validate(option, value, at) == nil {
+				matches++
+			}
+		}
+		if matches != 1 {
+			return fmt.Errorf("%s: matches %d of oneOf schemas", at, matches)
+		}
+		return nil
+	}
+	if enum, ok := s["enum"].([]interface{}); ok {
+		found := false
+		for _, e := range enum {
+			found = found || fmt.Sprint(e) == fmt.Sprint(value)
+		}
+		if !found {
+			return fmt.Errorf("%s: %v not in enum %v", at, value, enum)
+		}
+	}
+	switch s["type"] {
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s: expected object got %T", at, value)
+		}
+		properties, _ := s["properties"].(map[string]interface{})
+		required, _ := s["required"].([]interface{})
+		for _, name := range required {
+			if _, ok := object[name.(string)]; !ok {
+				return fmt.Errorf("%s: missing required property %s", at, name)
+			}
+		}
+		for name, v := range object {
+			var err error
+			if property, ok := properties[name]; ok {
+				err = spec.validate(property, v, at+"."+name)
+			} else if additional, ok := s["additionalProperties"].(map[string]interface{}); ok {
+				err = spec.validate(additional, v, at+"."+name)
+			} else if s["additionalProperties"] == false {
+				err = fmt.Errorf("%s: property %s not in schema", at, name)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	case "array":
+		array, ok := value.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s: expected array got %T", at, value)
+		}
+		for i, item := range array {
+			if err := spec.validate(s["items"], item, at+"["+strconv.Itoa(i)+"]"); err != nil {
+				return err
+			}
+		}
+	case "string":
+		if _, ok := value.(string); !ok {
+			return fmt.Errorf("%s: expected string got %T", at, value)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("%s: expected boolean got %T", at, value)
+		}
+	case "integer", "number":
+		n, ok := value.(json.Number)
+		if !ok {
+			return fmt.Errorf("%s: expected %s got %T", at, s["type"], value)
+		}
+		f, _ := n.Float64()
+		if _, err := n.Int64(); s["type"] == "integer" && err != nil {
+			return fmt.Errorf("%s: expected integer got %s", at, n)
+		}
+		if min, ok := s["minimum"].(json.Number); ok {
+			if m, _ := min.Float64(); f < m {
+				return fmt.Errorf("%s: %s below minimum %s", at, n, min)
+			}
+		}
+		if max, ok := s["maximum"].(json.Number); ok {
+			if m, _ := max.Float64(); f > m {
+				return fmt.Errorf("%s: %s above maximum %s", at, n, max)
+			}
+		}
+	}
+	return nil
+}
+
+// validateContent checks a json body against the schema of its content type
+func (spec openApi) validateContent(content map[string]interface{}, contentType string, body []byte, at string) error {
+	contentType = strings.TrimSpace(strings.Split(contentType, ";")[0])
+	media, ok := content[contentType].(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("%s: content type %q not documented", at, contentType)
+	}
+	if contentType != "application/json" {
+		return nil
+	}
+	var value interface{}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return fmt.Errorf("%s: %v", at, err)
+	}
+	return spec.validate(media["schema"], value, at)
+}
+
+// TestOpenApiConformance every operation of the spec is called and the
+// requests and responses are checked against its schemas
+func TestOpenApiConformance(t *testing.T) {
+	t.Parallel()
+	clock := newTestClock()
+	srv := serverSetup(shopSetup(t, DefaultConfig(), coffeedb.DefaultOptions(), clock.Now))
+	defer serverTeardown(srv)
+
+	resCode, data, err := getBody(srv.URL + "/openapi.json")
+	if err != nil || resCode != http.StatusOK {
+		t.Fatalf("get spec: code %d, err %v", resCode, err)
+	}
+	spec, err := loadOpenApi(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	maniac, basic := "0a5c7e9b-1d3f-4a6c-8e2b-4d6f8a0c2e4a", "5f7b9d1e-3a5c-4e7a-9c1d-6b8e0a2c4f6b"
+	vars := map[string]string{
+		"{maniac}": maniac,
+		"{basic}":  basic,
+		"{until}":  strconv.FormatInt(clock.Now().Add(time.Hour).Unix(), 10),
+	}
+	exchanges := []struct {
+		method      string
+		path        string
+		contentType string
+		body        string
+		status      int
+	}{
+		{"GET", "/openapi.json", "", ``, http.StatusOK},
+		{"POST", "/registerUser", "application/json", `{"user_id":"{maniac}","membership":3}`, http.StatusOK},
+		{"POST", "/registerUser", "application/json", `{"user_id":"{maniac}","membership":3}`, http.StatusBadRequest},
+		{"POST", "/v1/users", "application/json", `{"user_id":"{basic}","membership":1}`, http.StatusCreated},
+		{"POST", "/v1/users", "application/json", `{"user_id":"{basic}","membership":1}`, http.StatusConflict},
+		{"POST", "/v1/users", "application/json", `{"user_id":"other","membership":9}`, http.StatusUnprocessableEntity},
+		{"POST", "/v1/users", "text/plain", `{"user_id":"other","membership":1}`, http.StatusUnsupportedMediaType},
+		{"POST", "/v1/users", "application/json", `{"user_id":"other","membership":1,"role":"admin"}`, http.StatusBadRequest},
+		{"GET", "/v1/users/{basic}", "", ``, http.StatusOK},
+		{"GET", "/v1/users/unknown", "", ``, http.StatusNotFound},
+		{"POST", "/v1/users/{basic}/purchases", "application/json", `{"coffee_type":1}`, http.StatusCreated},
+		{"POST", "/v1/users/{basic}/purchases", "application/json", `{"coffee_type":1}`, http.StatusTooManyRequests},
+		{"POST", "/v1/users/{basic}/purchases", "application/json", `{"coffee_type":9}`, http.StatusUnprocessableEntity},
+		{"GET", "/v1/users/{basic}/purchases", "", ``, http.StatusOK},
+		{"GET", "/v1/users/{basic}/export", "", ``, http.StatusOK},
+		{"POST", "/buyCoffee", "application/json", `{"user_id":"{maniac}","coffee_type":1}`, http.StatusOK},
+		{"POST", "/buyCoffee", "application/json", `{"user_id":"{basic}","coffee_type":1}`, http.StatusTooManyRequests},
+		{"POST", "/buyCoffee", "application/json", `{"user_id":`, http.StatusBadRequest},
+		{"POST", "/guestPass", "application/json", `{"user_id":"{maniac}","coffee_type":2,"expires_in":3600}`, http.StatusOK},
+		{"POST", "/buyCoffee", "application/json", `{"guest_code":"{guest_code}","coffee_type":2}`, http.StatusOK},
+		{"POST", "/buyCoffee", "application/json", `{"guest_code":"{guest_code}","coffee_type":2}`, http.StatusGone},
+		{"POST", "/admin/grants", "application/json", `{"user_id":"{basic}","coffee_type":1,"amount":1,"expires_in":3600,"reason":"apology","granted_by":"admin"}`, http.StatusOK},
+		{"GET", "/admin/grants?user_id={basic}", "", ``, http.StatusOK},
+		{"POST", "/admin/groups", "application/json", `{"group_id":"team","membership":2}`, http.StatusOK},
+		{"GET", "/admin/groups?group_id=team", "", ``, http.StatusOK},
+		{"POST", "/admin/groups/members", "application/json", `{"group_id":"team","user_id":"{maniac}","sub_limits":{"1":2}}`, http.StatusOK},
+		{"DELETE", "/admin/groups/members?group_id=team&user_id={maniac}", "", ``, http.StatusOK},
+		{"POST", "/admin/organizations", "application/json", `{"org_id":"acme","name":"Acme","monthly_drink_cap":100,"monthly_spend_cap":100000}`, http.StatusOK},
+		{"GET", "/admin/organizations?org_id=acme", "", ``, http.StatusOK},
+		{"POST", "/admin/organizations/members", "application/json", `{"org_id":"acme","user_id":"{basic}"}`, http.StatusOK},
+		{"POST", "/v1/users/{basic}/purchases", "application/json", `{"coffee_type":3}`, http.StatusCreated},
+		{"GET", "/admin/organizations/statement?org_id=acme", "", ``, http.StatusOK},
+		{"GET", "/admin/organizations/statement?org_id=acme&format=csv", "", ``, http.StatusOK},
+		{"DELETE", "/admin/organizations/members?org_id=acme&user_id={basic}", "", ``, http.StatusOK},
+		{"POST", "/admin/userStatus", "application/json", `{"user_id":"{basic}","status":1,"until":{until},"reason":"abuse","changed_by":"admin"}`, http.StatusOK},
+		{"POST", "/v1/users/{basic}/purchases", "application/json", `{"coffee_type":2}`, http.StatusForbidden},
+		{"GET", "/admin/userStatus?user_id={basic}", "", ``, http.StatusOK},
+		{"POST", "/admin/userStatus", "application/json", `{"user_id":"{basic}","status":0,"changed_by":"admin"}`, http.StatusOK},
+		{"GET", "/admin/users?limit=1", "", ``, http.StatusOK},
+		{"GET", "/admin/users?limit=0", "", ``, http.StatusBadRequest},
+		{"GET", "/admin/stats", "", ``, http.StatusOK},
+		{"POST", "/admin/users/import", "text/csv", "user_id,membership,quota_state\nimported,1,{}\n", http.StatusOK},
+		{"GET", "/admin/users/export?format=jsonl", "", ``, http.StatusOK},
+		{"GET", "/admin/snapshot", "", ``, http.StatusOK},
+		{"GET", "/users/{maniac}/export", "", ``, http.StatusOK},
+		{"DELETE", "/users/{maniac}", "", ``, http.StatusOK},
+		{"DELETE", "/users/{maniac}", "", ``, http.StatusNotFound},
+		{"DELETE", "/v1/users/{basic}", "", ``, http.StatusNoContent},
+	}
+
+	called := make(map[string]bool)
+	for _, e := range exchanges {
+		replacements := []string{}
+		for name, value := range vars {
+			replacements = append(replacements, name, value)
+		}
+		replacer := strings.NewReplacer(replacements...)
+		path, body := replacer.Replace(e.path), replacer.Replace(e.body)
+		name := e.method + " " + path
+
+		template, op := spec.operation(e.method, strings.Split(path, "?")[0])
+		if op == nil {
+			t.Errorf("%s: operation not in spec", name)
+			continue
+		}
+		called[e.method+" "+template] = true
+		if requestBody, ok := op["requestBody"]; ok && len(e.contentType) != 0 && e.status < 400 {
+			content := spec.resolve(requestBody)["content"].(map[string]interface{})
+			if err := spec.validateContent(content, e.contentType, []byte(body), name+" request"); err != nil {
+				t.Error(err)
+			}
+		}
+
+		request, _ := http.NewRequest(e.method, srv.URL+path, strings.NewReader(body))
+		if len(e.contentType) != 0 {
+			request.Header.Set("Content-Type", e.contentType)
+		}
+		resp, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		respBody, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != e.status {
+			t.Errorf("%s: expected %d got %d %s", name, e.status, resp.StatusCode, respBody)
+			continue
+		}
+		response, ok := op["responses"].(map[string]interface{})[strconv.Itoa(resp.StatusCode)]
+		if !ok {
+			t.Errorf("%s: status %d not documented", name, resp.StatusCode)
+			continue
+		}
+		r := spec.resolve(response)
+		headers, _ := r["headers"].(map[string]interface{})
+		for header, h := range headers {
+			if spec.resolve(h)["required"] == true && len(resp.Header.Get(header)) == 0 {
+				t.Errorf("%s: missing header %s", name, header)
+			}
+		}
+		if content, ok := r["content"].(map[string]interface{}); ok {
+			if err := spec.validateContent(content, resp.Header.Get("Content-Type"), respBody, name+" response"); err != nil {
+				t.Error(err)
+			}
+		} else if len(respBody) != 0 {
+			t.Errorf("%s: undocumented body %s", name, respBody)
+		}
+
+		if template == "/guestPass" {
+			var pass GuestPassInfo
+			json.Unmarshal(respBody, &pass)
+			vars["{guest_code}"] = pass.GuestCode
+		}
+	}
+
+	missing := []string{}
+	for template, item := range spec["paths"].(map[string]interface{}) {
+		for method := range item.(map[string]interface{}) {
+			if method != "parameters" && !called[strings.ToUpper(method)+" "+template] {
+				missing = append(missing, strings.ToUpper(method)+" "+template)
+			}
+		}
+	}
+	sort.Strings(missing)
+	if len(missing) != 0 {
+		t.Errorf("operations not checked: %v", missing)
+	}
+}
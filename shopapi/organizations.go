@@ -0,0 +1,268 @@
+package shopapi
+
+import (
+	"CoffeeShop/coffeedb"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+var ErrOrganizationCapReached = errors.New("organization monthly cap reached")
+
+// OrganizationRegister admin request to create an organization
+// MonthlySpendCap is in cents, a zero cap means no cap
+type OrganizationRegister struct {
+	OrgId           string `json:"org_id"`
+	Name            string `json:"name"`
+	MonthlyDrinkCap uint32 `json:"monthly_drink_cap"`
+	MonthlySpendCap uint64 `json:"monthly_spend_cap"`
+}
+
+// OrganizationMemberInfo admin request to add a user to an organization
+type OrganizationMemberInfo struct {
+	OrgId  string `json:"org_id"`
+	UserId string `json:"user_id"`
+}
+
+// StatementLine drinks of one coffee type bought by one user in the month
+type StatementLine struct {
+	UserId    string              `json:"user_id"`
+	Coffee    coffeedb.CoffeeType `json:"coffee_type"`
+	Quantity  uint32              `json:"quantity"`
+	UnitPrice uint64              `json:"unit_price"`
+	Amount    uint64              `json:"amount"`
+}
+
+// OrganizationStatement month-end invoice of an organization
+type OrganizationStatement struct {
+	OrgId       string          `json:"org_id"`
+	Name        string          `json:"name"`
+	Month       string          `json:"month"`
+	Lines       []StatementLine `json:"lines"`
+	TotalDrinks uint32          `json:"total_drinks"`
+	TotalAmount uint64          `json:"total_amount"`
+}
+
+func (shop *Shop) coffeePrice(coffee coffeedb.CoffeeType) uint64 {
+	return shop.config.Prices[coffee]
+}
+
+// billingMonth returns month of the time (unix seconds) formatted as "2006-01" in UTC
+func billingMonth(timeSeconds int64) string {
+	return time.Unix(timeSeconds, 0).UTC().Format("2006-01")
+}
+
+// chargeOrganization charges the coffee to the organization in the current
+// month, it returns ErrOrganizationCapReached instead if the coffee would
+// exceed drinks or spending cap, the returned undo takes the charge back
+func (shop *Shop) chargeOrganization(orgId string, coffee coffeedb.CoffeeType, timeNowSeconds int64) (undoPurchase, error) {
+	month := billingMonth(timeNowSeconds)
+	price := shop.coffeePrice(coffee)
+	err := shop.db.ChargeOrganization(orgId, month, price, func(org *coffeedb.Organization, usage coffeedb.OrganizationUsage) error {
+		if org.MonthlyDrinkCap != 0 && usage.Drinks >= org.MonthlyDrinkCap {
+			return fmt.Errorf("%w: %d drinks", ErrOrganizationCapReached, org.MonthlyDrinkCap)
+		}
+		if org.MonthlySpendCap != 0 && usage.Spent+price > org.MonthlySpendCap {
+			return fmt.Errorf("%w: %d spent", ErrOrganizationCapReached, usage.Spent)
+		}
+		return nil
+	})
+	if errors.Is(err, coffeedb.ErrOrganizationNotFound) {
+		return nil, fmt.Errorf("%w %s", coffeedb.ErrOrganizationNotFound, orgId)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return func() error { return shop.db.RefundOrganization(orgId, month, price) }, nil
+}
+
+// organizationStatement builds the statement of the organization for month ("2006-01")
+// from the purchases ledger, lines are sorted by user id and coffee type
+func (shop *Shop) organizationStatement(orgId string, month string) (*OrganizationStatement, error) {
+	org := shop.db.GetOrganizationData(orgId)
+	if org == nil {
+		return nil, fmt.Errorf("%w %s", coffeedb.ErrOrganizationNotFound, orgId)
+	}
+	purchases, err := shop.db.Purchases(func(p *coffeedb.Purchase) bool {
+		return p.Organization == orgId && billingMonth(p.Time) == month
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	type lineKey struct {
+		userId    string
+		coffee    coffeedb.CoffeeType
+		unitPrice uint64
+	}
+	lines := make(map[lineKey]*StatementLine)
+	statement := OrganizationStatement{OrgId: orgId, Name: org.Name, Month: month, Lines: []StatementLine{}}
+	for _, p := range purchases {
+		key := lineKey{p.UserId, p.Coffee, p.Price}
+		line, ok := lines[key]
+		if !ok {
+			line = &StatementLine{UserId: p.UserId, Coffee: p.Coffee, UnitPrice: p.Price}
+			lines[key] = line
+		}
+		line.Quantity++
+		line.Amount += p.Price
+		statement.TotalDrinks++
+		statement.TotalAmount += p.Price
+	}
+	for _, line := range lines {
+		statement.Lines = append(statement.Lines, *line)
+	}
+	sort.Slice(statement.Lines, func(i, j int) bool {
+		a, b := statement.Lines[i], statement.Lines[j]
+		if a.UserId != b.UserId {
+			return a.UserId < b.UserId
+		}
+		if a.Coffee != b.Coffee {
+			return a.Coffee < b.Coffee
+		}
+		return a.UnitPrice < b.UnitPrice
+	})
+	return &statement, nil
+}
+
+// WriteCsv writes statement lines and a total row as csv
+func (s *OrganizationStatement) WriteCsv(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"user_id", "coffee_type", "quantity", "unit_price", "amount"})
+	for _, line := range s.Lines {
+		cw.Write([]string{
+			line.UserId,
+			line.Coffee.String(),
+			strconv.FormatUint(uint64(line.Quantity), 10),
+			strconv.FormatUint(line.UnitPrice, 10),
+			strconv.FormatUint(line.Amount, 10),
+		})
+	}
+	cw.Write([]string{"total", "", strconv.FormatUint(uint64(s.TotalDrinks), 10), "", strconv.FormatUint(s.TotalAmount, 10)})
+	cw.Flush()
+	return cw.Error()
+}
+
+// apiOrganizations POST creates an organization, GET returns org_id data
+func (shop *Shop) apiOrganizations(writer http.ResponseWriter, request *http.Request) {
+	switch request.Method {
+	case "POST":
+		var orgReg OrganizationRegister
+		err := shop.readJson(request, &orgReg)
+		if err != nil {
+			writeBodyError(writer, err)
+			return
+		}
+		if len(orgReg.OrgId) == 0 {
+			writeError(writer, http.StatusBadRequest, CodeInvalidRequest, "empty organization id")
+			return
+		}
+		err = shop.db.CreateOrganization(orgReg.OrgId, &coffeedb.Organization{
+			Name:            orgReg.Name,
+			MonthlyDrinkCap: orgReg.MonthlyDrinkCap,
+			MonthlySpendCap: orgReg.MonthlySpendCap,
+		})
+		if err != nil {
+			writeErrorStatus(writer, http.StatusBadRequest, err)
+			return
+		}
+		writeJson(writer, http.StatusOK, shop.db.GetOrganizationData(orgReg.OrgId))
+	case "GET":
+		orgId := request.URL.Query().Get("org_id")
+		if len(orgId) == 0 {
+			writeError(writer, http.StatusBadRequest, CodeInvalidRequest, "empty organization id")
+			return
+		}
+		if !coffeedb.ValidRecordId(orgId) {
+			writeErrorStatus(writer, http.StatusBadRequest, coffeedb.ErrInvalidOrganizationId)
+			return
+		}
+		org := shop.db.GetOrganizationData(orgId)
+		if org == nil {
+			writeError(writer, http.StatusBadRequest, CodeOrganizationNotFound, "organization not found "+orgId)
+			return
+		}
+		writeJson(writer, http.StatusOK, org)
+	default:
+		writeError(writer, http.StatusNotFound, CodeMethodNotAllowed, "Method is not supported.")
+	}
+}
+
+// apiOrganizationMembers POST adds a member, DELETE removes user_id from org_id
+func (shop *Shop) apiOrganizationMembers(writer http.ResponseWriter, request *http.Request) {
+	var memberInfo OrganizationMemberInfo
+	switch request.Method {
+	case "POST":
+		if err := shop.readJson(request, &memberInfo); err != nil {
+			writeBodyError(writer, err)
+			return
+		}
+	case "DELETE":
+		memberInfo.OrgId = request.URL.Query().Get("org_id")
+		memberInfo.UserId = request.URL.Query().Get("user_id")
+	default:
+		writeError(writer, http.StatusNotFound, CodeMethodNotAllowed, "Method is not supported.")
+		return
+	}
+	if len(memberInfo.OrgId) == 0 || len(memberInfo.UserId) == 0 {
+		writeError(writer, http.StatusBadRequest, CodeInvalidRequest, "empty organization or user id")
+		return
+	}
+	var err error
+	if request.Method == "POST" {
+		err = shop.db.AddOrganizationMember(memberInfo.OrgId, memberInfo.UserId)
+	} else {
+		err = shop.db.RemoveOrganizationMember(memberInfo.OrgId, memberInfo.UserId)
+	}
+	if err != nil {
+		writeErrorStatus(writer, http.StatusBadRequest, err)
+		return
+	}
+	writeJson(writer, http.StatusOK, shop.db.GetOrganizationData(memberInfo.OrgId))
+}
+
+// apiOrganizationStatement GET returns org_id statement of month ("2006-01",
+// current month by default) as json or as csv if format=csv
+func (shop *Shop) apiOrganizationStatement(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != "GET" {
+		writeError(writer, http.StatusNotFound, CodeMethodNotAllowed, "Method is not supported.")
+		return
+	}
+	query := request.URL.Query()
+	orgId := query.Get("org_id")
+	if len(orgId) == 0 {
+		writeError(writer, http.StatusBadRequest, CodeInvalidRequest, "empty organization id")
+		return
+	}
+	if !coffeedb.ValidRecordId(orgId) {
+		writeErrorStatus(writer, http.StatusBadRequest, coffeedb.ErrInvalidOrganizationId)
+		return
+	}
+	month := query.Get("month")
+	if len(month) == 0 {
+		month = billingMonth(shop.now().Unix())
+	} else if _, err := time.Parse("2006-01", month); err != nil {
+		writeError(writer, http.StatusBadRequest, CodeInvalidRequest, "invalid month, expected format YYYY-MM")
+		return
+	}
+	statement, err := shop.organizationStatement(orgId, month)
+	if err != nil {
+		writeErrorStatus(writer, http.StatusBadRequest, err)
+		return
+	}
+	switch query.Get("format") {
+	case "", "json":
+		writeJson(writer, http.StatusOK, statement)
+	case "csv":
+		writer.Header().Set("Content-Type", "text/csv")
+		writer.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s-%s.csv", orgId, month))
+		statement.WriteCsv(writer)
+	default:
+		writeError(writer, http.StatusBadRequest, CodeInvalidRequest, "invalid format, expected json or csv")
+	}
+}
@@ -0,0 +1,45 @@
+package shopapi
+
+import (
+	"CoffeeShop/coffeedb"
+	"net/http"
+	"strconv"
+)
+
+// setRateLimitHeaders sets RateLimit-Limit, RateLimit-Remaining and
+// RateLimit-Reset (seconds until the quota time frame ends) of the coffee type
+func setRateLimitHeaders(header http.Header, status *UserQuotaStatus, coffee coffeedb.CoffeeType, timeNowSeconds int64) {
+	for _, cs := range status.Coffees {
+		if cs.Coffee != coffee {
+			continue
+		}
+		var reset int64
+		if cs.ResetAt > timeNowSeconds {
+			reset = cs.ResetAt - timeNowSeconds
+		}
+		header.Set("RateLimit-Limit", strconv.FormatUint(uint64(cs.Limit), 10))
+		header.Set("RateLimit-Remaining", strconv.FormatUint(uint64(cs.Remaining), 10))
+		header.Set("RateLimit-Reset", strconv.FormatInt(reset, 10))
+		return
+	}
+}
+
+// writeBuyResult writes the result of a purchase with RateLimit headers of
+// the coffee type, the updated quota status with status or 429 with
+// Retry-After if limit is set
+func (shop *Shop) writeBuyResult(writer http.ResponseWriter, status int, userId string, coffee coffeedb.CoffeeType, limit *CoffeeLimitExceed) {
+	quotaStatus, err := shop.Status(userId)
+	if err == nil {
+		setRateLimitHeaders(writer.Header(), quotaStatus, coffee, shop.now().Unix())
+	}
+	if limit != nil {
+		writer.Header().Set("Retry-After", strconv.FormatInt(limit.AvailableIn, 10))
+		writeLimitExceeded(writer, limitExceededMessage(userId, limit), limit)
+		return
+	}
+	if err != nil {
+		writeShopError(writer, err)
+		return
+	}
+	writeJson(writer, status, quotaStatus)
+}
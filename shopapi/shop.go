@@ -0,0 +1,260 @@
+package shopapi
+
+import (
+	"CoffeeShop/coffeedb"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+)
+
+var ErrEmptyUserId = errors.New("empty user id")
+var ErrInvalidUserId = coffeedb.ErrInvalidUserId
+
+// Config quotas per membership type and coffee prices in cents,
+// prices are used for organization billing, IdempotencyTTL is how long
+// purchases with an Idempotency-Key are replayed, DefaultIdempotencyTTL if zero,
+// MaxBodyBytes is the size limit of JSON request bodies, DefaultMaxBodyBytes if zero,
+// MaxImportBytes is the size limit of bulk import bodies, DefaultMaxImportBytes if zero
+type Config struct {
+	Memberships    map[coffeedb.MembershipType]CoffeeQuotaPerMembership
+	Prices         map[coffeedb.CoffeeType]uint64
+	IdempotencyTTL time.Duration
+	MaxBodyBytes   int64
+	MaxImportBytes int64
+}
+
+// DefaultConfig returns a default config
+// something like:
+// Basic:
+// 1 Espresso in last 24 hours
+// 2 Americano in last 24 hours
+// 3 Cappuccino in last 24 hours
+//
+// Membership "Coffeelover":
+// 5 Espresso in last 24 hours
+// 5 Americano in last 24 hours
+// 5 Cappuccino in last 24 hours
+//
+// Membership "Espresso Maniac"
+// 5 Espresso in last 60 minutes
+// Cappuccino/Americano same as "Basic"
+// 2 guest passes in last 7 days
+func DefaultConfig() Config {
+	config := Config{Memberships: make(map[coffeedb.MembershipType]CoffeeQuotaPerMembership)}
+
+	//basic membership config
+	basicEspressoCoffeeQuota := CoffeeQuota{Type: coffeedb.Espresso, Amount: 1, TimeFrame: int64(time.Hour * 24)}
+	basicAmericanoCoffeeQuota := CoffeeQuota{Type: coffeedb.Americano, Amount: 2, TimeFrame: int64(time.Hour * 24)}
+	basicCappuccinoCoffeeQuota := CoffeeQuota{Type: coffeedb.Cappuccino, Amount: 3, TimeFrame: int64(time.Hour * 24)}
+	basicQuotas := []CoffeeQuota{basicEspressoCoffeeQuota, basicAmericanoCoffeeQuota, basicCappuccinoCoffeeQuota}
+	config.Memberships[coffeedb.Basic] = CoffeeQuotaPerMembership{Membership: coffeedb.Basic, Quota: basicQuotas}
+
+	//CoffeeLover membership config
+	coffeeLoverEspressoCoffeeQuota := CoffeeQuota{Type: coffeedb.Espresso, Amount: 5, TimeFrame: int64(time.Hour * 24)}
+	coffeeLoverAmericanoCoffeeQuota := CoffeeQuota{Type: coffeedb.Americano, Amount: 5, TimeFrame: int64(time.Hour * 24)}
+	coffeeLoverCappuccinoCoffeeQuota := CoffeeQuota{Type: coffeedb.Cappuccino, Amount: 5, TimeFrame: int64(time.Hour * 24)}
+	coffeeLoverQuotas := []CoffeeQuota{coffeeLoverEspressoCoffeeQuota, coffeeLoverAmericanoCoffeeQuota, coffeeLoverCappuccinoCoffeeQuota}
+	config.Memberships[coffeedb.CoffeeLover] = CoffeeQuotaPerMembership{Membership: coffeedb.CoffeeLover, Quota: coffeeLoverQuotas}
+
+	//Espresso Maniac membership config
+	espressoManiacEspressoCoffeeQuota := CoffeeQuota{Type: coffeedb.Espresso, Amount: 5, TimeFrame: int64(time.Hour)}
+	espressoManiacAmericanoCoffeeQuota := CoffeeQuota{Type: coffeedb.Americano, Amount: 2, TimeFrame: int64(time.Hour * 24)}
+	espressoManiacCappuccinoCoffeeQuota := CoffeeQuota{Type: coffeedb.Cappuccino, Amount: 3, TimeFrame: int64(time.Hour * 24)}
+	espressoManiacQuotas := []CoffeeQuota{espressoManiacEspressoCoffeeQuota, espressoManiacAmericanoCoffeeQuota, espressoManiacCappuccinoCoffeeQuota}
+	config.Memberships[coffeedb.EspressoManiac] = CoffeeQuotaPerMembership{
+		Membership:     coffeedb.EspressoManiac,
+		Quota:          espressoManiacQuotas,
+		GuestPasses:    2,
+		GuestTimeFrame: int64(time.Hour * 24 * 7),
+	}
+
+	config.Prices = map[coffeedb.CoffeeType]uint64{
+		coffeedb.Espresso:   250,
+		coffeedb.Americano:  300,
+		coffeedb.Cappuccino: 350,
+	}
+	config.IdempotencyTTL = DefaultIdempotencyTTL
+	config.MaxBodyBytes = DefaultMaxBodyBytes
+	config.MaxImportBytes = DefaultMaxImportBytes
+	return config
+}
+
+// PrintConfig prints quotas of all membership types
+func (c *Config) PrintConfig() {
+	memberships := make([]coffeedb.MembershipType, 0, len(c.Memberships))
+	for m := range c.Memberships {
+		memberships = append(memberships, m)
+	}
+	sort.Slice(memberships, func(i, j int) bool { return memberships[i] < memberships[j] })
+	for _, m := range memberships {
+		cqm := c.Memberships[m]
+		cqm.PrintConfig()
+	}
+}
+
+// Shop coffee shop service with its own store, config and clock,
+// several shops can run in one process
+type Shop struct {
+	db          *coffeedb.CoffeeDb
+	config      Config
+	now         func() time.Time
+	idempotency *idempotencyStore
+}
+
+// NewShop creates a shop on the store, clock returns the current time
+// and is time.Now if nil
+func NewShop(store *coffeedb.CoffeeDb, config Config, clock func() time.Time) *Shop {
+	if clock == nil {
+		clock = time.Now
+	}
+	return &Shop{db: store, config: config, now: clock, idempotency: newIdempotencyStore(maxIdempotencyEntries)}
+}
+
+// Store returns the db of the shop
+func (shop *Shop) Store() *coffeedb.CoffeeDb {
+	return shop.db
+}
+
+// Handler returns the http handler with all api endpoints of the shop
+func (shop *Shop) Handler() http.Handler {
+	httpHandler := http.NewServeMux()
+	httpHandler.Handle("/", http.HandlerFunc(apiNotFound))
+	httpHandler.Handle("/openapi.json", http.HandlerFunc(apiOpenApi))
+	httpHandler.Handle("/v1/users", http.HandlerFunc(shop.apiV1Users))
+	httpHandler.Handle("/v1/users/", http.HandlerFunc(shop.apiV1User))
+	//legacy routes
+	httpHandler.Handle("/registerUser", http.HandlerFunc(shop.apiRegisterUser))
+	httpHandler.Handle("/buyCoffee", http.HandlerFunc(shop.apiBuyCoffee))
+	httpHandler.Handle("/guestPass", http.HandlerFunc(shop.apiGuestPass))
+	httpHandler.Handle("/users/", http.HandlerFunc(shop.apiUsers))
+	httpHandler.Handle("/admin/users", http.HandlerFunc(shop.apiListUsers))
+	httpHandler.Handle("/admin/users/import", http.HandlerFunc(shop.apiImportUsers))
+	httpHandler.Handle("/admin/users/export", http.HandlerFunc(shop.apiExportUsers))
+	httpHandler.Handle("/admin/stats", http.HandlerFunc(shop.apiStats))
+	httpHandler.Handle("/admin/snapshot", http.HandlerFunc(shop.apiSnapshot))
+	httpHandler.Handle("/admin/userStatus", http.HandlerFunc(shop.apiUserStatus))
+	httpHandler.Handle("/admin/grants", http.HandlerFunc(shop.apiGrants))
+	httpHandler.Handle("/admin/groups", http.HandlerFunc(shop.apiGroups))
+	httpHandler.Handle("/admin/groups/members", http.HandlerFunc(shop.apiGroupMembers))
+	httpHandler.Handle("/admin/organizations", http.HandlerFunc(shop.apiOrganizations))
+	httpHandler.Handle("/admin/organizations/members", http.HandlerFunc(shop.apiOrganizationMembers))
+	httpHandler.Handle("/admin/organizations/statement", http.HandlerFunc(shop.apiOrganizationStatement))
+	return httpHandler
+}
+
+// Register registers a new user, returns coffeedb.ErrUserExists if
+// the user is registered already
+func (shop *Shop) Register(userId string, membership coffeedb.MembershipType) error {
+	if len(userId) == 0 {
+		return ErrEmptyUserId
+	}
+	if !coffeedb.ValidUserId(userId) {
+		return ErrInvalidUserId
+	}
+	if err := membership.IsValid(); err != nil {
+		return err
+	}
+	return shop.db.RegisterUser(userId, membership)
+}
+
+// Buy buys a coffee for a user or, if GuestCode is set, with a guest pass
+// returns limit exceeded info if the quota is exhausted
+func (shop *Shop) Buy(info CoffeeBuyInfo) (*CoffeeLimitExceed, error) {
+	if len(info.GuestCode) != 0 {
+		return nil, shop.buyCoffeeWithGuestPass(info.GuestCode, info.Coffee)
+	}
+	if len(info.UserId) == 0 {
+		return nil, ErrEmptyUserId
+	}
+	if err := info.Coffee.IsValid(); err != nil {
+		return nil, err
+	}
+	return shop.buyCoffee(info.UserId, info.Coffee)
+}
+
+// CoffeeStatus quota of one coffee type, ResetAt is the end of the current
+// quota time frame (unix seconds) and zero if none was bought in it
+type CoffeeStatus struct {
+	Coffee    coffeedb.CoffeeType `json:"coffee_type"`
+	Limit     uint32              `json:"limit"`
+	Remaining uint32              `json:"remaining"`
+	ResetAt   int64               `json:"reset_at,omitempty"`
+	Granted   uint32              `json:"granted,omitempty"`
+}
+
+// UserQuotaStatus quotas of a user, quotas of a group member are
+// the quotas of the group pool limited by member's sub-limits
+type UserQuotaStatus struct {
+	UserId     string                  `json:"user_id"`
+	Membership coffeedb.MembershipType `json:"membership"`
+	Status     coffeedb.UserStatus     `json:"status"`
+	Group      string                  `json:"group,omitempty"`
+	Coffees    []CoffeeStatus          `json:"coffees"`
+}
+
+// remainingQuota returns coffees left in the time frame and its end
+func remainingQuota(state *coffeedb.UserCoffeeQuota, limit uint32, timeFrame int64, timeNowSeconds int64) (uint32, int64) {
+	quotaInSeconds := int64(time.Duration(timeFrame).Seconds())
+	if state == nil || timeNowSeconds-state.StartBoughtTime >= quotaInSeconds {
+		return limit, 0
+	}
+	resetAt := state.StartBoughtTime + quotaInSeconds
+	if state.AmountBought >= limit {
+		return 0, resetAt
+	}
+	return limit - state.AmountBought, resetAt
+}
+
+// Status returns the quotas of the user at the shop's current time
+func (shop *Shop) Status(userId string) (*UserQuotaStatus, error) {
+	qs := shop.db.GetUserData(userId)
+	if qs == nil {
+		return nil, fmt.Errorf("%w %s", coffeedb.ErrUserNotFound, userId)
+	}
+	timeNowSeconds := shop.now().Unix()
+	status := UserQuotaStatus{UserId: userId, Membership: qs.Membership, Status: qs.Status, Group: qs.Group, Coffees: []CoffeeStatus{}}
+	if status.Status.Effective(timeNowSeconds) == coffeedb.Active {
+		status.Status = coffeedb.UserStatus{}
+	}
+	poolState := qs.QuotaState
+	var subLimits map[coffeedb.CoffeeType]uint32
+	if len(qs.Group) != 0 {
+		group := shop.db.GetGroupData(qs.Group)
+		if group == nil {
+			return nil, fmt.Errorf("%w %s", coffeedb.ErrGroupNotFound, qs.Group)
+		}
+		status.Membership = group.Membership
+		poolState = group.QuotaState
+		subLimits = group.Members[userId].SubLimits
+	}
+
+	for _, cq := range shop.config.Memberships[status.Membership].Quota {
+		cs := CoffeeStatus{Coffee: cq.Type, Limit: cq.Amount}
+		cs.Remaining, cs.ResetAt = remainingQuota(quotaState(poolState, cq.Type), cq.Amount, cq.TimeFrame, timeNowSeconds)
+		if subLimit, ok := subLimits[cq.Type]; ok {
+			memberRemaining, memberResetAt := remainingQuota(quotaState(qs.QuotaState, cq.Type), subLimit, cq.TimeFrame, timeNowSeconds)
+			if memberRemaining < cs.Remaining {
+				cs.Remaining, cs.ResetAt = memberRemaining, memberResetAt
+			}
+		}
+		for i := range qs.Grants {
+			if qs.Grants[i].Coffee == cq.Type && qs.Grants[i].IsActive(timeNowSeconds) {
+				cs.Granted += qs.Grants[i].Remaining()
+			}
+		}
+		status.Coffees = append(status.Coffees, cs)
+	}
+	return &status, nil
+}
+
+// History returns all purchases of the user
+func (shop *Shop) History(userId string) ([]coffeedb.Purchase, error) {
+	if shop.db.GetUserData(userId) == nil {
+		return nil, fmt.Errorf("%w %s", coffeedb.ErrUserNotFound, userId)
+	}
+	return shop.db.Purchases(func(p *coffeedb.Purchase) bool {
+		return p.UserId == userId
+	})
+}
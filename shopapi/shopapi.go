@@ -2,12 +2,10 @@ package shopapi
 
 import (
 	"CoffeeShop/coffeedb"
+	"CoffeeShop/logging"
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
-	"log"
 	"net/http"
 	"time"
 )
@@ -18,15 +16,21 @@ type CoffeeQuota struct {
 	TimeFrame int64
 }
 
+// CoffeeQuotaPerMembership quotas of a membership type
+// GuestPasses is a separate allowance of guest passes per GuestTimeFrame,
+// without it guest passes are taken from member's own coffee quota
 type CoffeeQuotaPerMembership struct {
-	Membership coffeedb.MembershipType
-	Quota      []CoffeeQuota
+	Membership     coffeedb.MembershipType
+	Quota          []CoffeeQuota
+	GuestPasses    uint32
+	GuestTimeFrame int64
 }
 
+// CoffeeLimitExceed exhausted quota, AvailableIn is in seconds
 type CoffeeLimitExceed struct {
-	Type         coffeedb.CoffeeType
-	AmountBought uint32
-	AvailableIn  int64
+	Type         coffeedb.CoffeeType `json:"coffee_type"`
+	AmountBought uint32              `json:"amount_bought"`
+	AvailableIn  int64               `json:"available_in"`
 }
 
 type UserRegister struct {
@@ -34,9 +38,12 @@ type UserRegister struct {
 	Membership coffeedb.MembershipType `json:"membership"`
 }
 
+// CoffeeBuyInfo coffee bought by a user or, if GuestCode is set,
+// by a guest with a guest pass issued by a member
 type CoffeeBuyInfo struct {
-	UserId string              `json:"user_id"`
-	Coffee coffeedb.CoffeeType `json:"coffee_type"`
+	UserId    string              `json:"user_id"`
+	Coffee    coffeedb.CoffeeType `json:"coffee_type"`
+	GuestCode string              `json:"guest_code,omitempty"`
 }
 
 func (cqm *CoffeeQuotaPerMembership) PrintConfig() {
@@ -44,15 +51,14 @@ func (cqm *CoffeeQuotaPerMembership) PrintConfig() {
 	for _, q := range cqm.Quota {
 		fmt.Printf("%d %s in Last %s\n", q.Amount, q.Type.String(), time.Duration(q.TimeFrame).String())
 	}
+	if cqm.GuestPasses != 0 {
+		fmt.Printf("%d guest passes in Last %s\n", cqm.GuestPasses, time.Duration(cqm.GuestTimeFrame).String())
+	}
 	fmt.Println()
 }
 
-var coffeeConfig map[coffeedb.MembershipType]CoffeeQuotaPerMembership
-
-var db *coffeedb.CoffeeDb
-
-func coffeeQuotaConfig(coffee coffeedb.CoffeeType, membership coffeedb.MembershipType) (*CoffeeQuota, error) {
-	if cq, ok := coffeeConfig[membership]; ok {
+func (shop *Shop) coffeeQuotaConfig(coffee coffeedb.CoffeeType, membership coffeedb.MembershipType) (*CoffeeQuota, error) {
+	if cq, ok := shop.config.Memberships[membership]; ok {
 		for _, cQuotaItem := range cq.Quota {
 			if cQuotaItem.Type == coffee {
 				return &cQuotaItem, nil
@@ -63,203 +69,254 @@ func coffeeQuotaConfig(coffee coffeedb.CoffeeType, membership coffeedb.Membershi
 	return nil, errors.New("invalid Membership")
 }
 
-func buyCoffee(userId string, coffee coffeedb.CoffeeType) (*CoffeeLimitExceed, error) {
-	qs := db.GetUserData(userId)
-	if qs == nil {
-		return nil, errors.New("user not found " + userId)
-	}
-	cQuotaConfig, err := coffeeQuotaConfig(coffee, qs.Membership)
-	if err != nil {
-		return nil, err
-	}
-
-	timeNowSeconds := time.Now().Unix()
-	if userCoffeeQuota, ok := qs.QuotaState[coffee]; ok {
+// quotaAfterPurchase returns the quota state after buying one more coffee
+// state is nil if user is buying this coffee for the first time
+// if limit amount is reached in the time frame returns limit exceeded info instead
+func quotaAfterPurchase(coffee coffeedb.CoffeeType, state *coffeedb.UserCoffeeQuota, limit uint32, timeFrame int64, timeNowSeconds int64) (*coffeedb.UserCoffeeQuota, *CoffeeLimitExceed) {
+	if state != nil {
 		//user has bought some coffee already
-		quotaInSeconds := int64(time.Duration(cQuotaConfig.TimeFrame).Seconds())
-		timeDiff := timeNowSeconds - userCoffeeQuota.StartBoughtTime
+		quotaInSeconds := int64(time.Duration(timeFrame).Seconds())
+		timeDiff := timeNowSeconds - state.StartBoughtTime
 
 		if timeDiff < quotaInSeconds {
-			if userCoffeeQuota.AmountBought >= cQuotaConfig.Amount {
+			if state.AmountBought >= limit {
 				//return quota limit exceeded
-				return &CoffeeLimitExceed{Type: coffee, AmountBought: userCoffeeQuota.AmountBought, AvailableIn: quotaInSeconds - timeDiff}, nil
+				return nil, &CoffeeLimitExceed{Type: coffee, AmountBought: state.AmountBought, AvailableIn: quotaInSeconds - timeDiff}
 			}
-			return nil, db.SetQuotaState(userId, coffee, &coffeedb.UserCoffeeQuota{AmountBought: userCoffeeQuota.AmountBought + 1, StartBoughtTime: userCoffeeQuota.StartBoughtTime})
+			return &coffeedb.UserCoffeeQuota{AmountBought: state.AmountBought + 1, StartBoughtTime: state.StartBoughtTime}, nil
 		}
 		//time has passed quota reset user amount and time
-		return nil, db.SetQuotaState(userId, coffee, &coffeedb.UserCoffeeQuota{AmountBought: 1, StartBoughtTime: timeNowSeconds})
 	}
-	//user is buying coffee for the first time
-	return nil, db.SetQuotaState(userId, coffee, &coffeedb.UserCoffeeQuota{AmountBought: 1, StartBoughtTime: timeNowSeconds})
+	return &coffeedb.UserCoffeeQuota{AmountBought: 1, StartBoughtTime: timeNowSeconds}, nil
 }
 
-func apiRegisterUser(writer http.ResponseWriter, request *http.Request) {
-	if request.Method != "POST" {
-		http.Error(writer, "Method is not supported.", http.StatusNotFound)
-		return
+// undoPurchase gives back what a purchase step charged if a later step fails
+type undoPurchase func() error
+
+// consumeGrantOrLimit is called when regular quota is exhausted, it tries
+// extra coffees granted to the user and returns limit if there are none
+func (shop *Shop) consumeGrantOrLimit(userId string, limit *CoffeeLimitExceed, timeNowSeconds int64) (*CoffeeLimitExceed, undoPurchase, error) {
+	grantId, err := shop.db.ConsumeGrant(userId, limit.Type, timeNowSeconds)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(grantId) != 0 {
+		return nil, func() error { return shop.db.ReleaseGrant(userId, grantId) }, nil
+	}
+	return limit, nil, nil
+}
+
+// refundQuota gives back one coffee of a quota state charged by quotaAfterPurchase
+func refundQuota(state *coffeedb.UserCoffeeQuota) (*coffeedb.UserCoffeeQuota, error) {
+	if state == nil || state.AmountBought == 0 {
+		return nil, nil
+	}
+	refunded := *state
+	refunded.AmountBought--
+	return &refunded, nil
+}
+
+func quotaState(state map[coffeedb.CoffeeType]coffeedb.UserCoffeeQuota, coffee coffeedb.CoffeeType) *coffeedb.UserCoffeeQuota {
+	if q, ok := state[coffee]; ok {
+		return &q
+	}
+	return nil
+}
+
+// buyCoffee charges user's organization, then user's quota and records
+// the purchase, if a step fails the steps before it are undone
+func (shop *Shop) buyCoffee(userId string, coffee coffeedb.CoffeeType) (*CoffeeLimitExceed, error) {
+	qs := shop.db.GetUserData(userId)
+	if qs == nil {
+		return nil, fmt.Errorf("%w %s", coffeedb.ErrUserNotFound, userId)
+	}
+
+	timeNowSeconds := shop.now().Unix()
+	if err := checkUserStatus(userId, qs, timeNowSeconds); err != nil {
+		return nil, err
+	}
+	price := shop.coffeePrice(coffee)
+	var undo []undoPurchase
+	if len(qs.Organization) != 0 {
+		undoCharge, err := shop.chargeOrganization(qs.Organization, coffee, timeNowSeconds)
+		if err != nil {
+			return nil, err
+		}
+		undo = append(undo, undoCharge)
+	}
+	var limit *CoffeeLimitExceed
+	var undoQuota undoPurchase
+	var err error
+	if len(qs.Group) != 0 {
+		limit, undoQuota, err = shop.buyCoffeeForGroupMember(userId, qs, coffee, timeNowSeconds)
+	} else {
+		limit, undoQuota, err = shop.buyCoffeeForMember(userId, qs, coffee, timeNowSeconds)
+	}
+	if err == nil && limit == nil {
+		undo = append(undo, undoQuota)
+		err = shop.db.RecordPurchase(&coffeedb.Purchase{UserId: userId, Coffee: coffee, Time: timeNowSeconds, Price: price, Organization: qs.Organization})
+		if err == nil {
+			return nil, nil
+		}
 	}
-	body, err := ioutil.ReadAll(request.Body)
+	for i := len(undo) - 1; i >= 0; i-- {
+		if undoErr := undo[i](); undoErr != nil {
+			logging.Errorf("undo purchase of user %s: %v", userId, undoErr)
+		}
+	}
+	return limit, err
+}
+
+// buyCoffeeForMember checks and charges user's own quota, the returned undo
+// gives the coffee back
+func (shop *Shop) buyCoffeeForMember(userId string, qs *coffeedb.UserCoffeeMembership, coffee coffeedb.CoffeeType, timeNowSeconds int64) (*CoffeeLimitExceed, undoPurchase, error) {
+	cQuotaConfig, err := shop.coffeeQuotaConfig(coffee, qs.Membership)
 	if err != nil {
-		http.Error(writer, "could not read body", http.StatusBadRequest)
+		return nil, nil, err
 	}
-	var userReg UserRegister
-	err = json.Unmarshal(body, &userReg)
+
+	//the limit is checked on the state read under user's lock, concurrent
+	//purchases can not all pass the check on the same state
+	var limit *CoffeeLimitExceed
+	err = shop.db.UpdateQuota(userId, coffee, func(state *coffeedb.UserCoffeeQuota) (*coffeedb.UserCoffeeQuota, error) {
+		var newState *coffeedb.UserCoffeeQuota
+		newState, limit = quotaAfterPurchase(coffee, state, cQuotaConfig.Amount, cQuotaConfig.TimeFrame, timeNowSeconds)
+		return newState, nil
+	})
 	if err != nil {
-		http.Error(writer, err.Error(), http.StatusBadRequest)
-		return
+		return nil, nil, err
 	}
-	if len(userReg.UserId) == 0 {
-		http.Error(writer, "empty user id", http.StatusBadRequest)
-		return
+	if limit != nil {
+		return shop.consumeGrantOrLimit(userId, limit, timeNowSeconds)
 	}
-	registeredUser := db.GetUserData(userReg.UserId)
-	if registeredUser != nil {
-		http.Error(writer, "this user is registered already", http.StatusBadRequest)
+	return nil, func() error { return shop.db.UpdateQuota(userId, coffee, refundQuota) }, nil
+}
+
+// limitExceededMessage returns the text of a 429 response
+func limitExceededMessage(userId string, limit *CoffeeLimitExceed) string {
+	return fmt.Sprintf("User %s limit exceeded, %s bought: %d available in %s",
+		userId,
+		limit.Type.String(),
+		limit.AmountBought,
+		time.Duration(limit.AvailableIn*int64(time.Second)).String())
+}
+
+func (shop *Shop) apiRegisterUser(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != "POST" {
+		writeError(writer, http.StatusNotFound, CodeMethodNotAllowed, "Method is not supported.")
 		return
 	}
-	err = userReg.Membership.IsValid()
+	var userReg UserRegister
+	err := shop.readJson(request, &userReg)
 	if err != nil {
-		http.Error(writer, err.Error(), http.StatusBadRequest)
+		writeBodyError(writer, err)
 		return
 	}
-	err = db.RegisterUser(userReg.UserId, userReg.Membership)
+	err = shop.Register(userReg.UserId, userReg.Membership)
 	if err != nil {
-		http.Error(writer, err.Error(), http.StatusBadRequest)
+		writeErrorStatus(writer, http.StatusBadRequest, err)
 		return
 	}
-	writer.WriteHeader(http.StatusOK)
+	shop.writeUserStatus(writer, http.StatusOK, userReg.UserId)
 }
 
-func apiBuyCoffee(writer http.ResponseWriter, request *http.Request) {
+func (shop *Shop) apiBuyCoffee(writer http.ResponseWriter, request *http.Request) {
 	if request.Method != "POST" {
-		http.Error(writer, "Method is not supported.", http.StatusNotFound)
+		writeError(writer, http.StatusNotFound, CodeMethodNotAllowed, "Method is not supported.")
 		return
 	}
-	body, err := ioutil.ReadAll(request.Body)
-	if err != nil {
-		http.Error(writer, "could not read body", http.StatusBadRequest)
-	}
+	shop.idempotent(writer, request, shop.buyCoffeeHandler)
+}
+
+// buyCoffeeHandler buys a coffee of the POST /buyCoffee body
+func (shop *Shop) buyCoffeeHandler(writer http.ResponseWriter, request *http.Request) {
 	var cInfo CoffeeBuyInfo
-	err = json.Unmarshal(body, &cInfo)
+	err := shop.readJson(request, &cInfo)
 	if err != nil {
-		http.Error(writer, err.Error(), http.StatusBadRequest)
+		writeBodyError(writer, err)
 		return
 	}
-	if len(cInfo.UserId) == 0 {
-		http.Error(writer, "empty user id", http.StatusBadRequest)
+	limit, err := shop.Buy(cInfo)
+	if errors.Is(err, coffeedb.ErrGuestPassRedeemed) || errors.Is(err, coffeedb.ErrGuestPassExpired) {
+		writeErrorStatus(writer, http.StatusGone, err)
+		return
+	}
+	if writeUserSuspended(writer, err) {
+		return
+	}
+	if errors.Is(err, ErrOrganizationCapReached) {
+		writeErrorStatus(writer, http.StatusForbidden, err)
 		return
 	}
-	limit, err := buyCoffee(cInfo.UserId, cInfo.Coffee)
 	if err != nil {
-		http.Error(writer, err.Error(), http.StatusBadRequest)
+		writeErrorStatus(writer, http.StatusBadRequest, err)
 		return
 	}
-	if limit != nil {
-		http.Error(
-			writer,
-			fmt.Sprintf("User %s limit exceeded, %s bought: %d available in %s\n",
-				cInfo.UserId,
-				limit.Type.String(),
-				limit.AmountBought,
-				time.Duration(limit.AvailableIn*int64(time.Second)).String()),
-			http.StatusTooManyRequests)
+	if len(cInfo.GuestCode) != 0 {
+		writeJson(writer, http.StatusOK, shop.db.GetGuestPass(cInfo.GuestCode))
 		return
 	}
-	writer.WriteHeader(http.StatusOK)
+	shop.writeBuyResult(writer, http.StatusOK, cInfo.UserId, cInfo.Coffee, limit)
 }
 
-func clearDb() {
-	db.ClearDb()
-}
+// default timeouts of the http server
+const (
+	DefaultReadTimeout  = 10 * time.Second
+	DefaultWriteTimeout = time.Minute
+	DefaultIdleTimeout  = 2 * time.Minute
+)
 
-// InitWithConfig initialize a custom config
-// it will overwrite default one if exists
-func InitWithConfig(config map[coffeedb.MembershipType]CoffeeQuotaPerMembership) {
-	log.Println("Initializing config...")
-	coffeeConfig = config
-	for _, value := range coffeeConfig {
-		value.PrintConfig()
-	}
+// HttpTimeouts timeouts of the http server, Read limits reading headers
+// and body so slow clients can not hold connections, Write limits the
+// whole request including the handler, Idle how long keep-alive
+// connections wait for the next request
+type HttpTimeouts struct {
+	Read  time.Duration
+	Write time.Duration
+	Idle  time.Duration
 }
 
-// InitDefaultConfig initialize a default config
-// something like:
-// Basic:
-// 1 Espresso in last 24 hours
-// 2 Americano in last 24 hours
-// 3 Cappuccino in last 24 hours
-//
-// Membership "Coffeelover":
-// 5 Espresso in last 24 hours
-// 5 Americano in last 24 hours
-// 5 Cappuccino in last 24 hours
-//
-// Membership "Espresso Maniac"
-// 5 Espresso in last 60 minutes
-// Cappuccino/Americano same as "Basic"
-func InitDefaultConfig() {
-	coffeeConfig = make(map[coffeedb.MembershipType]CoffeeQuotaPerMembership)
-
-	//basic membership config
-	basicEspressoCoffeeQuota := CoffeeQuota{Type: coffeedb.Espresso, Amount: 1, TimeFrame: int64(time.Hour * 24)}
-	basicAmericanoCoffeeQuota := CoffeeQuota{Type: coffeedb.Americano, Amount: 2, TimeFrame: int64(time.Hour * 24)}
-	basicCappuccinoCoffeeQuota := CoffeeQuota{Type: coffeedb.Cappuccino, Amount: 3, TimeFrame: int64(time.Hour * 24)}
-	basicQuotas := []CoffeeQuota{basicEspressoCoffeeQuota, basicAmericanoCoffeeQuota, basicCappuccinoCoffeeQuota}
-	basicCoffeeConfig := CoffeeQuotaPerMembership{Membership: coffeedb.Basic, Quota: basicQuotas}
-	coffeeConfig[coffeedb.Basic] = basicCoffeeConfig
-	basicCoffeeConfig.PrintConfig()
-
-	//CoffeeLover membership config
-	coffeeLoverEspressoCoffeeQuota := CoffeeQuota{Type: coffeedb.Espresso, Amount: 5, TimeFrame: int64(time.Hour * 24)}
-	coffeeLoverAmericanoCoffeeQuota := CoffeeQuota{Type: coffeedb.Americano, Amount: 5, TimeFrame: int64(time.Hour * 24)}
-	coffeeLoverCappuccinoCoffeeQuota := CoffeeQuota{Type: coffeedb.Cappuccino, Amount: 5, TimeFrame: int64(time.Hour * 24)}
-	coffeeLoverQuotas := []CoffeeQuota{coffeeLoverEspressoCoffeeQuota, coffeeLoverAmericanoCoffeeQuota, coffeeLoverCappuccinoCoffeeQuota}
-	coffeeLoverCoffeeConfig := CoffeeQuotaPerMembership{Membership: coffeedb.CoffeeLover, Quota: coffeeLoverQuotas}
-	coffeeConfig[coffeedb.CoffeeLover] = coffeeLoverCoffeeConfig
-	coffeeLoverCoffeeConfig.PrintConfig()
-
-	//Espresso Maniac membership config
-	espressoManiacEspressoCoffeeQuota := CoffeeQuota{Type: coffeedb.Espresso, Amount: 5, TimeFrame: int64(time.Hour)}
-	espressoManiacAmericanoCoffeeQuota := CoffeeQuota{Type: coffeedb.Americano, Amount: 2, TimeFrame: int64(time.Hour * 24)}
-	espressoManiacCappuccinoCoffeeQuota := CoffeeQuota{Type: coffeedb.Cappuccino, Amount: 3, TimeFrame: int64(time.Hour * 24)}
-	espressoManiacQuotas := []CoffeeQuota{espressoManiacEspressoCoffeeQuota, espressoManiacAmericanoCoffeeQuota, espressoManiacCappuccinoCoffeeQuota}
-	espressoManiacCoffeeConfig := CoffeeQuotaPerMembership{Membership: coffeedb.EspressoManiac, Quota: espressoManiacQuotas}
-	coffeeConfig[coffeedb.EspressoManiac] = espressoManiacCoffeeConfig
-	espressoManiacCoffeeConfig.PrintConfig()
+// DefaultHttpTimeouts returns the default timeouts
+func DefaultHttpTimeouts() HttpTimeouts {
+	return HttpTimeouts{Read: DefaultReadTimeout, Write: DefaultWriteTimeout, Idle: DefaultIdleTimeout}
 }
 
-// InitDb - database initializing
-// dbFolder is the folder where user's data will be stored
-func InitDb(dbFolder string) {
-	var err error
-	db, err = coffeedb.Init(dbFolder)
-	if err != nil {
-		log.Fatal(err)
+// NewHttpServer returns a server of handler on addr with the timeouts
+func NewHttpServer(addr string, handler http.Handler, timeouts HttpTimeouts) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: timeouts.Read,
+		ReadTimeout:       timeouts.Read,
+		WriteTimeout:      timeouts.Write,
+		IdleTimeout:       timeouts.Idle,
 	}
 }
 
-// StartHttpServer starts http server and init api endpoints
-func StartHttpServer(server *http.Server, httpHandler *http.ServeMux) {
-	log.Println("Starting server")
-
-	httpHandler.Handle("/registerUser", http.HandlerFunc(apiRegisterUser))
-	httpHandler.Handle("/buyCoffee", http.HandlerFunc(apiBuyCoffee))
+// StartHttpServer starts http server, the handler of the server
+// is usually Shop.Handler()
+func StartHttpServer(server *http.Server) {
+	logging.Infof("starting server on %s", server.Addr)
 
 	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 		panic(err)
 	} else {
-		log.Println("server stopped gracefully")
+		logging.Infof("server stopped gracefully")
 	}
 }
 
-// ShutdownHttpServer stops http server
+// ShutdownHttpServer stops http server, waits for open requests until
+// the deadline of ctx or 5 seconds if ctx has no deadline
 func ShutdownHttpServer(ctx context.Context, server *http.Server) {
-	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
-	defer cancel()
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+	}
 
 	if err := server.Shutdown(ctx); err != nil {
 		panic(err)
 	} else {
-		log.Println("server shutdown")
+		logging.Infof("server shutdown")
 	}
 }
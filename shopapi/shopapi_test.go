@@ -4,11 +4,20 @@ import (
 	"CoffeeShop/coffeedb"
 	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/google/uuid"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -63,13 +72,72 @@ func buyACoffeeForUser(userId string, cType coffeedb.CoffeeType, serverUrl strin
 	return resp.StatusCode, nil
 }
 
-func serverSetup() *httptest.Server {
-	mux := http.NewServeMux()
+func postJson(url string, v interface{}) (int, error) {
+	postBody, _ := json.Marshal(v)
+	resp, err := http.Post(url, "application/json", bytes.NewBuffer(postBody))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, err
+	}
+	log.Printf(string(body))
+	return resp.StatusCode, nil
+}
 
-	mux.Handle("/registerUser", http.HandlerFunc(apiRegisterUser))
-	mux.Handle("/buyCoffee", http.HandlerFunc(apiBuyCoffee))
+func getBody(url string) (int, []byte, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	return resp.StatusCode, body, err
+}
 
-	return httptest.NewServer(mux)
+func grantCoffeeToUser(grant GrantRequest, serverUrl string) (int, error) {
+	return postJson(serverUrl+"/admin/grants", grant)
+}
+
+// shopSetup creates a shop on its own data folder, so tests can run in parallel
+func shopSetup(t *testing.T, config Config, opts coffeedb.Options, clock func() time.Time) *Shop {
+	store, err := coffeedb.InitWithOptions(t.TempDir(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		store.Close()
+		store.ClearDb()
+	})
+	return NewShop(store, config, clock)
+}
+
+func serverSetup(shop *Shop) *httptest.Server {
+	return httptest.NewServer(shop.Handler())
+}
+
+// testClock time of a shop which moves only when advanced
+type testClock struct {
+	lock sync.Mutex
+	now  time.Time
+}
+
+func newTestClock() *testClock {
+	return &testClock{now: time.Now()}
+}
+
+func (c *testClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now
+}
+
+func (c *testClock) Advance(d time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.now = c.now.Add(d)
 }
 
 func serverTeardown(s *httptest.Server) {
@@ -77,9 +145,8 @@ func serverTeardown(s *httptest.Server) {
 }
 
 func TestRegisterUsers(t *testing.T) {
-	InitDefaultConfig()
-	InitDb("Tmp")
-	srv := serverSetup()
+	t.Parallel()
+	srv := serverSetup(shopSetup(t, DefaultConfig(), coffeedb.DefaultOptions(), nil))
 
 	testFailed := false
 	usersId := generateUserId(100)
@@ -95,7 +162,6 @@ func TestRegisterUsers(t *testing.T) {
 	}
 	serverTeardown(srv)
 
-	clearDb()
 	if testFailed {
 		t.Fail()
 	}
@@ -112,9 +178,9 @@ func TestBuyEspressoCoffee(t *testing.T) {
 	basicCoffeeConfig := CoffeeQuotaPerMembership{Membership: coffeedb.Basic, Quota: basicQuotas}
 	customConfig[coffeedb.Basic] = basicCoffeeConfig
 
-	InitWithConfig(customConfig)
-	InitDb("Tmp")
-	srv := serverSetup()
+	t.Parallel()
+	clock := newTestClock()
+	srv := serverSetup(shopSetup(t, Config{Memberships: customConfig}, coffeedb.DefaultOptions(), clock.Now))
 
 	testFailed := false
 	resCode, err := registerUserWithMembership("e6b92500-6cbf-4848-ac51-1ff07c76d88e", coffeedb.Basic, srv.URL)
@@ -134,7 +200,7 @@ func TestBuyEspressoCoffee(t *testing.T) {
 				testFailed = true
 			}
 		}
-		time.Sleep(time.Second * 5)
+		clock.Advance(time.Second * 5)
 		if !testFailed {
 			//buy second time
 			responseCode, err = buyACoffeeForUser("e6b92500-6cbf-4848-ac51-1ff07c76d88e", coffeedb.Espresso, srv.URL)
@@ -151,7 +217,6 @@ func TestBuyEspressoCoffee(t *testing.T) {
 
 	serverTeardown(srv)
 
-	clearDb()
 	if testFailed {
 		t.Fail()
 	}
@@ -168,9 +233,9 @@ func TestBuyEspressoCoffeeWithReset(t *testing.T) {
 	basicCoffeeConfig := CoffeeQuotaPerMembership{Membership: coffeedb.Basic, Quota: basicQuotas}
 	customConfig[coffeedb.Basic] = basicCoffeeConfig
 
-	InitWithConfig(customConfig)
-	InitDb("Tmp")
-	srv := serverSetup()
+	t.Parallel()
+	clock := newTestClock()
+	srv := serverSetup(shopSetup(t, Config{Memberships: customConfig}, coffeedb.DefaultOptions(), clock.Now))
 
 	testFailed := false
 	resCode, err := registerUserWithMembership("e6b92500-6cbf-4848-ac51-1ff07c76d88e", coffeedb.Basic, srv.URL)
@@ -190,7 +255,7 @@ func TestBuyEspressoCoffeeWithReset(t *testing.T) {
 				testFailed = true
 			}
 		}
-		time.Sleep(time.Second * 5)
+		clock.Advance(time.Second * 5)
 		if !testFailed {
 			//buy second time
 			responseCode, err = buyACoffeeForUser("e6b92500-6cbf-4848-ac51-1ff07c76d88e", coffeedb.Espresso, srv.URL)
@@ -207,8 +272,1245 @@ func TestBuyEspressoCoffeeWithReset(t *testing.T) {
 
 	serverTeardown(srv)
 
-	clearDb()
 	if testFailed {
 		t.Fail()
 	}
 }
+
+func TestBuyEspressoCoffeeWithGrant(t *testing.T) {
+	customConfig := make(map[coffeedb.MembershipType]CoffeeQuotaPerMembership)
+
+	basicEspressoCoffeeQuota := CoffeeQuota{Type: coffeedb.Espresso, Amount: 1, TimeFrame: int64(time.Hour)}
+	basicCoffeeConfig := CoffeeQuotaPerMembership{Membership: coffeedb.Basic, Quota: []CoffeeQuota{basicEspressoCoffeeQuota}}
+	customConfig[coffeedb.Basic] = basicCoffeeConfig
+
+	t.Parallel()
+	srv := serverSetup(shopSetup(t, Config{Memberships: customConfig}, coffeedb.DefaultOptions(), nil))
+	defer serverTeardown(srv)
+
+	userId := "0b7e3a52-2d4c-4a53-9d7e-5b1f6f0c3a11"
+	if resCode, err := registerUserWithMembership(userId, coffeedb.Basic, srv.URL); err != nil || resCode != http.StatusOK {
+		t.Fatalf("register user: code %d, err %v", resCode, err)
+	}
+	grant := GrantRequest{UserId: userId, Coffee: coffeedb.Espresso, Amount: 2, ExpiresIn: 3600, Reason: "compensation", GrantedBy: "manager"}
+	if resCode, err := grantCoffeeToUser(grant, srv.URL); err != nil || resCode != http.StatusOK {
+		t.Fatalf("grant coffee: code %d, err %v", resCode, err)
+	}
+
+	//one from the regular quota and two granted
+	expected := []int{http.StatusOK, http.StatusOK, http.StatusOK, http.StatusTooManyRequests}
+	for i, expectedCode := range expected {
+		responseCode, err := buyACoffeeForUser(userId, coffeedb.Espresso, srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if responseCode != expectedCode {
+			t.Errorf("buy %d: expected %d got %d", i+1, expectedCode, responseCode)
+		}
+	}
+}
+
+func TestBuyEspressoCoffeeFromGroupPool(t *testing.T) {
+	customConfig := make(map[coffeedb.MembershipType]CoffeeQuotaPerMembership)
+
+	coffeeLoverEspressoCoffeeQuota := CoffeeQuota{Type: coffeedb.Espresso, Amount: 3, TimeFrame: int64(time.Hour)}
+	coffeeLoverCoffeeConfig := CoffeeQuotaPerMembership{Membership: coffeedb.CoffeeLover, Quota: []CoffeeQuota{coffeeLoverEspressoCoffeeQuota}}
+	customConfig[coffeedb.CoffeeLover] = coffeeLoverCoffeeConfig
+
+	t.Parallel()
+	srv := serverSetup(shopSetup(t, Config{Memberships: customConfig}, coffeedb.DefaultOptions(), nil))
+	defer serverTeardown(srv)
+
+	groupId := "office-1"
+	if resCode, err := postJson(srv.URL+"/admin/groups", GroupRegister{GroupId: groupId, Membership: coffeedb.CoffeeLover}); err != nil || resCode != http.StatusOK {
+		t.Fatalf("create group: code %d, err %v", resCode, err)
+	}
+	usersId := generateUserId(2)
+	subLimits := []map[coffeedb.CoffeeType]uint32{{coffeedb.Espresso: 1}, nil}
+	for i, u := range usersId {
+		if resCode, err := registerUserWithMembership(u, coffeedb.Basic, srv.URL); err != nil || resCode != http.StatusOK {
+			t.Fatalf("register user: code %d, err %v", resCode, err)
+		}
+		member := GroupMemberInfo{GroupId: groupId, UserId: u, SubLimits: subLimits[i]}
+		if resCode, err := postJson(srv.URL+"/admin/groups/members", member); err != nil || resCode != http.StatusOK {
+			t.Fatalf("add group member: code %d, err %v", resCode, err)
+		}
+	}
+
+	//first member is limited by own sub-limit, second one by the pool of 3
+	purchases := []struct {
+		userId       string
+		expectedCode int
+	}{
+		{usersId[0], http.StatusOK},
+		{usersId[0], http.StatusTooManyRequests},
+		{usersId[1], http.StatusOK},
+		{usersId[1], http.StatusOK},
+		{usersId[1], http.StatusTooManyRequests},
+	}
+	for i, p := range purchases {
+		responseCode, err := buyACoffeeForUser(p.userId, coffeedb.Espresso, srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if responseCode != p.expectedCode {
+			t.Errorf("buy %d: expected %d got %d", i+1, p.expectedCode, responseCode)
+		}
+	}
+}
+
+func TestOrganizationCapAndStatement(t *testing.T) {
+	t.Parallel()
+	srv := serverSetup(shopSetup(t, DefaultConfig(), coffeedb.DefaultOptions(), nil))
+	defer serverTeardown(srv)
+
+	orgId := "acme"
+	org := OrganizationRegister{OrgId: orgId, Name: "Acme", MonthlyDrinkCap: 2}
+	if resCode, err := postJson(srv.URL+"/admin/organizations", org); err != nil || resCode != http.StatusOK {
+		t.Fatalf("create organization: code %d, err %v", resCode, err)
+	}
+	usersId := generateUserId(2)
+	for _, u := range usersId {
+		if resCode, err := registerUserWithMembership(u, coffeedb.CoffeeLover, srv.URL); err != nil || resCode != http.StatusOK {
+			t.Fatalf("register user: code %d, err %v", resCode, err)
+		}
+		member := OrganizationMemberInfo{OrgId: orgId, UserId: u}
+		if resCode, err := postJson(srv.URL+"/admin/organizations/members", member); err != nil || resCode != http.StatusOK {
+			t.Fatalf("add organization member: code %d, err %v", resCode, err)
+		}
+	}
+
+	purchases := []struct {
+		userId       string
+		coffee       coffeedb.CoffeeType
+		expectedCode int
+	}{
+		{usersId[0], coffeedb.Espresso, http.StatusOK},
+		{usersId[1], coffeedb.Cappuccino, http.StatusOK},
+		{usersId[0], coffeedb.Espresso, http.StatusForbidden},
+	}
+	for i, p := range purchases {
+		responseCode, err := buyACoffeeForUser(p.userId, p.coffee, srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if responseCode != p.expectedCode {
+			t.Errorf("buy %d: expected %d got %d", i+1, p.expectedCode, responseCode)
+		}
+	}
+
+	resCode, body, err := getBody(srv.URL + "/admin/organizations/statement?org_id=" + orgId)
+	if err != nil || resCode != http.StatusOK {
+		t.Fatalf("get statement: code %d, err %v", resCode, err)
+	}
+	var statement OrganizationStatement
+	if err := json.Unmarshal(body, &statement); err != nil {
+		t.Fatal(err)
+	}
+	prices := DefaultConfig().Prices
+	expectedAmount := prices[coffeedb.Espresso] + prices[coffeedb.Cappuccino]
+	if statement.TotalDrinks != 2 || statement.TotalAmount != expectedAmount || len(statement.Lines) != 2 {
+		t.Errorf("unexpected statement %+v", statement)
+	}
+
+	resCode, body, err = getBody(srv.URL + "/admin/organizations/statement?format=csv&org_id=" + orgId)
+	if err != nil || resCode != http.StatusOK {
+		t.Fatalf("get csv statement: code %d, err %v", resCode, err)
+	}
+	if !bytes.Contains(body, []byte("total,,2,,"+strconv.FormatUint(expectedAmount, 10))) {
+		t.Errorf("unexpected csv statement %s", body)
+	}
+}
+
+func TestGuestPass(t *testing.T) {
+	t.Parallel()
+	srv := serverSetup(shopSetup(t, DefaultConfig(), coffeedb.DefaultOptions(), nil))
+	defer serverTeardown(srv)
+
+	userId := "5f0f4c1e-8f59-4a4e-9a57-6f2d1f0b2c7d"
+	if resCode, err := registerUserWithMembership(userId, coffeedb.EspressoManiac, srv.URL); err != nil || resCode != http.StatusOK {
+		t.Fatalf("register user: code %d, err %v", resCode, err)
+	}
+
+	//default Espresso Maniac allowance is 2 guest passes
+	var codes []string
+	for i, expectedCode := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		postBody, _ := json.Marshal(GuestPassRequest{UserId: userId, Coffee: coffeedb.Cappuccino})
+		resp, err := http.Post(srv.URL+"/guestPass", "application/json", bytes.NewBuffer(postBody))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != expectedCode {
+			t.Errorf("issue guest pass %d: expected %d got %d", i+1, expectedCode, resp.StatusCode)
+		}
+		var pass GuestPassInfo
+		if resp.StatusCode == http.StatusOK && json.NewDecoder(resp.Body).Decode(&pass) == nil {
+			codes = append(codes, pass.GuestCode)
+		}
+		resp.Body.Close()
+	}
+	if len(codes) != 2 {
+		t.Fatalf("expected 2 guest codes got %d", len(codes))
+	}
+
+	//guest is not registered, a pass can be used once
+	for i, expectedCode := range []int{http.StatusOK, http.StatusGone} {
+		resCode, err := postJson(srv.URL+"/buyCoffee", CoffeeBuyInfo{GuestCode: codes[0]})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resCode != expectedCode {
+			t.Errorf("redeem guest pass %d: expected %d got %d", i+1, expectedCode, resCode)
+		}
+	}
+	if resCode, _ := postJson(srv.URL+"/buyCoffee", CoffeeBuyInfo{GuestCode: codes[1], Coffee: coffeedb.Espresso}); resCode != http.StatusBadRequest {
+		t.Errorf("redeem guest pass for another coffee: expected %d got %d", http.StatusBadRequest, resCode)
+	}
+
+	//passes of a banned member are refused until the member is reinstated
+	for _, c := range []struct {
+		status       coffeedb.UserStatusType
+		expectedCode int
+	}{{coffeedb.Banned, http.StatusForbidden}, {coffeedb.Active, http.StatusOK}} {
+		change := UserStatusChange{UserId: userId, Status: c.status, Reason: "abuse", ChangedBy: "manager"}
+		if resCode, err := postJson(srv.URL+"/admin/userStatus", change); err != nil || resCode != http.StatusOK {
+			t.Fatalf("change status: code %d, err %v", resCode, err)
+		}
+		if resCode, _ := postJson(srv.URL+"/buyCoffee", CoffeeBuyInfo{GuestCode: codes[1]}); resCode != c.expectedCode {
+			t.Errorf("redeem guest pass of a member with status %s: expected %d got %d", c.status.String(), c.expectedCode, resCode)
+		}
+	}
+}
+
+func TestConcurrentGuestPasses(t *testing.T) {
+	t.Parallel()
+	shop := shopSetup(t, DefaultConfig(), coffeedb.DefaultOptions(), nil)
+	userId := generateUserId(1)[0]
+	if err := shop.Register(userId, coffeedb.EspressoManiac); err != nil {
+		t.Fatal(err)
+	}
+	allowance := shop.config.Memberships[coffeedb.EspressoManiac].GuestPasses
+
+	var issued int32
+	var wg sync.WaitGroup
+	for i := 0; i < 40; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			pass, limit, err := shop.issueGuestPass(&GuestPassRequest{UserId: userId, Coffee: coffeedb.Espresso})
+			if err != nil {
+				t.Error(err)
+			}
+			if pass != nil && limit == nil {
+				atomic.AddInt32(&issued, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if issued != int32(allowance) {
+		t.Errorf("expected %d guest passes got %d", allowance, issued)
+	}
+
+	//a pass which could not be created is given back to the allowance
+	other := generateUserId(1)[0]
+	if err := shop.Register(other, coffeedb.EspressoManiac); err != nil {
+		t.Fatal(err)
+	}
+	limit, undo, err := shop.chargeGuestPass(other, shop.db.GetUserData(other), coffeedb.Espresso, shop.now().Unix())
+	if err != nil || limit != nil || undo == nil {
+		t.Fatalf("charge guest pass: limit %v, undo %v, err %v", limit, undo != nil, err)
+	}
+	if err := undo(); err != nil {
+		t.Fatal(err)
+	}
+	if guestQuota := shop.db.GetUserData(other).GuestQuota; guestQuota == nil || guestQuota.AmountBought != 0 {
+		t.Errorf("expected the guest pass given back got %v", guestQuota)
+	}
+}
+
+func TestSuspendAndReinstateUser(t *testing.T) {
+	t.Parallel()
+	srv := serverSetup(shopSetup(t, DefaultConfig(), coffeedb.DefaultOptions(), nil))
+	defer serverTeardown(srv)
+
+	userId := "c3a1d9e2-6b1f-4d8e-b0a4-2f7c9e5d1a33"
+	if resCode, err := registerUserWithMembership(userId, coffeedb.CoffeeLover, srv.URL); err != nil || resCode != http.StatusOK {
+		t.Fatalf("register user: code %d, err %v", resCode, err)
+	}
+
+	until := time.Now().Add(time.Hour).Unix()
+	changes := []struct {
+		change       UserStatusChange
+		expectedCode int
+	}{
+		{UserStatusChange{UserId: userId, Status: coffeedb.Suspended, Until: until, Reason: "abuse", ChangedBy: "manager"}, http.StatusForbidden},
+		{UserStatusChange{UserId: userId, Status: coffeedb.Active, ChangedBy: "manager"}, http.StatusOK},
+		{UserStatusChange{UserId: userId, Status: coffeedb.Banned, Reason: "abuse", ChangedBy: "manager"}, http.StatusForbidden},
+	}
+	for i, c := range changes {
+		if resCode, err := postJson(srv.URL+"/admin/userStatus", c.change); err != nil || resCode != http.StatusOK {
+			t.Fatalf("change status %d: code %d, err %v", i+1, resCode, err)
+		}
+		responseCode, err := buyACoffeeForUser(userId, coffeedb.Espresso, srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if responseCode != c.expectedCode {
+			t.Errorf("buy with status %s: expected %d got %d", c.change.Status.String(), c.expectedCode, responseCode)
+		}
+	}
+
+	suspend := UserStatusChange{UserId: userId, Status: coffeedb.Suspended, Until: until - 7200, Reason: "abuse", ChangedBy: "manager"}
+	if resCode, _ := postJson(srv.URL+"/admin/userStatus", suspend); resCode != http.StatusBadRequest {
+		t.Errorf("suspend until past time: expected %d got %d", http.StatusBadRequest, resCode)
+	}
+}
+
+func TestExportAndDeleteUser(t *testing.T) {
+	t.Parallel()
+	srv := serverSetup(shopSetup(t, DefaultConfig(), coffeedb.DefaultOptions(), nil))
+	defer serverTeardown(srv)
+
+	userId := "9d2b7f4a-3c6e-4e1b-8a5d-0f9e8d7c6b5a"
+	orgId := "acme"
+	if resCode, err := registerUserWithMembership(userId, coffeedb.CoffeeLover, srv.URL); err != nil || resCode != http.StatusOK {
+		t.Fatalf("register user: code %d, err %v", resCode, err)
+	}
+	if resCode, err := postJson(srv.URL+"/admin/organizations", OrganizationRegister{OrgId: orgId, Name: "Acme"}); err != nil || resCode != http.StatusOK {
+		t.Fatalf("create organization: code %d, err %v", resCode, err)
+	}
+	if resCode, err := postJson(srv.URL+"/admin/organizations/members", OrganizationMemberInfo{OrgId: orgId, UserId: userId}); err != nil || resCode != http.StatusOK {
+		t.Fatalf("add organization member: code %d, err %v", resCode, err)
+	}
+	if resCode, err := buyACoffeeForUser(userId, coffeedb.Americano, srv.URL); err != nil || resCode != http.StatusOK {
+		t.Fatalf("buy coffee: code %d, err %v", resCode, err)
+	}
+
+	resCode, body, err := getBody(srv.URL + "/users/" + userId + "/export")
+	if err != nil || resCode != http.StatusOK {
+		t.Fatalf("export user: code %d, err %v", resCode, err)
+	}
+	var export UserExport
+	if err := json.Unmarshal(body, &export); err != nil {
+		t.Fatal(err)
+	}
+	if export.Membership.Organization != orgId || len(export.Purchases) != 1 {
+		t.Errorf("unexpected export %s", body)
+	}
+
+	request, _ := http.NewRequest("DELETE", srv.URL+"/users/"+userId, nil)
+	resp, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("delete user: code %d", resp.StatusCode)
+	}
+	if resCode, _, _ := getBody(srv.URL + "/users/" + userId + "/export"); resCode != http.StatusNotFound {
+		t.Errorf("export deleted user: expected %d got %d", http.StatusNotFound, resCode)
+	}
+
+	//purchase stays on the organization statement under an anonymous id
+	resCode, body, err = getBody(srv.URL + "/admin/organizations/statement?org_id=" + orgId)
+	if err != nil || resCode != http.StatusOK {
+		t.Fatalf("get statement: code %d, err %v", resCode, err)
+	}
+	var statement OrganizationStatement
+	if err := json.Unmarshal(body, &statement); err != nil {
+		t.Fatal(err)
+	}
+	if statement.TotalDrinks != 1 || len(statement.Lines) != 1 || statement.Lines[0].UserId == userId {
+		t.Errorf("unexpected statement %s", body)
+	}
+}
+
+func TestListUsers(t *testing.T) {
+	t.Parallel()
+	srv := serverSetup(shopSetup(t, DefaultConfig(), coffeedb.DefaultOptions(), nil))
+	defer serverTeardown(srv)
+
+	memberships := []coffeedb.MembershipType{coffeedb.Basic, coffeedb.CoffeeLover, coffeedb.Basic, coffeedb.EspressoManiac, coffeedb.Basic}
+	for i, m := range memberships {
+		if resCode, err := registerUserWithMembership("shop-"+strconv.Itoa(i), m, srv.URL); err != nil || resCode != http.StatusOK {
+			t.Fatalf("register user: code %d, err %v", resCode, err)
+		}
+	}
+	if resCode, err := registerUserWithMembership("other-0", coffeedb.Basic, srv.URL); err != nil || resCode != http.StatusOK {
+		t.Fatalf("register user: code %d, err %v", resCode, err)
+	}
+	ban := UserStatusChange{UserId: "shop-2", Status: coffeedb.Banned, Reason: "abuse", ChangedBy: "manager"}
+	if resCode, err := postJson(srv.URL+"/admin/userStatus", ban); err != nil || resCode != http.StatusOK {
+		t.Fatalf("ban user: code %d, err %v", resCode, err)
+	}
+
+	listAll := func(query string) []string {
+		var usersId []string
+		cursor := ""
+		for {
+			resCode, body, err := getBody(srv.URL + "/admin/users?limit=2&" + query + "&cursor=" + cursor)
+			if err != nil || resCode != http.StatusOK {
+				t.Fatalf("list users: code %d, err %v", resCode, err)
+			}
+			var page UsersPage
+			if err := json.Unmarshal(body, &page); err != nil {
+				t.Fatal(err)
+			}
+			for _, u := range page.Users {
+				usersId = append(usersId, u.UserId)
+			}
+			if len(page.NextCursor) == 0 {
+				return usersId
+			}
+			cursor = page.NextCursor
+		}
+	}
+
+	queries := []struct {
+		query    string
+		expected string
+	}{
+		{"prefix=shop-", "[shop-0 shop-1 shop-2 shop-3 shop-4]"},
+		{"prefix=shop-&membership=1", "[shop-0 shop-2 shop-4]"},
+		{"membership=1&status=0", "[other-0 shop-0 shop-4]"},
+		{"status=2", "[shop-2]"},
+		{"status=banned", "[shop-2]"},
+	}
+	for _, q := range queries {
+		if got := fmt.Sprint(listAll(q.query)); got != q.expected {
+			t.Errorf("list users %s: expected %s got %s", q.query, q.expected, got)
+		}
+	}
+}
+
+func TestBoundedUsersCache(t *testing.T) {
+	t.Parallel()
+	srv := serverSetup(shopSetup(t, DefaultConfig(), coffeedb.Options{CacheSize: 2}, nil))
+	defer serverTeardown(srv)
+
+	usersId := generateUserId(5)
+	for _, u := range usersId {
+		if resCode, err := registerUserWithMembership(u, coffeedb.Basic, srv.URL); err != nil || resCode != http.StatusOK {
+			t.Fatalf("register user: code %d, err %v", resCode, err)
+		}
+	}
+	//evicted users are loaded from storage with their quota state
+	for _, expectedCode := range []int{http.StatusOK, http.StatusTooManyRequests} {
+		for _, u := range usersId {
+			responseCode, err := buyACoffeeForUser(u, coffeedb.Espresso, srv.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if responseCode != expectedCode {
+				t.Errorf("buy for %s: expected %d got %d", u, expectedCode, responseCode)
+			}
+		}
+	}
+
+	resCode, body, err := getBody(srv.URL + "/admin/stats")
+	if err != nil || resCode != http.StatusOK {
+		t.Fatalf("get stats: code %d, err %v", resCode, err)
+	}
+	var stats DbStats
+	if err := json.Unmarshal(body, &stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.Users != len(usersId) || stats.Cache.Size > 2 || stats.Cache.Evictions == 0 || stats.Cache.Misses == 0 {
+		t.Errorf("unexpected stats %s", body)
+	}
+}
+
+func TestBulkImportExport(t *testing.T) {
+	t.Parallel()
+	srv := serverSetup(shopSetup(t, DefaultConfig(), coffeedb.DefaultOptions(), nil))
+	defer serverTeardown(srv)
+
+	if resCode, err := registerUserWithMembership("existing", coffeedb.Basic, srv.URL); err != nil || resCode != http.StatusOK {
+		t.Fatalf("register user: code %d, err %v", resCode, err)
+	}
+	csvBody := "user_id,membership,quota_state\n" +
+		"office-1,1,\n" +
+		"office-2,3,\"{\"\"2\"\":{\"\"amount_bought\"\":2,\"\"bought_time\"\":" + strconv.FormatInt(time.Now().Unix(), 10) + "}}\"\n" +
+		"office-3,7,\n" +
+		"existing,1,\n" +
+		",2,\n"
+	resp, err := http.Post(srv.URL+"/admin/users/import", "text/csv", strings.NewReader(csvBody))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report coffeedb.ImportReport
+	err = json.NewDecoder(resp.Body).Decode(&report)
+	resp.Body.Close()
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("import: code %d, err %v", resp.StatusCode, err)
+	}
+	if report.Total != 5 || report.Imported != 2 || len(report.Failed) != 3 || report.Failed[0].Row != 3 || report.Failed[1].UserId != "existing" {
+		t.Errorf("unexpected import report %+v", report)
+	}
+	//imported quota state is used by buyCoffee
+	if responseCode, err := buyACoffeeForUser("office-2", coffeedb.Americano, srv.URL); err != nil || responseCode != http.StatusTooManyRequests {
+		t.Errorf("buy after import: code %d, err %v", responseCode, err)
+	}
+
+	resCode, body, err := getBody(srv.URL + "/admin/users/export?format=jsonl")
+	if err != nil || resCode != http.StatusOK {
+		t.Fatalf("export: code %d, err %v", resCode, err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(body)), "\n"); len(lines) != 3 || !strings.Contains(lines[2], `"user_id":"office-2"`) {
+		t.Errorf("unexpected export %s", body)
+	}
+}
+
+func TestTwoShopsInOneProcess(t *testing.T) {
+	t.Parallel()
+	clock := newTestClock()
+	shops := []*Shop{
+		shopSetup(t, DefaultConfig(), coffeedb.DefaultOptions(), clock.Now),
+		shopSetup(t, DefaultConfig(), coffeedb.DefaultOptions(), clock.Now),
+	}
+	userId := "2a9c4e6f-1b3d-4f5a-8c7e-9d0b1a2c3e4f"
+	for _, shop := range shops {
+		if err := shop.Register(userId, coffeedb.Basic); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := shops[0].Register(userId, coffeedb.Basic); !errors.Is(err, coffeedb.ErrUserExists) {
+		t.Errorf("register twice: expected %v got %v", coffeedb.ErrUserExists, err)
+	}
+	if _, err := shops[0].Buy(CoffeeBuyInfo{UserId: "unknown", Coffee: coffeedb.Espresso}); !errors.Is(err, coffeedb.ErrUserNotFound) {
+		t.Errorf("buy for unknown user: expected %v got %v", coffeedb.ErrUserNotFound, err)
+	}
+
+	for i, expectLimit := range []bool{false, true} {
+		limit, err := shops[0].Buy(CoffeeBuyInfo{UserId: userId, Coffee: coffeedb.Espresso})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (limit != nil) != expectLimit {
+			t.Errorf("buy %d: unexpected limit %+v", i+1, limit)
+		}
+	}
+
+	clock.Advance(time.Hour)
+	for i, expectedRemaining := range []uint32{0, 1} {
+		status, err := shops[i].Status(userId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		espresso := status.Coffees[0]
+		if espresso.Coffee != coffeedb.Espresso || espresso.Limit != 1 || espresso.Remaining != expectedRemaining {
+			t.Errorf("shop %d: unexpected status %+v", i+1, status)
+		}
+		if expectedRemaining == 0 && espresso.ResetAt != clock.Now().Add(23*time.Hour).Unix() {
+			t.Errorf("shop %d: unexpected reset time %d", i+1, espresso.ResetAt)
+		}
+	}
+}
+
+func TestV1StatusCodes(t *testing.T) {
+	t.Parallel()
+	srv := serverSetup(shopSetup(t, DefaultConfig(), coffeedb.DefaultOptions(), nil))
+	defer serverTeardown(srv)
+
+	userId := "7e1d3c5b-9a2f-4b6d-8e0c-1f3a5b7d9c2e"
+	requests := []struct {
+		method       string
+		path         string
+		body         string
+		expectedCode int
+	}{
+		{"POST", "/v1/users", `{"user_id":"` + userId + `","membership":1}`, http.StatusCreated},
+		{"POST", "/v1/users", `{"user_id":"` + userId + `","membership":1}`, http.StatusConflict},
+		{"POST", "/v1/users", `{"user_id":"other","membership":7}`, http.StatusUnprocessableEntity},
+		{"POST", "/v1/users", `{"user_id":`, http.StatusBadRequest},
+		{"GET", "/v1/users", "", http.StatusMethodNotAllowed},
+		{"GET", "/v1/users/" + userId, "", http.StatusOK},
+		{"GET", "/v1/users/unknown", "", http.StatusNotFound},
+		{"PUT", "/v1/users/" + userId, "", http.StatusMethodNotAllowed},
+		{"POST", "/v1/users/" + userId + "/purchases", `{"coffee_type":4}`, http.StatusUnprocessableEntity},
+		{"POST", "/v1/users/" + userId + "/purchases", `{"coffee_type":1}`, http.StatusCreated},
+		{"POST", "/v1/users/" + userId + "/purchases", `{"coffee_type":1}`, http.StatusTooManyRequests},
+		{"POST", "/v1/users/unknown/purchases", `{"coffee_type":1}`, http.StatusNotFound},
+		{"GET", "/v1/users/" + userId + "/purchases", "", http.StatusOK},
+		{"DELETE", "/v1/users/" + userId + "/purchases", "", http.StatusMethodNotAllowed},
+		{"GET", "/v1/users/" + userId + "/export", "", http.StatusOK},
+		{"GET", "/v1/users/" + userId + "/unknown", "", http.StatusNotFound},
+		{"DELETE", "/v1/users/" + userId, "", http.StatusNoContent},
+		{"GET", "/v1/users/" + userId, "", http.StatusNotFound},
+	}
+	for _, r := range requests {
+		request, _ := http.NewRequest(r.method, srv.URL+r.path, strings.NewReader(r.body))
+		if len(r.body) != 0 {
+			request.Header.Set("Content-Type", "application/json")
+		}
+		resp, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != r.expectedCode {
+			t.Errorf("%s %s %s: expected %d got %d", r.method, r.path, r.body, r.expectedCode, resp.StatusCode)
+		}
+		if resp.StatusCode == http.StatusMethodNotAllowed && len(resp.Header.Get("Allow")) == 0 {
+			t.Errorf("%s %s: no Allow header", r.method, r.path)
+		}
+	}
+
+	//legacy routes are aliases of the same operations
+	if resCode, err := registerUserWithMembership(userId, coffeedb.Basic, srv.URL); err != nil || resCode != http.StatusOK {
+		t.Fatalf("register user: code %d, err %v", resCode, err)
+	}
+	resCode, body, err := getBody(srv.URL + "/v1/users/" + userId)
+	if err != nil || resCode != http.StatusOK {
+		t.Fatalf("get user: code %d, err %v", resCode, err)
+	}
+	var status UserQuotaStatus
+	if err := json.Unmarshal(body, &status); err != nil || status.Membership != coffeedb.Basic || len(status.Coffees) != 3 {
+		t.Errorf("unexpected status %s %v", body, err)
+	}
+}
+
+func TestJsonResponses(t *testing.T) {
+	t.Parallel()
+	srv := serverSetup(shopSetup(t, DefaultConfig(), coffeedb.DefaultOptions(), nil))
+	defer serverTeardown(srv)
+
+	userId := "3b9f2d7a-6c1e-4a8b-9d5f-0e2c4a6b8d1f"
+	post := func(path string, body string) (int, []byte) {
+		resp, err := http.Post(srv.URL+path, "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if contentType := resp.Header.Get("Content-Type"); contentType != "application/json" {
+			t.Errorf("POST %s: content type %q", path, contentType)
+		}
+		data, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, data
+	}
+
+	resCode, body := post("/registerUser", `{"user_id":"`+userId+`","membership":1}`)
+	var status UserQuotaStatus
+	if err := json.Unmarshal(body, &status); resCode != http.StatusOK || err != nil || status.UserId != userId {
+		t.Fatalf("register user: code %d body %s", resCode, body)
+	}
+	resCode, body = post("/buyCoffee", `{"user_id":"`+userId+`","coffee_type":1}`)
+	if err := json.Unmarshal(body, &status); resCode != http.StatusOK || err != nil || status.Coffees[0].Remaining != 0 {
+		t.Fatalf("buy coffee: code %d body %s", resCode, body)
+	}
+
+	errorsExpected := []struct {
+		path string
+		body string
+		code string
+	}{
+		{"/buyCoffee", `{"user_id":"` + userId + `","coffee_type":1}`, CodeQuotaExceeded},
+		{"/buyCoffee", `{"user_id":"` + userId + `","coffee_type":9}`, CodeInvalidCoffeeType},
+		{"/buyCoffee", `{"user_id":"unknown","coffee_type":1}`, CodeUserNotFound},
+		{"/buyCoffee", `{"user_id":`, CodeInvalidRequest},
+		{"/registerUser", `{"user_id":"` + userId + `","membership":1}`, CodeUserExists},
+		{"/admin/groups/members", `{"group_id":"unknown","user_id":"` + userId + `"}`, CodeGroupNotFound},
+		{"/admin/groups", `{"group_id":"../` + userId + `","membership":1}`, CodeInvalidRequest},
+		{"/admin/grants", `{"user_id":"groups/team","coffee_type":1,"amount":1,"expires_in":60,"reason":"r","granted_by":"admin"}`, CodeInvalidRequest},
+		{"/unknown", ``, CodeNotFound},
+	}
+	for _, e := range errorsExpected {
+		_, body := post(e.path, e.body)
+		var response ErrorResponse
+		if err := json.Unmarshal(body, &response); err != nil || response.Code != e.code || len(response.Message) == 0 {
+			t.Errorf("%s %s: expected code %s got %s", e.path, e.body, e.code, body)
+		}
+	}
+
+	_, body = post("/buyCoffee", `{"user_id":"`+userId+`","coffee_type":1}`)
+	var limit struct {
+		Details CoffeeLimitExceed `json:"details"`
+	}
+	if err := json.Unmarshal(body, &limit); err != nil || limit.Details.Type != coffeedb.Espresso || limit.Details.AmountBought != 1 || limit.Details.AvailableIn <= 0 {
+		t.Errorf("limit exceeded details missing %s", body)
+	}
+}
+
+func TestCoffeeAndMembershipNames(t *testing.T) {
+	t.Parallel()
+	srv := serverSetup(shopSetup(t, DefaultConfig(), coffeedb.DefaultOptions(), nil))
+	defer serverTeardown(srv)
+
+	userId := "8d2f6b0e-4a7c-4e1d-b3f9-5c8a0e2d4f6b"
+	post := func(path string, body string) (int, []byte) {
+		resp, err := http.Post(srv.URL+path, "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		data, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, data
+	}
+
+	resCode, body := post("/v1/users", `{"user_id":"`+userId+`","membership":"coffee lover"}`)
+	if resCode != http.StatusCreated || !strings.Contains(string(body), `"membership":"Coffee Lover"`) {
+		t.Fatalf("register with membership name: code %d body %s", resCode, body)
+	}
+	resCode, body = post("/v1/users/"+userId+"/purchases", `{"coffee_type":"Espresso"}`)
+	if resCode != http.StatusCreated || !strings.Contains(string(body), `{"coffee_type":"Espresso","limit":5,"remaining":4,`) {
+		t.Errorf("buy with coffee name: code %d body %s", resCode, body)
+	}
+	resCode, body = post("/buyCoffee", `{"user_id":"`+userId+`","coffee_type":2}`)
+	if resCode != http.StatusOK {
+		t.Errorf("buy with coffee number: code %d body %s", resCode, body)
+	}
+
+	for _, body := range []string{`{"coffee_type":4}`, `{"coffee_type":"Latte"}`} {
+		resCode, data := post("/v1/users/"+userId+"/purchases", body)
+		var response ErrorResponse
+		if err := json.Unmarshal(data, &response); err != nil || resCode != http.StatusUnprocessableEntity ||
+			response.Code != CodeInvalidCoffeeType || !strings.Contains(response.Message, "Espresso, Americano, Cappuccino") {
+			t.Errorf("%s: expected 422 %s got %d %s", body, CodeInvalidCoffeeType, resCode, data)
+		}
+	}
+
+	resCode, body, err := getBody(srv.URL + "/admin/users?membership=Coffee%20Lover")
+	if err != nil || resCode != http.StatusOK || !strings.Contains(string(body), userId) {
+		t.Errorf("list users by membership name: code %d body %s", resCode, body)
+	}
+}
+
+func TestRateLimitHeaders(t *testing.T) {
+	t.Parallel()
+	clock := newTestClock()
+	srv := serverSetup(shopSetup(t, DefaultConfig(), coffeedb.DefaultOptions(), clock.Now))
+	defer serverTeardown(srv)
+
+	userId := "9c4e1a7b-2d5f-4e8a-b6c3-7f1d0a9e5b2c"
+	if resCode, err := registerUserWithMembership(userId, coffeedb.Basic, srv.URL); err != nil || resCode != http.StatusOK {
+		t.Fatalf("register user: code %d, err %v", resCode, err)
+	}
+	buy := func(path string, body string) *http.Response {
+		resp, err := http.Post(srv.URL+path, "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+	expectHeaders := func(resp *http.Response, expected map[string]string) {
+		for name, value := range expected {
+			if resp.Header.Get(name) != value {
+				t.Errorf("%s: expected %q got %q", name, value, resp.Header.Get(name))
+			}
+		}
+	}
+
+	resp := buy("/buyCoffee", `{"user_id":"`+userId+`","coffee_type":2}`)
+	expectHeaders(resp, map[string]string{"RateLimit-Limit": "2", "RateLimit-Remaining": "1", "RateLimit-Reset": "86400", "Retry-After": ""})
+
+	clock.Advance(time.Hour)
+	resp = buy("/v1/users/"+userId+"/purchases", `{"coffee_type":2}`)
+	expectHeaders(resp, map[string]string{"RateLimit-Limit": "2", "RateLimit-Remaining": "0", "RateLimit-Reset": "82800"})
+
+	clock.Advance(time.Hour)
+	purchases := map[string]string{
+		"/buyCoffee":                         `{"user_id":"` + userId + `","coffee_type":2}`,
+		"/v1/users/" + userId + "/purchases": `{"coffee_type":2}`,
+	}
+	for path, body := range purchases {
+		resp = buy(path, body)
+		if resp.StatusCode != http.StatusTooManyRequests {
+			t.Fatalf("%s: expected 429 got %d", path, resp.StatusCode)
+		}
+		expectHeaders(resp, map[string]string{"Retry-After": "79200", "RateLimit-Remaining": "0", "RateLimit-Reset": "79200"})
+	}
+}
+
+func TestIdempotencyKey(t *testing.T) {
+	t.Parallel()
+	clock := newTestClock()
+	shop := shopSetup(t, DefaultConfig(), coffeedb.DefaultOptions(), clock.Now)
+	srv := serverSetup(shop)
+	defer serverTeardown(srv)
+
+	userId := "e1a3c5e7-9b1d-4f3a-a5c7-e9b1d3f5a7c9"
+	if resCode, err := registerUserWithMembership(userId, coffeedb.Basic, srv.URL); err != nil || resCode != http.StatusOK {
+		t.Fatalf("register user: code %d, err %v", resCode, err)
+	}
+	buy := func(path string, key string, body string) (*http.Response, []byte) {
+		request, _ := http.NewRequest("POST", srv.URL+path, strings.NewReader(body))
+		request.Header.Set("Idempotency-Key", key)
+		request.Header.Set("Content-Type", "application/json")
+		resp, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		data, _ := ioutil.ReadAll(resp.Body)
+		return resp, data
+	}
+	purchases := func() int {
+		history, err := shop.History(userId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(history)
+	}
+	espresso := `{"user_id":"` + userId + `","coffee_type":1}`
+
+	first, firstBody := buy("/buyCoffee", "key-1", espresso)
+	retry, retryBody := buy("/buyCoffee", "key-1", espresso)
+	if first.StatusCode != http.StatusOK || retry.StatusCode != http.StatusOK || string(firstBody) != string(retryBody) {
+		t.Fatalf("retry not replayed: %d %s, %d %s", first.StatusCode, firstBody, retry.StatusCode, retryBody)
+	}
+	if retry.Header.Get("Idempotent-Replayed") != "true" || len(first.Header.Get("Idempotent-Replayed")) != 0 {
+		t.Error("Idempotent-Replayed header not set on the replay only")
+	}
+	if purchases() != 1 {
+		t.Errorf("retry bought again, %d purchases", purchases())
+	}
+
+	resp, body := buy("/buyCoffee", "key-1", `{"user_id":"`+userId+`","coffee_type":2}`)
+	var errResp ErrorResponse
+	json.Unmarshal(body, &errResp)
+	if resp.StatusCode != http.StatusUnprocessableEntity || errResp.Code != CodeIdempotencyKeyReused {
+		t.Errorf("key reused with another payload: %d %s", resp.StatusCode, body)
+	}
+	//keys are scoped to the endpoint and the user
+	if resp, body := buy("/v1/users/"+userId+"/purchases", "key-1", `{"coffee_type":1}`); resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("key of another endpoint replayed or rejected: %d %s", resp.StatusCode, body)
+	}
+	if resp, body := buy("/buyCoffee", "key-1", `{"user_id":"other-user","coffee_type":1}`); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("key of another user replayed or rejected: %d %s", resp.StatusCode, body)
+	}
+
+	for i := 0; i < 2; i++ {
+		if resp, _ := buy("/buyCoffee", "key-2", espresso); resp.StatusCode != http.StatusTooManyRequests {
+			t.Errorf("quota exceeded not replayed: %d", resp.StatusCode)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if resp, body := buy("/v1/users/"+userId+"/purchases", "key-3", `{"coffee_type":3}`); resp.StatusCode != http.StatusCreated {
+				t.Errorf("concurrent retry: %d %s", resp.StatusCode, body)
+			}
+		}()
+	}
+	wg.Wait()
+	if purchases() != 2 {
+		t.Errorf("concurrent retries bought more than once, %d purchases", purchases())
+	}
+
+	clock.Advance(DefaultIdempotencyTTL + time.Second)
+	if resp, _ := buy("/buyCoffee", "key-1", espresso); resp.StatusCode != http.StatusOK || len(resp.Header.Get("Idempotent-Replayed")) != 0 || purchases() != 3 {
+		t.Errorf("expired key replayed: %d, %d purchases", resp.StatusCode, purchases())
+	}
+}
+
+func TestIdempotencyStoreLimits(t *testing.T) {
+	t.Parallel()
+	shop := shopSetup(t, DefaultConfig(), coffeedb.DefaultOptions(), nil)
+	shop.idempotency = newIdempotencyStore(2)
+	calls := 0
+	call := func(key string, handler func(http.ResponseWriter, *http.Request)) int {
+		request := httptest.NewRequest("POST", "/buyCoffee", strings.NewReader(`{"user_id":"user-1"}`))
+		request.Header.Set("Idempotency-Key", key)
+		recorder := httptest.NewRecorder()
+		shop.idempotent(recorder, request, handler)
+		return recorder.Code
+	}
+	ok := func(writer http.ResponseWriter, request *http.Request) {
+		calls++
+		writer.WriteHeader(http.StatusOK)
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("handler panic not passed on")
+			}
+		}()
+		call("key-1", func(http.ResponseWriter, *http.Request) { panic("handler failed") })
+	}()
+	done := make(chan int)
+	go func() { done <- call("key-1", ok) }()
+	select {
+	case code := <-done:
+		if code != http.StatusOK || calls != 1 {
+			t.Errorf("retry after a panic: %d, %d calls", code, calls)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("retry after a panic waits for the failed request")
+	}
+
+	call("key-2", ok)
+	call("key-3", ok)
+	if len(shop.idempotency.entries) != 2 {
+		t.Errorf("expected 2 stored keys got %d", len(shop.idempotency.entries))
+	}
+	if call("key-1", ok); calls != 4 {
+		t.Errorf("oldest key not dropped, %d calls", calls)
+	}
+}
+
+func TestRequestBodyLimits(t *testing.T) {
+	t.Parallel()
+	config := DefaultConfig()
+	config.MaxBodyBytes = 256
+	config.MaxImportBytes = 512
+	shop := shopSetup(t, config, coffeedb.DefaultOptions(), nil)
+	srv := serverSetup(shop)
+	defer serverTeardown(srv)
+
+	userId := "5e7a9c1b-3d5f-4b7d-9f1a-3c5e7a9c1b3d"
+	longId := strings.Repeat("x", 300)
+	importBody := "user_id,membership,quota_state\n"
+	for i := 0; int64(len(importBody)) <= config.MaxImportBytes; i++ {
+		importBody += fmt.Sprintf("imported-%d,1,\n", i)
+	}
+	requests := []struct {
+		path         string
+		contentType  string
+		key          string
+		body         string
+		expectedCode int
+		code         string
+	}{
+		{"/registerUser", "application/json", "", `{"user_id":"` + longId + `","membership":1}`, http.StatusRequestEntityTooLarge, CodeRequestTooLarge},
+		{"/v1/users", "application/json", "", `{"user_id":"` + longId + `","membership":1}`, http.StatusRequestEntityTooLarge, CodeRequestTooLarge},
+		{"/buyCoffee", "application/json", "key-1", `{"user_id":"` + longId + `","coffee_type":1}`, http.StatusRequestEntityTooLarge, CodeRequestTooLarge},
+		{"/registerUser", "text/plain", "", `{"user_id":"` + userId + `","membership":1}`, http.StatusUnsupportedMediaType, CodeUnsupportedMediaType},
+		{"/v1/users", "", "", `{"user_id":"` + userId + `","membership":1}`, http.StatusUnsupportedMediaType, CodeUnsupportedMediaType},
+		{"/registerUser", "application/json", "", `{"user_id":"` + userId + `","membership":1,"admin":true}`, http.StatusBadRequest, CodeInvalidRequest},
+		{"/v1/users", "application/json", "", `{"user_id":"` + userId + `","membership":1}{}`, http.StatusBadRequest, CodeInvalidRequest},
+		{"/admin/users/import", "text/csv", "", importBody, http.StatusRequestEntityTooLarge, CodeRequestTooLarge},
+		{"/registerUser", "application/json; charset=utf-8", "", `{"user_id":"` + userId + `","membership":1}`, http.StatusOK, ""},
+	}
+	for _, r := range requests {
+		request, _ := http.NewRequest("POST", srv.URL+r.path, strings.NewReader(r.body))
+		if len(r.contentType) != 0 {
+			request.Header.Set("Content-Type", r.contentType)
+		}
+		if len(r.key) != 0 {
+			request.Header.Set("Idempotency-Key", r.key)
+		}
+		resp, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		var response ErrorResponse
+		json.Unmarshal(data, &response)
+		if resp.StatusCode != r.expectedCode || response.Code != r.code {
+			t.Errorf("%s %s: expected %d %s got %d %s", r.path, r.contentType, r.expectedCode, r.code, resp.StatusCode, data)
+		}
+	}
+	if shop.db.UsersCount() != 1 {
+		t.Errorf("rejected requests registered users, %d users", shop.db.UsersCount())
+	}
+}
+
+func TestSlowClients(t *testing.T) {
+	t.Parallel()
+	shop := shopSetup(t, DefaultConfig(), coffeedb.DefaultOptions(), nil)
+	server := NewHttpServer("", shop.Handler(), HttpTimeouts{Read: 200 * time.Millisecond, Write: time.Second, Idle: time.Second})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(listener)
+	defer server.Close()
+
+	userId := "9c1e3a5d-7f9b-4d1f-8a3c-5e7a9c1e3a5d"
+	body := `{"user_id":"` + userId + `","membership":1}`
+	partial := map[string]string{
+		"headers": "POST /registerUser HTTP/1.1\r\nHost: shop\r\nContent-Type: application/json\r\n",
+		"body":    "POST /registerUser HTTP/1.1\r\nHost: shop\r\nContent-Type: application/json\r\nContent-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n" + body[:10],
+	}
+	for name, data := range partial {
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Write([]byte(data))
+		//the client stops sending, the server has to give up after the read timeout
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		response, err := ioutil.ReadAll(conn)
+		conn.Close()
+		if err != nil {
+			t.Errorf("slow %s: connection kept open %v", name, err)
+		}
+		if bytes.HasPrefix(response, []byte("HTTP/1.1 200")) {
+			t.Errorf("slow %s: request served %s", name, response)
+		}
+	}
+	if _, err := shop.Status(userId); !errors.Is(err, coffeedb.ErrUserNotFound) {
+		t.Errorf("user of a slow client registered %v", err)
+	}
+}
+
+func TestConcurrentBuys(t *testing.T) {
+	t.Parallel()
+	shop := shopSetup(t, DefaultConfig(), coffeedb.DefaultOptions(), nil)
+	userId := "2f4a6c8e-0b2d-4f6a-8c0e-2b4d6f8a0c2e"
+	if err := shop.Register(userId, coffeedb.CoffeeLover); err != nil {
+		t.Fatal(err)
+	}
+	quota, err := shop.coffeeQuotaConfig(coffeedb.Espresso, coffeedb.CoffeeLover)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var bought int32
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			limit, err := shop.Buy(CoffeeBuyInfo{UserId: userId, Coffee: coffeedb.Espresso})
+			if err != nil {
+				t.Error(err)
+			}
+			if err == nil && limit == nil {
+				atomic.AddInt32(&bought, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if bought != int32(quota.Amount) {
+		t.Errorf("expected %d purchases got %d", quota.Amount, bought)
+	}
+	if history, err := shop.History(userId); err != nil || len(history) != int(quota.Amount) {
+		t.Errorf("expected %d purchases in the ledger got %d %v", quota.Amount, len(history), err)
+	}
+}
+
+func TestConcurrentRegister(t *testing.T) {
+	t.Parallel()
+	shop := shopSetup(t, DefaultConfig(), coffeedb.DefaultOptions(), nil)
+	srv := serverSetup(shop)
+	defer serverTeardown(srv)
+
+	userId := "7d9f1b3d-5f7b-4d9f-b1d3-f5b7d9f1b3d5"
+	var created, conflicts int32
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := http.Post(srv.URL+"/v1/users", "application/json", strings.NewReader(`{"user_id":"`+userId+`","membership":1}`))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			resp.Body.Close()
+			switch resp.StatusCode {
+			case http.StatusCreated:
+				atomic.AddInt32(&created, 1)
+			case http.StatusConflict:
+				atomic.AddInt32(&conflicts, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if created != 1 || conflicts != 19 {
+		t.Errorf("expected 1 created and 19 conflicts got %d and %d", created, conflicts)
+	}
+	//the check is done under the user's lock in the store, not by a lookup before
+	if err := shop.db.RegisterUser(userId, coffeedb.Basic); !errors.Is(err, coffeedb.ErrUserExists) {
+		t.Errorf("expected ErrUserExists got %v", err)
+	}
+
+	for _, invalidId := range []string{"../user", `dir\user`} {
+		if err := shop.Register(invalidId, coffeedb.Basic); !errors.Is(err, ErrInvalidUserId) {
+			t.Errorf("%s: expected ErrInvalidUserId got %v", invalidId, err)
+		}
+	}
+}
+
+func TestConcurrentGroupBuys(t *testing.T) {
+	t.Parallel()
+	shop := shopSetup(t, DefaultConfig(), coffeedb.DefaultOptions(), nil)
+	if err := shop.db.CreateGroup("team", coffeedb.CoffeeLover); err != nil {
+		t.Fatal(err)
+	}
+	members := generateUserId(10)
+	for _, userId := range members {
+		if err := shop.Register(userId, coffeedb.Basic); err != nil {
+			t.Fatal(err)
+		}
+		if err := shop.db.AddGroupMember("team", userId, coffeedb.GroupMember{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	quota, err := shop.coffeeQuotaConfig(coffeedb.Espresso, coffeedb.CoffeeLover)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var bought int32
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(userId string) {
+			defer wg.Done()
+			limit, err := shop.Buy(CoffeeBuyInfo{UserId: userId, Coffee: coffeedb.Espresso})
+			if err != nil {
+				t.Error(err)
+			}
+			if err == nil && limit == nil {
+				atomic.AddInt32(&bought, 1)
+			}
+		}(members[i%len(members)])
+	}
+	wg.Wait()
+	if bought != int32(quota.Amount) {
+		t.Errorf("expected %d purchases from the pool got %d", quota.Amount, bought)
+	}
+	if pool := shop.db.GetGroupData("team").QuotaState[coffeedb.Espresso]; pool.AmountBought != quota.Amount {
+		t.Errorf("expected pool state %d got %d", quota.Amount, pool.AmountBought)
+	}
+}
+
+func TestSnapshotDuringGroupBuys(t *testing.T) {
+	t.Parallel()
+	shop := shopSetup(t, DefaultConfig(), coffeedb.DefaultOptions(), nil)
+	if err := shop.db.CreateGroup("team", coffeedb.CoffeeLover); err != nil {
+		t.Fatal(err)
+	}
+	members := generateUserId(10)
+	for _, userId := range members {
+		if err := shop.Register(userId, coffeedb.Basic); err != nil {
+			t.Fatal(err)
+		}
+		if err := shop.db.AddGroupMember("team", userId, coffeedb.GroupMember{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		var wg sync.WaitGroup
+		for i := 0; i < 100; i++ {
+			wg.Add(2)
+			go func(userId string) {
+				defer wg.Done()
+				if _, err := shop.Buy(CoffeeBuyInfo{UserId: userId, Coffee: coffeedb.Espresso}); err != nil {
+					t.Error(err)
+				}
+			}(members[i%len(members)])
+			go func() {
+				defer wg.Done()
+				if _, err := shop.db.Snapshot(ioutil.Discard); err != nil {
+					t.Error(err)
+				}
+			}()
+		}
+		wg.Wait()
+	}()
+	select {
+	case <-done:
+	case <-time.After(30 * time.Second):
+		t.Fatal("snapshots and group purchases deadlocked")
+	}
+}
+
+func TestConcurrentOrganizationCaps(t *testing.T) {
+	t.Parallel()
+	shop := shopSetup(t, DefaultConfig(), coffeedb.DefaultOptions(), nil)
+	if err := shop.db.CreateOrganization("acme", &coffeedb.Organization{Name: "Acme", MonthlyDrinkCap: 3}); err != nil {
+		t.Fatal(err)
+	}
+	members := generateUserId(20)
+	for _, userId := range members {
+		if err := shop.Register(userId, coffeedb.Basic); err != nil {
+			t.Fatal(err)
+		}
+		if err := shop.db.AddOrganizationMember("acme", userId); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var bought int32
+	var wg sync.WaitGroup
+	for _, userId := range members {
+		wg.Add(1)
+		go func(userId string) {
+			defer wg.Done()
+			limit, err := shop.Buy(CoffeeBuyInfo{UserId: userId, Coffee: coffeedb.Espresso})
+			if err != nil && !errors.Is(err, ErrOrganizationCapReached) {
+				t.Error(err)
+			}
+			if err == nil && limit == nil {
+				atomic.AddInt32(&bought, 1)
+			}
+		}(userId)
+	}
+	wg.Wait()
+	usage := shop.db.GetOrganizationData("acme").Usage
+	if bought != 3 || usage.Drinks != 3 {
+		t.Errorf("expected 3 purchases and 3 drinks charged got %d and %d", bought, usage.Drinks)
+	}
+	//members refused by the cap keep their coffee
+	refused := 0
+	for _, userId := range members {
+		if shop.db.GetUserData(userId).QuotaState[coffeedb.Espresso].AmountBought == 0 {
+			refused++
+		}
+	}
+	if refused != len(members)-3 {
+		t.Errorf("expected %d members with untouched quota got %d", len(members)-3, refused)
+	}
+}
+
+func TestPurchaseUndoneOnFailure(t *testing.T) {
+	t.Parallel()
+	dataDir := t.TempDir()
+	store, err := coffeedb.Init(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	shop := NewShop(store, DefaultConfig(), nil)
+	userId := "6a8c0e2b-4d6f-4a8c-8e0b-2d4f6a8c0e2b"
+	if err := shop.Register(userId, coffeedb.Basic); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.CreateOrganization("acme", &coffeedb.Organization{Name: "Acme"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.AddOrganizationMember("acme", userId); err != nil {
+		t.Fatal(err)
+	}
+
+	//the ledger can not be written, the organization charge and the quota are given back
+	ledger := filepath.Join(dataDir, "purchases.jsonl")
+	if err := os.Mkdir(ledger, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := shop.Buy(CoffeeBuyInfo{UserId: userId, Coffee: coffeedb.Espresso}); err == nil {
+		t.Fatal("purchase succeeded without a ledger")
+	}
+	if drinks := store.GetOrganizationData("acme").Usage.Drinks; drinks != 0 {
+		t.Errorf("organization charged %d drinks for a failed purchase", drinks)
+	}
+	if qs := store.GetUserData(userId).QuotaState[coffeedb.Espresso]; qs.AmountBought != 0 {
+		t.Errorf("quota charged %d coffees for a failed purchase", qs.AmountBought)
+	}
+
+	//an exhausted quota does not charge the organization
+	if err := os.Remove(ledger); err != nil {
+		t.Fatal(err)
+	}
+	for i, expectLimit := range []bool{false, true} {
+		limit, err := shop.Buy(CoffeeBuyInfo{UserId: userId, Coffee: coffeedb.Espresso})
+		if err != nil || (limit != nil) != expectLimit {
+			t.Errorf("purchase %d: limit %v err %v", i+1, limit, err)
+		}
+	}
+	if drinks := store.GetOrganizationData("acme").Usage.Drinks; drinks != 1 {
+		t.Errorf("expected 1 drink charged got %d", drinks)
+	}
+}
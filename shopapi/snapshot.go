@@ -0,0 +1,30 @@
+package shopapi
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// apiSnapshot GET returns a consistent tar.gz archive of all records,
+// the archive is built in memory first so a failure still returns an error code
+func (shop *Shop) apiSnapshot(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != "GET" {
+		writeError(writer, http.StatusNotFound, CodeMethodNotAllowed, "Method is not supported.")
+		return
+	}
+	var archive bytes.Buffer
+	manifest, err := shop.db.Snapshot(&archive)
+	if err != nil {
+		writeErrorStatus(writer, http.StatusInternalServerError, err)
+		return
+	}
+	fileName := fmt.Sprintf("coffeeshop-%s.tar.gz", time.Unix(manifest.CreatedAt, 0).UTC().Format("20060102T150405Z"))
+	writer.Header().Set("Content-Type", "application/gzip")
+	writer.Header().Set("Content-Disposition", "attachment; filename=\""+fileName+"\"")
+	writer.Header().Set("Content-Length", strconv.Itoa(archive.Len()))
+	writer.WriteHeader(http.StatusOK)
+	archive.WriteTo(writer)
+}
@@ -0,0 +1,189 @@
+package shopapi
+
+import (
+	"CoffeeShop/coffeedb"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// UserExport all data held about a user
+type UserExport struct {
+	UserId      string                        `json:"user_id"`
+	ExportedAt  int64                         `json:"exported_at"`
+	Membership  coffeedb.UserCoffeeMembership `json:"membership"`
+	Group       *coffeedb.GroupMember         `json:"group_member,omitempty"`
+	Purchases   []coffeedb.Purchase           `json:"purchases"`
+	GuestPasses map[string]coffeedb.GuestPass `json:"guest_passes"`
+}
+
+// UserDeleted body of DELETE /users/{id}
+type UserDeleted struct {
+	UserId  string `json:"user_id"`
+	Deleted bool   `json:"deleted"`
+}
+
+// exportUser collects user's record, group membership, purchases and issued guest passes
+func (shop *Shop) exportUser(userId string) (*UserExport, error) {
+	qs := shop.db.GetUserData(userId)
+	if qs == nil {
+		return nil, coffeedb.ErrUserNotFound
+	}
+	export := UserExport{UserId: userId, ExportedAt: shop.now().Unix(), Membership: *qs}
+	if len(qs.Group) != 0 {
+		if group := shop.db.GetGroupData(qs.Group); group != nil {
+			if member, ok := group.Members[userId]; ok {
+				export.Group = &member
+			}
+		}
+	}
+	var err error
+	export.Purchases, err = shop.db.Purchases(func(p *coffeedb.Purchase) bool {
+		return p.UserId == userId
+	})
+	if err != nil {
+		return nil, err
+	}
+	export.GuestPasses, err = shop.db.GuestPassesIssuedBy(userId)
+	if err != nil {
+		return nil, err
+	}
+	return &export, nil
+}
+
+// deleteUser removes the user, purchases and guest passes are anonymized
+func (shop *Shop) deleteUser(userId string) error {
+	return shop.db.DeleteUser(userId, "deleted-"+uuid.New().String())
+}
+
+// apiUsers handles
+// DELETE /users/{id} - removes the user
+// GET /users/{id}/export - returns all data held about the user
+func (shop *Shop) apiUsers(writer http.ResponseWriter, request *http.Request) {
+	path := strings.TrimPrefix(request.URL.Path, "/users/")
+	userId, action := path, ""
+	if i := strings.LastIndex(path, "/"); i >= 0 {
+		userId, action = path[:i], path[i+1:]
+	}
+	if len(userId) == 0 {
+		writeError(writer, http.StatusBadRequest, CodeInvalidRequest, "empty user id")
+		return
+	}
+
+	switch {
+	case action == "" && request.Method == "DELETE":
+		err := shop.deleteUser(userId)
+		if errors.Is(err, coffeedb.ErrUserNotFound) {
+			writeError(writer, http.StatusNotFound, CodeUserNotFound, "user not found "+userId)
+			return
+		}
+		if err != nil {
+			writeErrorStatus(writer, http.StatusInternalServerError, err)
+			return
+		}
+		writeJson(writer, http.StatusOK, UserDeleted{UserId: userId, Deleted: true})
+	case action == "export" && request.Method == "GET":
+		export, err := shop.exportUser(userId)
+		if errors.Is(err, coffeedb.ErrUserNotFound) {
+			writeError(writer, http.StatusNotFound, CodeUserNotFound, "user not found "+userId)
+			return
+		}
+		if err != nil {
+			writeErrorStatus(writer, http.StatusInternalServerError, err)
+			return
+		}
+		writer.Header().Set("Content-Disposition", "attachment; filename=user-export.json")
+		writeJson(writer, http.StatusOK, export)
+	default:
+		writeError(writer, http.StatusNotFound, CodeMethodNotAllowed, "Method is not supported.")
+	}
+}
+
+const defaultUsersPageSize = 50
+const maxUsersPageSize = 500
+
+// UsersPage one page of the users listing, NextCursor is empty on the last page
+type UsersPage struct {
+	Users      []coffeedb.UserSummary `json:"users"`
+	NextCursor string                 `json:"next_cursor,omitempty"`
+}
+
+// parseUserQuery reads listing filters from the query string:
+// cursor, limit, membership, status and prefix
+func (shop *Shop) parseUserQuery(values url.Values) (*coffeedb.UserQuery, error) {
+	q := coffeedb.UserQuery{Limit: defaultUsersPageSize, Prefix: values.Get("prefix"), Now: shop.now().Unix()}
+	if cursor := values.Get("cursor"); len(cursor) != 0 {
+		after, err := base64.RawURLEncoding.DecodeString(cursor)
+		if err != nil {
+			return nil, errors.New("invalid cursor")
+		}
+		q.After = string(after)
+	}
+	if limit := values.Get("limit"); len(limit) != 0 {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 || n > maxUsersPageSize {
+			return nil, fmt.Errorf("limit must be between 1 and %d", maxUsersPageSize)
+		}
+		q.Limit = n
+	}
+	if membership := values.Get("membership"); len(membership) != 0 {
+		if err := q.Membership.UnmarshalText([]byte(membership)); err != nil {
+			return nil, err
+		}
+		if err := q.Membership.IsValid(); err != nil {
+			return nil, err
+		}
+	}
+	if status := values.Get("status"); len(status) != 0 {
+		var userStatus coffeedb.UserStatusType
+		if err := userStatus.UnmarshalText([]byte(status)); err != nil {
+			return nil, err
+		}
+		q.Status = &userStatus
+	}
+	return &q, nil
+}
+
+func (shop *Shop) listUsers(q *coffeedb.UserQuery) *UsersPage {
+	users, more := shop.db.ListUsers(q)
+	page := UsersPage{Users: users}
+	if more {
+		page.NextCursor = base64.RawURLEncoding.EncodeToString([]byte(users[len(users)-1].UserId))
+	}
+	return &page
+}
+
+// apiListUsers GET returns a page of users sorted by user id
+func (shop *Shop) apiListUsers(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != "GET" {
+		writeError(writer, http.StatusNotFound, CodeMethodNotAllowed, "Method is not supported.")
+		return
+	}
+	q, err := shop.parseUserQuery(request.URL.Query())
+	if err != nil {
+		writeErrorStatus(writer, http.StatusBadRequest, err)
+		return
+	}
+	writeJson(writer, http.StatusOK, shop.listUsers(q))
+}
+
+// DbStats number of users on storage and users cache counters
+type DbStats struct {
+	Users int                 `json:"users"`
+	Cache coffeedb.CacheStats `json:"cache"`
+}
+
+// apiStats GET returns db stats
+func (shop *Shop) apiStats(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != "GET" {
+		writeError(writer, http.StatusNotFound, CodeMethodNotAllowed, "Method is not supported.")
+		return
+	}
+	writeJson(writer, http.StatusOK, DbStats{Users: shop.db.UsersCount(), Cache: shop.db.CacheStats()})
+}
@@ -0,0 +1,119 @@
+package shopapi
+
+import (
+	"CoffeeShop/coffeedb"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// UserSuspendedError returned when a suspended or banned user tries to buy coffee
+// Until is the end of the suspension (unix seconds), zero for banned users
+type UserSuspendedError struct {
+	UserId string                  `json:"user_id"`
+	Status coffeedb.UserStatusType `json:"status"`
+	Until  int64                   `json:"until,omitempty"`
+	Reason string                  `json:"reason,omitempty"`
+}
+
+func (e *UserSuspendedError) Error() string {
+	if e.Status == coffeedb.Suspended {
+		return fmt.Sprintf("user %s is suspended until %s: %s",
+			e.UserId, time.Unix(e.Until, 0).UTC().Format(time.RFC3339), e.Reason)
+	}
+	return fmt.Sprintf("user %s is banned: %s", e.UserId, e.Reason)
+}
+
+// UserStatusChange admin request to suspend, ban or reinstate a user
+// Until is the end of a suspension (unix seconds), ignored for other statuses
+type UserStatusChange struct {
+	UserId    string                  `json:"user_id"`
+	Status    coffeedb.UserStatusType `json:"status"`
+	Until     int64                   `json:"until"`
+	Reason    string                  `json:"reason"`
+	ChangedBy string                  `json:"changed_by"`
+}
+
+// checkUserStatus returns *UserSuspendedError if the user may not buy coffee now
+func checkUserStatus(userId string, qs *coffeedb.UserCoffeeMembership, timeNowSeconds int64) error {
+	if qs.Status.IsActive(timeNowSeconds) {
+		return nil
+	}
+	return &UserSuspendedError{UserId: userId, Status: qs.Status.Status, Until: qs.Status.Until, Reason: qs.Status.Reason}
+}
+
+func (shop *Shop) changeUserStatus(sc *UserStatusChange) error {
+	if len(sc.UserId) == 0 {
+		return errors.New("empty user id")
+	}
+	if err := sc.Status.IsValid(); err != nil {
+		return err
+	}
+	if len(sc.ChangedBy) == 0 {
+		return errors.New("empty changed by")
+	}
+	timeNowSeconds := shop.now().Unix()
+	status := coffeedb.UserStatus{Status: sc.Status, Reason: sc.Reason, ChangedBy: sc.ChangedBy, ChangedAt: timeNowSeconds}
+	switch sc.Status {
+	case coffeedb.Suspended:
+		if sc.Until <= timeNowSeconds {
+			return errors.New("suspension end time must be in the future")
+		}
+		status.Until = sc.Until
+		fallthrough
+	case coffeedb.Banned:
+		if len(sc.Reason) == 0 {
+			return errors.New("empty reason")
+		}
+	}
+	err := shop.db.SetUserStatus(sc.UserId, &status)
+	if errors.Is(err, coffeedb.ErrUserNotFound) {
+		return fmt.Errorf("%w %s", coffeedb.ErrUserNotFound, sc.UserId)
+	}
+	return err
+}
+
+// writeUserSuspended writes a 403 response if err is *UserSuspendedError
+// returns false if err is another error
+func writeUserSuspended(writer http.ResponseWriter, err error) bool {
+	var suspended *UserSuspendedError
+	if !errors.As(err, &suspended) {
+		return false
+	}
+	writeErrorStatus(writer, http.StatusForbidden, err)
+	return true
+}
+
+// apiUserStatus POST changes user's status, GET returns status of user_id
+func (shop *Shop) apiUserStatus(writer http.ResponseWriter, request *http.Request) {
+	switch request.Method {
+	case "POST":
+		var sc UserStatusChange
+		err := shop.readJson(request, &sc)
+		if err != nil {
+			writeBodyError(writer, err)
+			return
+		}
+		err = shop.changeUserStatus(&sc)
+		if err != nil {
+			writeErrorStatus(writer, http.StatusBadRequest, err)
+			return
+		}
+		writeJson(writer, http.StatusOK, shop.db.GetUserData(sc.UserId).Status)
+	case "GET":
+		userId := request.URL.Query().Get("user_id")
+		if len(userId) == 0 {
+			writeError(writer, http.StatusBadRequest, CodeInvalidRequest, "empty user id")
+			return
+		}
+		qs := shop.db.GetUserData(userId)
+		if qs == nil {
+			writeError(writer, http.StatusBadRequest, CodeUserNotFound, "user not found "+userId)
+			return
+		}
+		writeJson(writer, http.StatusOK, qs.Status)
+	default:
+		writeError(writer, http.StatusNotFound, CodeMethodNotAllowed, "Method is not supported.")
+	}
+}
@@ -0,0 +1,133 @@
+package shopapi
+
+import (
+	"CoffeeShop/coffeedb"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// PurchaseRequest body of POST /v1/users/{id}/purchases
+type PurchaseRequest struct {
+	Coffee coffeedb.CoffeeType `json:"coffee_type"`
+}
+
+// methodNotAllowed writes a 405 response with the methods the resource supports
+func methodNotAllowed(writer http.ResponseWriter, allowed ...string) {
+	writer.Header().Set("Allow", strings.Join(allowed, ", "))
+	writeError(writer, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method is not supported.")
+}
+
+func userLocation(userId string) string {
+	return "/v1/users/" + url.PathEscape(userId)
+}
+
+// writeUserStatus writes the current quota status of the user
+func (shop *Shop) writeUserStatus(writer http.ResponseWriter, status int, userId string) {
+	quotaStatus, err := shop.Status(userId)
+	if err != nil {
+		writeShopError(writer, err)
+		return
+	}
+	writeJson(writer, status, quotaStatus)
+}
+
+// apiV1Users POST /v1/users registers a user and returns the user's quota status
+func (shop *Shop) apiV1Users(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != "POST" {
+		methodNotAllowed(writer, "POST")
+		return
+	}
+	var userReg UserRegister
+	if err := shop.readJson(request, &userReg); err != nil {
+		writeShopError(writer, err)
+		return
+	}
+	if err := shop.Register(userReg.UserId, userReg.Membership); err != nil {
+		writeShopError(writer, err)
+		return
+	}
+	writer.Header().Set("Location", userLocation(userReg.UserId))
+	shop.writeUserStatus(writer, http.StatusCreated, userReg.UserId)
+}
+
+// apiV1User handles
+// GET /v1/users/{id} - user's quota status
+// DELETE /v1/users/{id} - removes the user
+// GET /v1/users/{id}/export - all data held about the user
+// GET /v1/users/{id}/purchases - user's purchases
+// POST /v1/users/{id}/purchases - buys a coffee
+func (shop *Shop) apiV1User(writer http.ResponseWriter, request *http.Request) {
+	path := strings.TrimPrefix(request.URL.Path, "/v1/users/")
+	userId, resource := path, ""
+	if i := strings.Index(path, "/"); i >= 0 {
+		userId, resource = path[:i], path[i+1:]
+	}
+	if len(userId) == 0 {
+		writeError(writer, http.StatusNotFound, CodeNotFound, ErrEmptyUserId.Error())
+		return
+	}
+
+	switch resource {
+	case "":
+		switch request.Method {
+		case "GET":
+			shop.writeUserStatus(writer, http.StatusOK, userId)
+		case "DELETE":
+			if err := shop.deleteUser(userId); err != nil {
+				writeShopError(writer, err)
+				return
+			}
+			writer.WriteHeader(http.StatusNoContent)
+		default:
+			methodNotAllowed(writer, "GET", "DELETE")
+		}
+	case "export":
+		if request.Method != "GET" {
+			methodNotAllowed(writer, "GET")
+			return
+		}
+		export, err := shop.exportUser(userId)
+		if err != nil {
+			writeShopError(writer, err)
+			return
+		}
+		writeJson(writer, http.StatusOK, export)
+	case "purchases":
+		switch request.Method {
+		case "GET":
+			purchases, err := shop.History(userId)
+			if err != nil {
+				writeShopError(writer, err)
+				return
+			}
+			writeJson(writer, http.StatusOK, purchases)
+		case "POST":
+			shop.idempotent(writer, request, func(writer http.ResponseWriter, request *http.Request) {
+				shop.apiV1BuyCoffee(writer, request, userId)
+			})
+		default:
+			methodNotAllowed(writer, "GET", "POST")
+		}
+	default:
+		apiNotFound(writer, request)
+	}
+}
+
+// apiV1BuyCoffee buys a coffee for the user and returns the updated quota status
+func (shop *Shop) apiV1BuyCoffee(writer http.ResponseWriter, request *http.Request, userId string) {
+	var purchase PurchaseRequest
+	if err := shop.readJson(request, &purchase); err != nil {
+		writeShopError(writer, err)
+		return
+	}
+	limit, err := shop.Buy(CoffeeBuyInfo{UserId: userId, Coffee: purchase.Coffee})
+	if writeUserSuspended(writer, err) {
+		return
+	}
+	if err != nil {
+		writeShopError(writer, err)
+		return
+	}
+	shop.writeBuyResult(writer, http.StatusCreated, userId, purchase.Coffee, limit)
+}
@@ -0,0 +1,1039 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.30.0
+// 	protoc        (unknown)
+// source: coffeeshop.proto
+
+package shopgrpc
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// values are the same as in the http api
+type Membership int32
+
+const (
+	Membership_MEMBERSHIP_UNSPECIFIED     Membership = 0
+	Membership_MEMBERSHIP_BASIC           Membership = 1
+	Membership_MEMBERSHIP_COFFEE_LOVER    Membership = 2
+	Membership_MEMBERSHIP_ESPRESSO_MANIAC Membership = 3
+)
+
+// Enum value maps for Membership.
+var (
+	Membership_name = map[int32]string{
+		0: "MEMBERSHIP_UNSPECIFIED",
+		1: "MEMBERSHIP_BASIC",
+		2: "MEMBERSHIP_COFFEE_LOVER",
+		3: "MEMBERSHIP_ESPRESSO_MANIAC",
+	}
+	Membership_value = map[string]int32{
+		"MEMBERSHIP_UNSPECIFIED":     0,
+		"MEMBERSHIP_BASIC":           1,
+		"MEMBERSHIP_COFFEE_LOVER":    2,
+		"MEMBERSHIP_ESPRESSO_MANIAC": 3,
+	}
+)
+
+func (x Membership) Enum() *Membership {
+	p := new(Membership)
+	*p = x
+	return p
+}
+
+func (x Membership) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (Membership) Descriptor() protoreflect.EnumDescriptor {
+	return file_coffeeshop_proto_enumTypes[0].Descriptor()
+}
+
+func (Membership) Type() protoreflect.EnumType {
+	return &file_coffeeshop_proto_enumTypes[0]
+}
+
+func (x Membership) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use Membership.Descriptor instead.
+func (Membership) EnumDescriptor() ([]byte, []int) {
+	return file_coffeeshop_proto_rawDescGZIP(), []int{0}
+}
+
+type Coffee int32
+
+const (
+	Coffee_COFFEE_UNSPECIFIED Coffee = 0
+	Coffee_COFFEE_ESPRESSO    Coffee = 1
+	Coffee_COFFEE_AMERICANO   Coffee = 2
+	Coffee_COFFEE_CAPPUCCINO  Coffee = 3
+)
+
+// Enum value maps for Coffee.
+var (
+	Coffee_name = map[int32]string{
+		0: "COFFEE_UNSPECIFIED",
+		1: "COFFEE_ESPRESSO",
+		2: "COFFEE_AMERICANO",
+		3: "COFFEE_CAPPUCCINO",
+	}
+	Coffee_value = map[string]int32{
+		"COFFEE_UNSPECIFIED": 0,
+		"COFFEE_ESPRESSO":    1,
+		"COFFEE_AMERICANO":   2,
+		"COFFEE_CAPPUCCINO":  3,
+	}
+)
+
+func (x Coffee) Enum() *Coffee {
+	p := new(Coffee)
+	*p = x
+	return p
+}
+
+func (x Coffee) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (Coffee) Descriptor() protoreflect.EnumDescriptor {
+	return file_coffeeshop_proto_enumTypes[1].Descriptor()
+}
+
+func (Coffee) Type() protoreflect.EnumType {
+	return &file_coffeeshop_proto_enumTypes[1]
+}
+
+func (x Coffee) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use Coffee.Descriptor instead.
+func (Coffee) EnumDescriptor() ([]byte, []int) {
+	return file_coffeeshop_proto_rawDescGZIP(), []int{1}
+}
+
+type UserState int32
+
+const (
+	UserState_USER_STATE_ACTIVE    UserState = 0
+	UserState_USER_STATE_SUSPENDED UserState = 1
+	UserState_USER_STATE_BANNED    UserState = 2
+)
+
+// Enum value maps for UserState.
+var (
+	UserState_name = map[int32]string{
+		0: "USER_STATE_ACTIVE",
+		1: "USER_STATE_SUSPENDED",
+		2: "USER_STATE_BANNED",
+	}
+	UserState_value = map[string]int32{
+		"USER_STATE_ACTIVE":    0,
+		"USER_STATE_SUSPENDED": 1,
+		"USER_STATE_BANNED":    2,
+	}
+)
+
+func (x UserState) Enum() *UserState {
+	p := new(UserState)
+	*p = x
+	return p
+}
+
+func (x UserState) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (UserState) Descriptor() protoreflect.EnumDescriptor {
+	return file_coffeeshop_proto_enumTypes[2].Descriptor()
+}
+
+func (UserState) Type() protoreflect.EnumType {
+	return &file_coffeeshop_proto_enumTypes[2]
+}
+
+func (x UserState) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use UserState.Descriptor instead.
+func (UserState) EnumDescriptor() ([]byte, []int) {
+	return file_coffeeshop_proto_rawDescGZIP(), []int{2}
+}
+
+type RegisterRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	UserId     string     `protobuf:"bytes,1,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`
+	Membership Membership `protobuf:"varint,2,opt,name=membership,proto3,enum=coffeeshop.v1.Membership" json:"membership,omitempty"`
+}
+
+func (x *RegisterRequest) Reset() {
+	*x = RegisterRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_coffeeshop_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *RegisterRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RegisterRequest) ProtoMessage() {}
+
+func (x *RegisterRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_coffeeshop_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RegisterRequest.ProtoReflect.Descriptor instead.
+func (*RegisterRequest) Descriptor() ([]byte, []int) {
+	return file_coffeeshop_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *RegisterRequest) GetUserId() string {
+	if x != nil {
+		return x.UserId
+	}
+	return ""
+}
+
+func (x *RegisterRequest) GetMembership() Membership {
+	if x != nil {
+		return x.Membership
+	}
+	return Membership_MEMBERSHIP_UNSPECIFIED
+}
+
+type BuyRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	UserId string `protobuf:"bytes,1,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`
+	Coffee Coffee `protobuf:"varint,2,opt,name=coffee,proto3,enum=coffeeshop.v1.Coffee" json:"coffee,omitempty"`
+}
+
+func (x *BuyRequest) Reset() {
+	*x = BuyRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_coffeeshop_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *BuyRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BuyRequest) ProtoMessage() {}
+
+func (x *BuyRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_coffeeshop_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BuyRequest.ProtoReflect.Descriptor instead.
+func (*BuyRequest) Descriptor() ([]byte, []int) {
+	return file_coffeeshop_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *BuyRequest) GetUserId() string {
+	if x != nil {
+		return x.UserId
+	}
+	return ""
+}
+
+func (x *BuyRequest) GetCoffee() Coffee {
+	if x != nil {
+		return x.Coffee
+	}
+	return Coffee_COFFEE_UNSPECIFIED
+}
+
+type StatusRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	UserId string `protobuf:"bytes,1,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`
+}
+
+func (x *StatusRequest) Reset() {
+	*x = StatusRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_coffeeshop_proto_msgTypes[2]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *StatusRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StatusRequest) ProtoMessage() {}
+
+func (x *StatusRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_coffeeshop_proto_msgTypes[2]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StatusRequest.ProtoReflect.Descriptor instead.
+func (*StatusRequest) Descriptor() ([]byte, []int) {
+	return file_coffeeshop_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *StatusRequest) GetUserId() string {
+	if x != nil {
+		return x.UserId
+	}
+	return ""
+}
+
+type HistoryRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	UserId string `protobuf:"bytes,1,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`
+}
+
+func (x *HistoryRequest) Reset() {
+	*x = HistoryRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_coffeeshop_proto_msgTypes[3]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *HistoryRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*HistoryRequest) ProtoMessage() {}
+
+func (x *HistoryRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_coffeeshop_proto_msgTypes[3]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use HistoryRequest.ProtoReflect.Descriptor instead.
+func (*HistoryRequest) Descriptor() ([]byte, []int) {
+	return file_coffeeshop_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *HistoryRequest) GetUserId() string {
+	if x != nil {
+		return x.UserId
+	}
+	return ""
+}
+
+type UserStatus struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	State UserState `protobuf:"varint,1,opt,name=state,proto3,enum=coffeeshop.v1.UserState" json:"state,omitempty"`
+	// end of a suspension, unix seconds
+	Until  int64  `protobuf:"varint,2,opt,name=until,proto3" json:"until,omitempty"`
+	Reason string `protobuf:"bytes,3,opt,name=reason,proto3" json:"reason,omitempty"`
+}
+
+func (x *UserStatus) Reset() {
+	*x = UserStatus{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_coffeeshop_proto_msgTypes[4]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *UserStatus) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UserStatus) ProtoMessage() {}
+
+func (x *UserStatus) ProtoReflect() protoreflect.Message {
+	mi := &file_coffeeshop_proto_msgTypes[4]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UserStatus.ProtoReflect.Descriptor instead.
+func (*UserStatus) Descriptor() ([]byte, []int) {
+	return file_coffeeshop_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *UserStatus) GetState() UserState {
+	if x != nil {
+		return x.State
+	}
+	return UserState_USER_STATE_ACTIVE
+}
+
+func (x *UserStatus) GetUntil() int64 {
+	if x != nil {
+		return x.Until
+	}
+	return 0
+}
+
+func (x *UserStatus) GetReason() string {
+	if x != nil {
+		return x.Reason
+	}
+	return ""
+}
+
+type CoffeeQuota struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Coffee    Coffee `protobuf:"varint,1,opt,name=coffee,proto3,enum=coffeeshop.v1.Coffee" json:"coffee,omitempty"`
+	Limit     uint32 `protobuf:"varint,2,opt,name=limit,proto3" json:"limit,omitempty"`
+	Remaining uint32 `protobuf:"varint,3,opt,name=remaining,proto3" json:"remaining,omitempty"`
+	// end of the current quota time frame, unix seconds, zero if none was bought in it
+	ResetAt int64  `protobuf:"varint,4,opt,name=reset_at,json=resetAt,proto3" json:"reset_at,omitempty"`
+	Granted uint32 `protobuf:"varint,5,opt,name=granted,proto3" json:"granted,omitempty"`
+}
+
+func (x *CoffeeQuota) Reset() {
+	*x = CoffeeQuota{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_coffeeshop_proto_msgTypes[5]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *CoffeeQuota) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CoffeeQuota) ProtoMessage() {}
+
+func (x *CoffeeQuota) ProtoReflect() protoreflect.Message {
+	mi := &file_coffeeshop_proto_msgTypes[5]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CoffeeQuota.ProtoReflect.Descriptor instead.
+func (*CoffeeQuota) Descriptor() ([]byte, []int) {
+	return file_coffeeshop_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *CoffeeQuota) GetCoffee() Coffee {
+	if x != nil {
+		return x.Coffee
+	}
+	return Coffee_COFFEE_UNSPECIFIED
+}
+
+func (x *CoffeeQuota) GetLimit() uint32 {
+	if x != nil {
+		return x.Limit
+	}
+	return 0
+}
+
+func (x *CoffeeQuota) GetRemaining() uint32 {
+	if x != nil {
+		return x.Remaining
+	}
+	return 0
+}
+
+func (x *CoffeeQuota) GetResetAt() int64 {
+	if x != nil {
+		return x.ResetAt
+	}
+	return 0
+}
+
+func (x *CoffeeQuota) GetGranted() uint32 {
+	if x != nil {
+		return x.Granted
+	}
+	return 0
+}
+
+type QuotaStatus struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	UserId     string         `protobuf:"bytes,1,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`
+	Membership Membership     `protobuf:"varint,2,opt,name=membership,proto3,enum=coffeeshop.v1.Membership" json:"membership,omitempty"`
+	Status     *UserStatus    `protobuf:"bytes,3,opt,name=status,proto3" json:"status,omitempty"`
+	Group      string         `protobuf:"bytes,4,opt,name=group,proto3" json:"group,omitempty"`
+	Coffees    []*CoffeeQuota `protobuf:"bytes,5,rep,name=coffees,proto3" json:"coffees,omitempty"`
+}
+
+func (x *QuotaStatus) Reset() {
+	*x = QuotaStatus{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_coffeeshop_proto_msgTypes[6]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *QuotaStatus) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*QuotaStatus) ProtoMessage() {}
+
+func (x *QuotaStatus) ProtoReflect() protoreflect.Message {
+	mi := &file_coffeeshop_proto_msgTypes[6]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use QuotaStatus.ProtoReflect.Descriptor instead.
+func (*QuotaStatus) Descriptor() ([]byte, []int) {
+	return file_coffeeshop_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *QuotaStatus) GetUserId() string {
+	if x != nil {
+		return x.UserId
+	}
+	return ""
+}
+
+func (x *QuotaStatus) GetMembership() Membership {
+	if x != nil {
+		return x.Membership
+	}
+	return Membership_MEMBERSHIP_UNSPECIFIED
+}
+
+func (x *QuotaStatus) GetStatus() *UserStatus {
+	if x != nil {
+		return x.Status
+	}
+	return nil
+}
+
+func (x *QuotaStatus) GetGroup() string {
+	if x != nil {
+		return x.Group
+	}
+	return ""
+}
+
+func (x *QuotaStatus) GetCoffees() []*CoffeeQuota {
+	if x != nil {
+		return x.Coffees
+	}
+	return nil
+}
+
+type Purchase struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	UserId string `protobuf:"bytes,1,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`
+	Coffee Coffee `protobuf:"varint,2,opt,name=coffee,proto3,enum=coffeeshop.v1.Coffee" json:"coffee,omitempty"`
+	// unix seconds
+	Time int64 `protobuf:"varint,3,opt,name=time,proto3" json:"time,omitempty"`
+	// cents
+	Price        uint64 `protobuf:"varint,4,opt,name=price,proto3" json:"price,omitempty"`
+	Organization string `protobuf:"bytes,5,opt,name=organization,proto3" json:"organization,omitempty"`
+	GuestCode    string `protobuf:"bytes,6,opt,name=guest_code,json=guestCode,proto3" json:"guest_code,omitempty"`
+}
+
+func (x *Purchase) Reset() {
+	*x = Purchase{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_coffeeshop_proto_msgTypes[7]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Purchase) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Purchase) ProtoMessage() {}
+
+func (x *Purchase) ProtoReflect() protoreflect.Message {
+	mi := &file_coffeeshop_proto_msgTypes[7]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Purchase.ProtoReflect.Descriptor instead.
+func (*Purchase) Descriptor() ([]byte, []int) {
+	return file_coffeeshop_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *Purchase) GetUserId() string {
+	if x != nil {
+		return x.UserId
+	}
+	return ""
+}
+
+func (x *Purchase) GetCoffee() Coffee {
+	if x != nil {
+		return x.Coffee
+	}
+	return Coffee_COFFEE_UNSPECIFIED
+}
+
+func (x *Purchase) GetTime() int64 {
+	if x != nil {
+		return x.Time
+	}
+	return 0
+}
+
+func (x *Purchase) GetPrice() uint64 {
+	if x != nil {
+		return x.Price
+	}
+	return 0
+}
+
+func (x *Purchase) GetOrganization() string {
+	if x != nil {
+		return x.Organization
+	}
+	return ""
+}
+
+func (x *Purchase) GetGuestCode() string {
+	if x != nil {
+		return x.GuestCode
+	}
+	return ""
+}
+
+type HistoryResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Purchases []*Purchase `protobuf:"bytes,1,rep,name=purchases,proto3" json:"purchases,omitempty"`
+}
+
+func (x *HistoryResponse) Reset() {
+	*x = HistoryResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_coffeeshop_proto_msgTypes[8]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *HistoryResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*HistoryResponse) ProtoMessage() {}
+
+func (x *HistoryResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_coffeeshop_proto_msgTypes[8]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use HistoryResponse.ProtoReflect.Descriptor instead.
+func (*HistoryResponse) Descriptor() ([]byte, []int) {
+	return file_coffeeshop_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *HistoryResponse) GetPurchases() []*Purchase {
+	if x != nil {
+		return x.Purchases
+	}
+	return nil
+}
+
+var File_coffeeshop_proto protoreflect.FileDescriptor
+
+var file_coffeeshop_proto_rawDesc = []byte{
+	0x0a, 0x10, 0x63, 0x6f, 0x66, 0x66, 0x65, 0x65, 0x73, 0x68, 0x6f, 0x70, 0x2e, 0x70, 0x72, 0x6f,
+	0x74, 0x6f, 0x12, 0x0d, 0x63, 0x6f, 0x66, 0x66, 0x65, 0x65, 0x73, 0x68, 0x6f, 0x70, 0x2e, 0x76,
+	0x31, 0x22, 0x65, 0x0a, 0x0f, 0x52, 0x65, 0x67, 0x69, 0x73, 0x74, 0x65, 0x72, 0x52, 0x65, 0x71,
+	0x75, 0x65, 0x73, 0x74, 0x12, 0x17, 0x0a, 0x07, 0x75, 0x73, 0x65, 0x72, 0x5f, 0x69, 0x64, 0x18,
+	0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x75, 0x73, 0x65, 0x72, 0x49, 0x64, 0x12, 0x39, 0x0a,
+	0x0a, 0x6d, 0x65, 0x6d, 0x62, 0x65, 0x72, 0x73, 0x68, 0x69, 0x70, 0x18, 0x02, 0x20, 0x01, 0x28,
+	0x0e, 0x32, 0x19, 0x2e, 0x63, 0x6f, 0x66, 0x66, 0x65, 0x65, 0x73, 0x68, 0x6f, 0x70, 0x2e, 0x76,
+	0x31, 0x2e, 0x4d, 0x65, 0x6d, 0x62, 0x65, 0x72, 0x73, 0x68, 0x69, 0x70, 0x52, 0x0a, 0x6d, 0x65,
+	0x6d, 0x62, 0x65, 0x72, 0x73, 0x68, 0x69, 0x70, 0x22, 0x54, 0x0a, 0x0a, 0x42, 0x75, 0x79, 0x52,
+	0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x17, 0x0a, 0x07, 0x75, 0x73, 0x65, 0x72, 0x5f, 0x69,
+	0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x75, 0x73, 0x65, 0x72, 0x49, 0x64, 0x12,
+	0x2d, 0x0a, 0x06, 0x63, 0x6f, 0x66, 0x66, 0x65, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0e, 0x32,
+	0x15, 0x2e, 0x63, 0x6f, 0x66, 0x66, 0x65, 0x65, 0x73, 0x68, 0x6f, 0x70, 0x2e, 0x76, 0x31, 0x2e,
+	0x43, 0x6f, 0x66, 0x66, 0x65, 0x65, 0x52, 0x06, 0x63, 0x6f, 0x66, 0x66, 0x65, 0x65, 0x22, 0x28,
+	0x0a, 0x0d, 0x53, 0x74, 0x61, 0x74, 0x75, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12,
+	0x17, 0x0a, 0x07, 0x75, 0x73, 0x65, 0x72, 0x5f, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09,
+	0x52, 0x06, 0x75, 0x73, 0x65, 0x72, 0x49, 0x64, 0x22, 0x29, 0x0a, 0x0e, 0x48, 0x69, 0x73, 0x74,
+	0x6f, 0x72, 0x79, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x17, 0x0a, 0x07, 0x75, 0x73,
+	0x65, 0x72, 0x5f, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x75, 0x73, 0x65,
+	0x72, 0x49, 0x64, 0x22, 0x6a, 0x0a, 0x0a, 0x55, 0x73, 0x65, 0x72, 0x53, 0x74, 0x61, 0x74, 0x75,
+	0x73, 0x12, 0x2e, 0x0a, 0x05, 0x73, 0x74, 0x61, 0x74, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0e,
+	0x32, 0x18, 0x2e, 0x63, 0x6f, 0x66, 0x66, 0x65, 0x65, 0x73, 0x68, 0x6f, 0x70, 0x2e, 0x76, 0x31,
+	0x2e, 0x55, 0x73, 0x65, 0x72, 0x53, 0x74, 0x61, 0x74, 0x65, 0x52, 0x05, 0x73, 0x74, 0x61, 0x74,
+	0x65, 0x12, 0x14, 0x0a, 0x05, 0x75, 0x6e, 0x74, 0x69, 0x6c, 0x18, 0x02, 0x20, 0x01, 0x28, 0x03,
+	0x52, 0x05, 0x75, 0x6e, 0x74, 0x69, 0x6c, 0x12, 0x16, 0x0a, 0x06, 0x72, 0x65, 0x61, 0x73, 0x6f,
+	0x6e, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x72, 0x65, 0x61, 0x73, 0x6f, 0x6e, 0x22,
+	0xa5, 0x01, 0x0a, 0x0b, 0x43, 0x6f, 0x66, 0x66, 0x65, 0x65, 0x51, 0x75, 0x6f, 0x74, 0x61, 0x12,
+	0x2d, 0x0a, 0x06, 0x63, 0x6f, 0x66, 0x66, 0x65, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0e, 0x32,
+	0x15, 0x2e, 0x63, 0x6f, 0x66, 0x66, 0x65, 0x65, 0x73, 0x68, 0x6f, 0x70, 0x2e, 0x76, 0x31, 0x2e,
+	0x43, 0x6f, 0x66, 0x66, 0x65, 0x65, 0x52, 0x06, 0x63, 0x6f, 0x66, 0x66, 0x65, 0x65, 0x12, 0x14,
+	0x0a, 0x05, 0x6c, 0x69, 0x6d, 0x69, 0x74, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x05, 0x6c,
+	0x69, 0x6d, 0x69, 0x74, 0x12, 0x1c, 0x0a, 0x09, 0x72, 0x65, 0x6d, 0x61, 0x69, 0x6e, 0x69, 0x6e,
+	0x67, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x09, 0x72, 0x65, 0x6d, 0x61, 0x69, 0x6e, 0x69,
+	0x6e, 0x67, 0x12, 0x19, 0x0a, 0x08, 0x72, 0x65, 0x73, 0x65, 0x74, 0x5f, 0x61, 0x74, 0x18, 0x04,
+	0x20, 0x01, 0x28, 0x03, 0x52, 0x07, 0x72, 0x65, 0x73, 0x65, 0x74, 0x41, 0x74, 0x12, 0x18, 0x0a,
+	0x07, 0x67, 0x72, 0x61, 0x6e, 0x74, 0x65, 0x64, 0x18, 0x05, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x07,
+	0x67, 0x72, 0x61, 0x6e, 0x74, 0x65, 0x64, 0x22, 0xe0, 0x01, 0x0a, 0x0b, 0x51, 0x75, 0x6f, 0x74,
+	0x61, 0x53, 0x74, 0x61, 0x74, 0x75, 0x73, 0x12, 0x17, 0x0a, 0x07, 0x75, 0x73, 0x65, 0x72, 0x5f,
+	0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x75, 0x73, 0x65, 0x72, 0x49, 0x64,
+	0x12, 0x39, 0x0a, 0x0a, 0x6d, 0x65, 0x6d, 0x62, 0x65, 0x72, 0x73, 0x68, 0x69, 0x70, 0x18, 0x02,
+	0x20, 0x01, 0x28, 0x0e, 0x32, 0x19, 0x2e, 0x63, 0x6f, 0x66, 0x66, 0x65, 0x65, 0x73, 0x68, 0x6f,
+	0x70, 0x2e, 0x76, 0x31, 0x2e, 0x4d, 0x65, 0x6d, 0x62, 0x65, 0x72, 0x73, 0x68, 0x69, 0x70, 0x52,
+	0x0a, 0x6d, 0x65, 0x6d, 0x62, 0x65, 0x72, 0x73, 0x68, 0x69, 0x70, 0x12, 0x31, 0x0a, 0x06, 0x73,
+	0x74, 0x61, 0x74, 0x75, 0x73, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x19, 0x2e, 0x63, 0x6f,
+	0x66, 0x66, 0x65, 0x65, 0x73, 0x68, 0x6f, 0x70, 0x2e, 0x76, 0x31, 0x2e, 0x55, 0x73, 0x65, 0x72,
+	0x53, 0x74, 0x61, 0x74, 0x75, 0x73, 0x52, 0x06, 0x73, 0x74, 0x61, 0x74, 0x75, 0x73, 0x12, 0x14,
+	0x0a, 0x05, 0x67, 0x72, 0x6f, 0x75, 0x70, 0x18, 0x04, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x67,
+	0x72, 0x6f, 0x75, 0x70, 0x12, 0x34, 0x0a, 0x07, 0x63, 0x6f, 0x66, 0x66, 0x65, 0x65, 0x73, 0x18,
+	0x05, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x63, 0x6f, 0x66, 0x66, 0x65, 0x65, 0x73, 0x68,
+	0x6f, 0x70, 0x2e, 0x76, 0x31, 0x2e, 0x43, 0x6f, 0x66, 0x66, 0x65, 0x65, 0x51, 0x75, 0x6f, 0x74,
+	0x61, 0x52, 0x07, 0x63, 0x6f, 0x66, 0x66, 0x65, 0x65, 0x73, 0x22, 0xbf, 0x01, 0x0a, 0x08, 0x50,
+	0x75, 0x72, 0x63, 0x68, 0x61, 0x73, 0x65, 0x12, 0x17, 0x0a, 0x07, 0x75, 0x73, 0x65, 0x72, 0x5f,
+	0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x75, 0x73, 0x65, 0x72, 0x49, 0x64,
+	0x12, 0x2d, 0x0a, 0x06, 0x63, 0x6f, 0x66, 0x66, 0x65, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0e,
+	0x32, 0x15, 0x2e, 0x63, 0x6f, 0x66, 0x66, 0x65, 0x65, 0x73, 0x68, 0x6f, 0x70, 0x2e, 0x76, 0x31,
+	0x2e, 0x43, 0x6f, 0x66, 0x66, 0x65, 0x65, 0x52, 0x06, 0x63, 0x6f, 0x66, 0x66, 0x65, 0x65, 0x12,
+	0x12, 0x0a, 0x04, 0x74, 0x69, 0x6d, 0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x03, 0x52, 0x04, 0x74,
+	0x69, 0x6d, 0x65, 0x12, 0x14, 0x0a, 0x05, 0x70, 0x72, 0x69, 0x63, 0x65, 0x18, 0x04, 0x20, 0x01,
+	0x28, 0x04, 0x52, 0x05, 0x70, 0x72, 0x69, 0x63, 0x65, 0x12, 0x22, 0x0a, 0x0c, 0x6f, 0x72, 0x67,
+	0x61, 0x6e, 0x69, 0x7a, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x18, 0x05, 0x20, 0x01, 0x28, 0x09, 0x52,
+	0x0c, 0x6f, 0x72, 0x67, 0x61, 0x6e, 0x69, 0x7a, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x12, 0x1d, 0x0a,
+	0x0a, 0x67, 0x75, 0x65, 0x73, 0x74, 0x5f, 0x63, 0x6f, 0x64, 0x65, 0x18, 0x06, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x09, 0x67, 0x75, 0x65, 0x73, 0x74, 0x43, 0x6f, 0x64, 0x65, 0x22, 0x48, 0x0a, 0x0f,
+	0x48, 0x69, 0x73, 0x74, 0x6f, 0x72, 0x79, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12,
+	0x35, 0x0a, 0x09, 0x70, 0x75, 0x72, 0x63, 0x68, 0x61, 0x73, 0x65, 0x73, 0x18, 0x01, 0x20, 0x03,
+	0x28, 0x0b, 0x32, 0x17, 0x2e, 0x63, 0x6f, 0x66, 0x66, 0x65, 0x65, 0x73, 0x68, 0x6f, 0x70, 0x2e,
+	0x76, 0x31, 0x2e, 0x50, 0x75, 0x72, 0x63, 0x68, 0x61, 0x73, 0x65, 0x52, 0x09, 0x70, 0x75, 0x72,
+	0x63, 0x68, 0x61, 0x73, 0x65, 0x73, 0x2a, 0x7b, 0x0a, 0x0a, 0x4d, 0x65, 0x6d, 0x62, 0x65, 0x72,
+	0x73, 0x68, 0x69, 0x70, 0x12, 0x1a, 0x0a, 0x16, 0x4d, 0x45, 0x4d, 0x42, 0x45, 0x52, 0x53, 0x48,
+	0x49, 0x50, 0x5f, 0x55, 0x4e, 0x53, 0x50, 0x45, 0x43, 0x49, 0x46, 0x49, 0x45, 0x44, 0x10, 0x00,
+	0x12, 0x14, 0x0a, 0x10, 0x4d, 0x45, 0x4d, 0x42, 0x45, 0x52, 0x53, 0x48, 0x49, 0x50, 0x5f, 0x42,
+	0x41, 0x53, 0x49, 0x43, 0x10, 0x01, 0x12, 0x1b, 0x0a, 0x17, 0x4d, 0x45, 0x4d, 0x42, 0x45, 0x52,
+	0x53, 0x48, 0x49, 0x50, 0x5f, 0x43, 0x4f, 0x46, 0x46, 0x45, 0x45, 0x5f, 0x4c, 0x4f, 0x56, 0x45,
+	0x52, 0x10, 0x02, 0x12, 0x1e, 0x0a, 0x1a, 0x4d, 0x45, 0x4d, 0x42, 0x45, 0x52, 0x53, 0x48, 0x49,
+	0x50, 0x5f, 0x45, 0x53, 0x50, 0x52, 0x45, 0x53, 0x53, 0x4f, 0x5f, 0x4d, 0x41, 0x4e, 0x49, 0x41,
+	0x43, 0x10, 0x03, 0x2a, 0x62, 0x0a, 0x06, 0x43, 0x6f, 0x66, 0x66, 0x65, 0x65, 0x12, 0x16, 0x0a,
+	0x12, 0x43, 0x4f, 0x46, 0x46, 0x45, 0x45, 0x5f, 0x55, 0x4e, 0x53, 0x50, 0x45, 0x43, 0x49, 0x46,
+	0x49, 0x45, 0x44, 0x10, 0x00, 0x12, 0x13, 0x0a, 0x0f, 0x43, 0x4f, 0x46, 0x46, 0x45, 0x45, 0x5f,
+	0x45, 0x53, 0x50, 0x52, 0x45, 0x53, 0x53, 0x4f, 0x10, 0x01, 0x12, 0x14, 0x0a, 0x10, 0x43, 0x4f,
+	0x46, 0x46, 0x45, 0x45, 0x5f, 0x41, 0x4d, 0x45, 0x52, 0x49, 0x43, 0x41, 0x4e, 0x4f, 0x10, 0x02,
+	0x12, 0x15, 0x0a, 0x11, 0x43, 0x4f, 0x46, 0x46, 0x45, 0x45, 0x5f, 0x43, 0x41, 0x50, 0x50, 0x55,
+	0x43, 0x43, 0x49, 0x4e, 0x4f, 0x10, 0x03, 0x2a, 0x53, 0x0a, 0x09, 0x55, 0x73, 0x65, 0x72, 0x53,
+	0x74, 0x61, 0x74, 0x65, 0x12, 0x15, 0x0a, 0x11, 0x55, 0x53, 0x45, 0x52, 0x5f, 0x53, 0x54, 0x41,
+	0x54, 0x45, 0x5f, 0x41, 0x43, 0x54, 0x49, 0x56, 0x45, 0x10, 0x00, 0x12, 0x18, 0x0a, 0x14, 0x55,
+	0x53, 0x45, 0x52, 0x5f, 0x53, 0x54, 0x41, 0x54, 0x45, 0x5f, 0x53, 0x55, 0x53, 0x50, 0x45, 0x4e,
+	0x44, 0x45, 0x44, 0x10, 0x01, 0x12, 0x15, 0x0a, 0x11, 0x55, 0x53, 0x45, 0x52, 0x5f, 0x53, 0x54,
+	0x41, 0x54, 0x45, 0x5f, 0x42, 0x41, 0x4e, 0x4e, 0x45, 0x44, 0x10, 0x02, 0x32, 0xa0, 0x02, 0x0a,
+	0x0a, 0x43, 0x6f, 0x66, 0x66, 0x65, 0x65, 0x53, 0x68, 0x6f, 0x70, 0x12, 0x46, 0x0a, 0x08, 0x52,
+	0x65, 0x67, 0x69, 0x73, 0x74, 0x65, 0x72, 0x12, 0x1e, 0x2e, 0x63, 0x6f, 0x66, 0x66, 0x65, 0x65,
+	0x73, 0x68, 0x6f, 0x70, 0x2e, 0x76, 0x31, 0x2e, 0x52, 0x65, 0x67, 0x69, 0x73, 0x74, 0x65, 0x72,
+	0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x1a, 0x2e, 0x63, 0x6f, 0x66, 0x66, 0x65, 0x65,
+	0x73, 0x68, 0x6f, 0x70, 0x2e, 0x76, 0x31, 0x2e, 0x51, 0x75, 0x6f, 0x74, 0x61, 0x53, 0x74, 0x61,
+	0x74, 0x75, 0x73, 0x12, 0x3c, 0x0a, 0x03, 0x42, 0x75, 0x79, 0x12, 0x19, 0x2e, 0x63, 0x6f, 0x66,
+	0x66, 0x65, 0x65, 0x73, 0x68, 0x6f, 0x70, 0x2e, 0x76, 0x31, 0x2e, 0x42, 0x75, 0x79, 0x52, 0x65,
+	0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x1a, 0x2e, 0x63, 0x6f, 0x66, 0x66, 0x65, 0x65, 0x73, 0x68,
+	0x6f, 0x70, 0x2e, 0x76, 0x31, 0x2e, 0x51, 0x75, 0x6f, 0x74, 0x61, 0x53, 0x74, 0x61, 0x74, 0x75,
+	0x73, 0x12, 0x42, 0x0a, 0x06, 0x53, 0x74, 0x61, 0x74, 0x75, 0x73, 0x12, 0x1c, 0x2e, 0x63, 0x6f,
+	0x66, 0x66, 0x65, 0x65, 0x73, 0x68, 0x6f, 0x70, 0x2e, 0x76, 0x31, 0x2e, 0x53, 0x74, 0x61, 0x74,
+	0x75, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x1a, 0x2e, 0x63, 0x6f, 0x66, 0x66,
+	0x65, 0x65, 0x73, 0x68, 0x6f, 0x70, 0x2e, 0x76, 0x31, 0x2e, 0x51, 0x75, 0x6f, 0x74, 0x61, 0x53,
+	0x74, 0x61, 0x74, 0x75, 0x73, 0x12, 0x48, 0x0a, 0x07, 0x48, 0x69, 0x73, 0x74, 0x6f, 0x72, 0x79,
+	0x12, 0x1d, 0x2e, 0x63, 0x6f, 0x66, 0x66, 0x65, 0x65, 0x73, 0x68, 0x6f, 0x70, 0x2e, 0x76, 0x31,
+	0x2e, 0x48, 0x69, 0x73, 0x74, 0x6f, 0x72, 0x79, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a,
+	0x1e, 0x2e, 0x63, 0x6f, 0x66, 0x66, 0x65, 0x65, 0x73, 0x68, 0x6f, 0x70, 0x2e, 0x76, 0x31, 0x2e,
+	0x48, 0x69, 0x73, 0x74, 0x6f, 0x72, 0x79, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x42,
+	0x15, 0x5a, 0x13, 0x43, 0x6f, 0x66, 0x66, 0x65, 0x65, 0x53, 0x68, 0x6f, 0x70, 0x2f, 0x73, 0x68,
+	0x6f, 0x70, 0x67, 0x72, 0x70, 0x63, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_coffeeshop_proto_rawDescOnce sync.Once
+	file_coffeeshop_proto_rawDescData = file_coffeeshop_proto_rawDesc
+)
+
+func file_coffeeshop_proto_rawDescGZIP() []byte {
+	file_coffeeshop_proto_rawDescOnce.Do(func() {
+		file_coffeeshop_proto_rawDescData = protoimpl.X.CompressGZIP(file_coffeeshop_proto_rawDescData)
+	})
+	return file_coffeeshop_proto_rawDescData
+}
+
+var file_coffeeshop_proto_enumTypes = make([]protoimpl.EnumInfo, 3)
+var file_coffeeshop_proto_msgTypes = make([]protoimpl.MessageInfo, 9)
+var file_coffeeshop_proto_goTypes = []interface{}{
+	(Membership)(0),         // 0: coffeeshop.v1.Membership
+	(Coffee)(0),             // 1: coffeeshop.v1.Coffee
+	(UserState)(0),          // 2: coffeeshop.v1.UserState
+	(*RegisterRequest)(nil), // 3: coffeeshop.v1.RegisterRequest
+	(*BuyRequest)(nil),      // 4: coffeeshop.v1.BuyRequest
+	(*StatusRequest)(nil),   // 5: coffeeshop.v1.StatusRequest
+	(*HistoryRequest)(nil),  // 6: coffeeshop.v1.HistoryRequest
+	(*UserStatus)(nil),      // 7: coffeeshop.v1.UserStatus
+	(*CoffeeQuota)(nil),     // 8: coffeeshop.v1.CoffeeQuota
+	(*QuotaStatus)(nil),     // 9: coffeeshop.v1.QuotaStatus
+	(*Purchase)(nil),        // 10: coffeeshop.v1.Purchase
+	(*HistoryResponse)(nil), // 11: coffeeshop.v1.HistoryResponse
+}
+var file_coffeeshop_proto_depIdxs = []int32{
+	0,  // 0: coffeeshop.v1.RegisterRequest.membership:type_name -> coffeeshop.v1.Membership
+	1,  // 1: coffeeshop.v1.BuyRequest.coffee:type_name -> coffeeshop.v1.Coffee
+	2,  // 2: coffeeshop.v1.UserStatus.state:type_name -> coffeeshop.v1.UserState
+	1,  // 3: coffeeshop.v1.CoffeeQuota.coffee:type_name -> coffeeshop.v1.Coffee
+	0,  // 4: coffeeshop.v1.QuotaStatus.membership:type_name -> coffeeshop.v1.Membership
+	7,  // 5: coffeeshop.v1.QuotaStatus.status:type_name -> coffeeshop.v1.UserStatus
+	8,  // 6: coffeeshop.v1.QuotaStatus.coffees:type_name -> coffeeshop.v1.CoffeeQuota
+	1,  // 7: coffeeshop.v1.Purchase.coffee:type_name -> coffeeshop.v1.Coffee
+	10, // 8: coffeeshop.v1.HistoryResponse.purchases:type_name -> coffeeshop.v1.Purchase
+	3,  // 9: coffeeshop.v1.CoffeeShop.Register:input_type -> coffeeshop.v1.RegisterRequest
+	4,  // 10: coffeeshop.v1.CoffeeShop.Buy:input_type -> coffeeshop.v1.BuyRequest
+	5,  // 11: coffeeshop.v1.CoffeeShop.Status:input_type -> coffeeshop.v1.StatusRequest
+	6,  // 12: coffeeshop.v1.CoffeeShop.History:input_type -> coffeeshop.v1.HistoryRequest
+	9,  // 13: coffeeshop.v1.CoffeeShop.Register:output_type -> coffeeshop.v1.QuotaStatus
+	9,  // 14: coffeeshop.v1.CoffeeShop.Buy:output_type -> coffeeshop.v1.QuotaStatus
+	9,  // 15: coffeeshop.v1.CoffeeShop.Status:output_type -> coffeeshop.v1.QuotaStatus
+	11, // 16: coffeeshop.v1.CoffeeShop.History:output_type -> coffeeshop.v1.HistoryResponse
+	13, // [13:17] is the sub-list for method output_type
+	9,  // [9:13] is the sub-list for method input_type
+	9,  // [9:9] is the sub-list for extension type_name
+	9,  // [9:9] is the sub-list for extension extendee
+	0,  // [0:9] is the sub-list for field type_name
+}
+
+func init() { file_coffeeshop_proto_init() }
+func file_coffeeshop_proto_init() {
+	if File_coffeeshop_proto != nil {
+		return
+	}
+	if !protoimpl.UnsafeEnabled {
+		file_coffeeshop_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*RegisterRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_coffeeshop_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*BuyRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_coffeeshop_proto_msgTypes[2].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*StatusRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_coffeeshop_proto_msgTypes[3].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*HistoryRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_coffeeshop_proto_msgTypes[4].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*UserStatus); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_coffeeshop_proto_msgTypes[5].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*CoffeeQuota); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_coffeeshop_proto_msgTypes[6].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*QuotaStatus); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_coffeeshop_proto_msgTypes[7].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Purchase); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_coffeeshop_proto_msgTypes[8].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*HistoryResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_coffeeshop_proto_rawDesc,
+			NumEnums:      3,
+			NumMessages:   9,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_coffeeshop_proto_goTypes,
+		DependencyIndexes: file_coffeeshop_proto_depIdxs,
+		EnumInfos:         file_coffeeshop_proto_enumTypes,
+		MessageInfos:      file_coffeeshop_proto_msgTypes,
+	}.Build()
+	File_coffeeshop_proto = out.File
+	file_coffeeshop_proto_rawDesc = nil
+	file_coffeeshop_proto_goTypes = nil
+	file_coffeeshop_proto_depIdxs = nil
+}
@@ -0,0 +1,220 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             (unknown)
+// source: coffeeshop.proto
+
+package shopgrpc
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	CoffeeShop_Register_FullMethodName = "/coffeeshop.v1.CoffeeShop/Register"
+	CoffeeShop_Buy_FullMethodName      = "/coffeeshop.v1.CoffeeShop/Buy"
+	CoffeeShop_Status_FullMethodName   = "/coffeeshop.v1.CoffeeShop/Status"
+	CoffeeShop_History_FullMethodName  = "/coffeeshop.v1.CoffeeShop/History"
+)
+
+// CoffeeShopClient is the client API for CoffeeShop service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type CoffeeShopClient interface {
+	Register(ctx context.Context, in *RegisterRequest, opts ...grpc.CallOption) (*QuotaStatus, error)
+	Buy(ctx context.Context, in *BuyRequest, opts ...grpc.CallOption) (*QuotaStatus, error)
+	Status(ctx context.Context, in *StatusRequest, opts ...grpc.CallOption) (*QuotaStatus, error)
+	History(ctx context.Context, in *HistoryRequest, opts ...grpc.CallOption) (*HistoryResponse, error)
+}
+
+type coffeeShopClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewCoffeeShopClient(cc grpc.ClientConnInterface) CoffeeShopClient {
+	return &coffeeShopClient{cc}
+}
+
+func (c *coffeeShopClient) Register(ctx context.Context, in *RegisterRequest, opts ...grpc.CallOption) (*QuotaStatus, error) {
+	out := new(QuotaStatus)
+	err := c.cc.Invoke(ctx, CoffeeShop_Register_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *coffeeShopClient) Buy(ctx context.Context, in *BuyRequest, opts ...grpc.CallOption) (*QuotaStatus, error) {
+	out := new(QuotaStatus)
+	err := c.cc.Invoke(ctx, CoffeeShop_Buy_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *coffeeShopClient) Status(ctx context.Context, in *StatusRequest, opts ...grpc.CallOption) (*QuotaStatus, error) {
+	out := new(QuotaStatus)
+	err := c.cc.Invoke(ctx, CoffeeShop_Status_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *coffeeShopClient) History(ctx context.Context, in *HistoryRequest, opts ...grpc.CallOption) (*HistoryResponse, error) {
+	out := new(HistoryResponse)
+	err := c.cc.Invoke(ctx, CoffeeShop_History_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// CoffeeShopServer is the server API for CoffeeShop service.
+// All implementations must embed UnimplementedCoffeeShopServer
+// for forward compatibility
+type CoffeeShopServer interface {
+	Register(context.Context, *RegisterRequest) (*QuotaStatus, error)
+	Buy(context.Context, *BuyRequest) (*QuotaStatus, error)
+	Status(context.Context, *StatusRequest) (*QuotaStatus, error)
+	History(context.Context, *HistoryRequest) (*HistoryResponse, error)
+	mustEmbedUnimplementedCoffeeShopServer()
+}
+
+// UnimplementedCoffeeShopServer must be embedded to have forward compatible implementations.
+type UnimplementedCoffeeShopServer struct {
+}
+
+func (UnimplementedCoffeeShopServer) Register(context.Context, *RegisterRequest) (*QuotaStatus, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Register not implemented")
+}
+func (UnimplementedCoffeeShopServer) Buy(context.Context, *BuyRequest) (*QuotaStatus, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Buy not implemented")
+}
+func (UnimplementedCoffeeShopServer) Status(context.Context, *StatusRequest) (*QuotaStatus, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Status not implemented")
+}
+func (UnimplementedCoffeeShopServer) History(context.Context, *HistoryRequest) (*HistoryResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method History not implemented")
+}
+func (UnimplementedCoffeeShopServer) mustEmbedUnimplementedCoffeeShopServer() {}
+
+// UnsafeCoffeeShopServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to CoffeeShopServer will
+// result in compilation errors.
+type UnsafeCoffeeShopServer interface {
+	mustEmbedUnimplementedCoffeeShopServer()
+}
+
+func RegisterCoffeeShopServer(s grpc.ServiceRegistrar, srv CoffeeShopServer) {
+	s.RegisterService(&CoffeeShop_ServiceDesc, srv)
+}
+
+func _CoffeeShop_Register_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RegisterRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CoffeeShopServer).Register(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CoffeeShop_Register_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CoffeeShopServer).Register(ctx, req.(*RegisterRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CoffeeShop_Buy_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BuyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CoffeeShopServer).Buy(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CoffeeShop_Buy_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CoffeeShopServer).Buy(ctx, req.(*BuyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CoffeeShop_Status_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StatusRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CoffeeShopServer).Status(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CoffeeShop_Status_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CoffeeShopServer).Status(ctx, req.(*StatusRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CoffeeShop_History_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(HistoryRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CoffeeShopServer).History(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CoffeeShop_History_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CoffeeShopServer).History(ctx, req.(*HistoryRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// CoffeeShop_ServiceDesc is the grpc.ServiceDesc for CoffeeShop service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var CoffeeShop_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "coffeeshop.v1.CoffeeShop",
+	HandlerType: (*CoffeeShopServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Register",
+			Handler:    _CoffeeShop_Register_Handler,
+		},
+		{
+			MethodName: "Buy",
+			Handler:    _CoffeeShop_Buy_Handler,
+		},
+		{
+			MethodName: "Status",
+			Handler:    _CoffeeShop_Status_Handler,
+		},
+		{
+			MethodName: "History",
+			Handler:    _CoffeeShop_History_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "coffeeshop.proto",
+}
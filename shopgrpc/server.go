@@ -0,0 +1,214 @@
+// Package shopgrpc serves register, buy, status and history operations of
+// shopapi.Shop over gRPC, coffeeshop.pb.go and coffeeshop_grpc.pb.go are
+// generated from coffeeshop.proto
+package shopgrpc
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative coffeeshop.proto
+
+import (
+	"CoffeeShop/coffeedb"
+	"CoffeeShop/logging"
+	"CoffeeShop/shopapi"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// Server CoffeeShop service of one shop
+type Server struct {
+	UnimplementedCoffeeShopServer
+	shop *shopapi.Shop
+}
+
+// NewServer creates the service of the shop
+func NewServer(shop *shopapi.Shop) *Server {
+	return &Server{shop: shop}
+}
+
+// NewGrpcServer returns a grpc server with the CoffeeShop service of the shop registered
+func NewGrpcServer(shop *shopapi.Shop, opts ...grpc.ServerOption) *grpc.Server {
+	server := grpc.NewServer(opts...)
+	RegisterCoffeeShopServer(server, NewServer(shop))
+	return server
+}
+
+// StartGrpcServer listens on addr and serves until server is stopped
+func StartGrpcServer(server *grpc.Server, addr string) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		panic(err)
+	}
+	logging.Infof("starting grpc server on %s", addr)
+	if err := server.Serve(listener); err != nil && !errors.Is(err, grpc.ErrServerStopped) {
+		panic(err)
+	}
+	logging.Infof("grpc server stopped gracefully")
+}
+
+// ShutdownGrpcServer stops server, waits for open calls until the deadline
+// of ctx and cancels the rest
+func ShutdownGrpcServer(ctx context.Context, server *grpc.Server) {
+	stopped := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		server.Stop()
+	}
+	logging.Infof("grpc server shutdown")
+}
+
+func (s *Server) Register(ctx context.Context, request *RegisterRequest) (*QuotaStatus, error) {
+	membership, err := membershipType(request.Membership)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.shop.Register(request.UserId, membership); err != nil {
+		return nil, statusError(err)
+	}
+	return s.quotaStatus(request.UserId)
+}
+
+func (s *Server) Buy(ctx context.Context, request *BuyRequest) (*QuotaStatus, error) {
+	coffee, err := coffeeType(request.Coffee)
+	if err != nil {
+		return nil, err
+	}
+	limit, err := s.shop.Buy(shopapi.CoffeeBuyInfo{UserId: request.UserId, Coffee: coffee})
+	if err != nil {
+		return nil, statusError(err)
+	}
+	if limit != nil {
+		return nil, quotaExceededError(request.UserId, limit)
+	}
+	return s.quotaStatus(request.UserId)
+}
+
+func (s *Server) Status(ctx context.Context, request *StatusRequest) (*QuotaStatus, error) {
+	return s.quotaStatus(request.UserId)
+}
+
+func (s *Server) History(ctx context.Context, request *HistoryRequest) (*HistoryResponse, error) {
+	purchases, err := s.shop.History(request.UserId)
+	if err != nil {
+		return nil, statusError(err)
+	}
+	response := HistoryResponse{Purchases: make([]*Purchase, 0, len(purchases))}
+	for _, p := range purchases {
+		response.Purchases = append(response.Purchases, &Purchase{
+			UserId:       p.UserId,
+			Coffee:       Coffee(p.Coffee),
+			Time:         p.Time,
+			Price:        p.Price,
+			Organization: p.Organization,
+			GuestCode:    p.GuestCode,
+		})
+	}
+	return &response, nil
+}
+
+func (s *Server) quotaStatus(userId string) (*QuotaStatus, error) {
+	qs, err := s.shop.Status(userId)
+	if err != nil {
+		return nil, statusError(err)
+	}
+	response := QuotaStatus{
+		UserId:     qs.UserId,
+		Membership: Membership(qs.Membership),
+		Status:     &UserStatus{State: UserState(qs.Status.Status), Until: qs.Status.Until, Reason: qs.Status.Reason},
+		Group:      qs.Group,
+		Coffees:    make([]*CoffeeQuota, 0, len(qs.Coffees)),
+	}
+	for _, cs := range qs.Coffees {
+		response.Coffees = append(response.Coffees, &CoffeeQuota{
+			Coffee:    Coffee(cs.Coffee),
+			Limit:     cs.Limit,
+			Remaining: cs.Remaining,
+			ResetAt:   cs.ResetAt,
+			Granted:   cs.Granted,
+		})
+	}
+	return &response, nil
+}
+
+// membershipType converts the request enum, values out of the range of
+// coffeedb.MembershipType are rejected instead of truncated to a valid type
+func membershipType(m Membership) (coffeedb.MembershipType, error) {
+	if m < 0 || m > math.MaxUint8 || coffeedb.MembershipType(m).IsValid() != nil {
+		return 0, invalidFieldError("membership", fmt.Errorf("%w %d", coffeedb.ErrInvalidMembership, m))
+	}
+	return coffeedb.MembershipType(m), nil
+}
+
+// coffeeType converts the request enum like membershipType
+func coffeeType(c Coffee) (coffeedb.CoffeeType, error) {
+	if c < 0 || c > math.MaxUint8 || coffeedb.CoffeeType(c).IsValid() != nil {
+		return 0, invalidFieldError("coffee", fmt.Errorf("%w %d", coffeedb.ErrInvalidCoffeeType, c))
+	}
+	return coffeedb.CoffeeType(c), nil
+}
+
+// invalidFieldError INVALID_ARGUMENT with the field as BadRequest field violation
+func invalidFieldError(field string, err error) error {
+	st := status.New(codes.InvalidArgument, err.Error())
+	withDetails, detailsErr := st.WithDetails(&errdetails.BadRequest{FieldViolations: []*errdetails.BadRequest_FieldViolation{{
+		Field:       field,
+		Description: err.Error(),
+	}}})
+	if detailsErr != nil {
+		return st.Err()
+	}
+	return withDetails.Err()
+}
+
+// statusCode returns the grpc code of an error returned by Shop methods,
+// it matches the http status of the same error
+func statusCode(err error) codes.Code {
+	var suspended *shopapi.UserSuspendedError
+	switch {
+	case errors.Is(err, coffeedb.ErrUserNotFound), errors.Is(err, coffeedb.ErrGroupNotFound):
+		return codes.NotFound
+	case errors.Is(err, coffeedb.ErrUserExists):
+		return codes.AlreadyExists
+	case errors.Is(err, shopapi.ErrEmptyUserId), errors.Is(err, shopapi.ErrInvalidUserId), errors.Is(err, coffeedb.ErrInvalidMembership), errors.Is(err, coffeedb.ErrInvalidCoffeeType):
+		return codes.InvalidArgument
+	case errors.As(err, &suspended):
+		return codes.PermissionDenied
+	case errors.Is(err, shopapi.ErrOrganizationCapReached):
+		return codes.FailedPrecondition
+	}
+	return codes.Internal
+}
+
+func statusError(err error) error {
+	return status.Error(statusCode(err), err.Error())
+}
+
+// quotaExceededError RESOURCE_EXHAUSTED with the time until the next coffee
+// as RetryInfo and the exhausted coffee type as QuotaFailure
+func quotaExceededError(userId string, limit *shopapi.CoffeeLimitExceed) error {
+	st := status.New(codes.ResourceExhausted, "limit exceeded "+limit.Type.String())
+	withDetails, err := st.WithDetails(
+		&errdetails.RetryInfo{RetryDelay: durationpb.New(time.Duration(limit.AvailableIn) * time.Second)},
+		&errdetails.QuotaFailure{Violations: []*errdetails.QuotaFailure_Violation{{
+			Subject:     "user:" + userId,
+			Description: limit.Type.String() + " quota exhausted",
+		}}},
+	)
+	if err != nil {
+		return st.Err()
+	}
+	return withDetails.Err()
+}
@@ -0,0 +1,106 @@
+package shopgrpc
+
+import (
+	"CoffeeShop/coffeedb"
+	"CoffeeShop/shopapi"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func clientSetup(t *testing.T) CoffeeShopClient {
+	db, err := coffeedb.Init(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewGrpcServer(shopapi.NewShop(db, shopapi.DefaultConfig(), nil))
+	listener := bufconn.Listen(1 << 20)
+	go server.Serve(listener)
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+		server.Stop()
+		db.Close()
+	})
+	return NewCoffeeShopClient(conn)
+}
+
+func TestGrpcService(t *testing.T) {
+	t.Parallel()
+	c := clientSetup(t)
+	ctx := context.Background()
+
+	userId := "d4f6b8a0-2c4e-4f6a-8b0d-2e4f6a8c0e2a"
+	qs, err := c.Register(ctx, &RegisterRequest{UserId: userId, Membership: Membership_MEMBERSHIP_BASIC})
+	if err != nil || qs.UserId != userId || len(qs.Coffees) != 3 {
+		t.Fatalf("register: %v %v", qs, err)
+	}
+	expectCode := func(err error, code codes.Code) {
+		t.Helper()
+		if status.Code(err) != code {
+			t.Errorf("expected %s got %v", code, err)
+		}
+	}
+	_, err = c.Register(ctx, &RegisterRequest{UserId: userId, Membership: Membership_MEMBERSHIP_BASIC})
+	expectCode(err, codes.AlreadyExists)
+	_, err = c.Register(ctx, &RegisterRequest{UserId: "other"})
+	expectCode(err, codes.InvalidArgument)
+	_, err = c.Status(ctx, &StatusRequest{UserId: "unknown"})
+	expectCode(err, codes.NotFound)
+	_, err = c.Buy(ctx, &BuyRequest{UserId: userId})
+	expectCode(err, codes.InvalidArgument)
+
+	//values out of the enum range are not truncated to a valid type
+	expectFieldViolation := func(err error, field string) {
+		t.Helper()
+		expectCode(err, codes.InvalidArgument)
+		for _, detail := range status.Convert(err).Details() {
+			if badRequest, ok := detail.(*errdetails.BadRequest); ok && len(badRequest.FieldViolations) == 1 && badRequest.FieldViolations[0].Field == field {
+				return
+			}
+		}
+		t.Errorf("expected a violation of field %s got %v", field, err)
+	}
+	_, err = c.Register(ctx, &RegisterRequest{UserId: "other", Membership: Membership(257)})
+	expectFieldViolation(err, "membership")
+	_, err = c.Status(ctx, &StatusRequest{UserId: "other"})
+	expectCode(err, codes.NotFound)
+	_, err = c.Buy(ctx, &BuyRequest{UserId: userId, Coffee: Coffee(259)})
+	expectFieldViolation(err, "coffee")
+	_, err = c.Buy(ctx, &BuyRequest{UserId: userId, Coffee: Coffee(-1)})
+	expectFieldViolation(err, "coffee")
+
+	qs, err = c.Buy(ctx, &BuyRequest{UserId: userId, Coffee: Coffee_COFFEE_ESPRESSO})
+	if err != nil || qs.Coffees[0].Coffee != Coffee_COFFEE_ESPRESSO || qs.Coffees[0].Remaining != 0 {
+		t.Fatalf("buy: %v %v", qs, err)
+	}
+	_, err = c.Buy(ctx, &BuyRequest{UserId: userId, Coffee: Coffee_COFFEE_ESPRESSO})
+	expectCode(err, codes.ResourceExhausted)
+	var retryDelay time.Duration
+	for _, detail := range status.Convert(err).Details() {
+		if retryInfo, ok := detail.(*errdetails.RetryInfo); ok {
+			retryDelay = retryInfo.RetryDelay.AsDuration()
+		}
+	}
+	if retryDelay <= 0 || retryDelay > 24*time.Hour {
+		t.Errorf("unexpected retry delay %s", retryDelay)
+	}
+
+	history, err := c.History(ctx, &HistoryRequest{UserId: userId})
+	if err != nil || len(history.Purchases) != 1 || history.Purchases[0].Coffee != Coffee_COFFEE_ESPRESSO {
+		t.Errorf("history: %v %v", history, err)
+	}
+}